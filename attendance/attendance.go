@@ -0,0 +1,121 @@
+package attendance
+
+import (
+	"errors"
+	"log"
+	"site/database/models"
+	"site/mailer"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrNoSeats = errors.New("not enough seats left")
+
+// Seats is the number of seats an attendee going to the event takes.
+func Seats(a *models.Attendee) int {
+	return 1 + a.Guests
+}
+
+// LockEvent loads the event and locks it until the transaction ends, so
+// concurrent answers can not take the same seats.
+func LockEvent(tx *gorm.DB, id uint) (models.Event, error) {
+	event := models.Event{}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Find(&event, id).Error
+	return event, err
+}
+
+// Taken counts the seats of the attendees going to the event, except the
+// ones of the user.
+func Taken(tx *gorm.DB, eventID uint, exceptUserID uint) (int, error) {
+	taken := int64(0)
+	err := tx.Model(&models.Attendee{}).
+		Select("COALESCE(SUM(1 + guests), 0)").
+		Where("event_id = ? AND status = ? AND user_id <> ?", eventID, models.AttendeeGoing, exceptUserID).
+		Scan(&taken).Error
+	return int(taken), err
+}
+
+// Fits reports whether the seats are still free. Events without a capacity
+// have room for everyone.
+func Fits(event *models.Event, taken int, seats int) bool {
+	return event.Capacity == 0 || taken+seats <= event.Capacity
+}
+
+// Position is the place of a waitlisted attendee on the waitlist, starting
+// at 1. Attendees that are not waiting have none.
+func Position(tx *gorm.DB, a *models.Attendee) (int, error) {
+	if a.Status != models.AttendeeWaitlisted || a.WaitlistedAt == nil {
+		return 0, nil
+	}
+
+	ahead := int64(0)
+	err := tx.Model(&models.Attendee{}).
+		Where("event_id = ? AND status = ?", a.EventID, models.AttendeeWaitlisted).
+		Where("waitlisted_at < ? OR (waitlisted_at = ? AND id < ?)", a.WaitlistedAt, a.WaitlistedAt, a.ID).
+		Count(&ahead).Error
+	return int(ahead) + 1, err
+}
+
+// Promote gives the free seats of the event to the waitlist in the order
+// people joined it. It stops at the first attendee that does not fit, so a
+// smaller party does not pass a larger one waiting longer. The event has to be
+// locked by the transaction.
+func Promote(tx *gorm.DB, event *models.Event) ([]models.Attendee, error) {
+	waiting := []models.Attendee{}
+	err := tx.Preload("User").
+		Where("event_id = ? AND status = ?", event.ID, models.AttendeeWaitlisted).
+		Order("waitlisted_at, id").
+		Find(&waiting).Error
+	if err != nil || len(waiting) == 0 {
+		return nil, err
+	}
+
+	taken, err := Taken(tx, event.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	promoted := []models.Attendee{}
+	for _, attendee := range waiting {
+		if !Fits(event, taken, Seats(&attendee)) {
+			break
+		}
+
+		result := tx.Model(&attendee).Updates(map[string]interface{}{
+			"status":        models.AttendeeGoing,
+			"waitlisted_at": nil,
+		})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		attendee.Status, attendee.WaitlistedAt = models.AttendeeGoing, nil
+
+		taken += Seats(&attendee)
+		promoted = append(promoted, attendee)
+	}
+	return promoted, nil
+}
+
+// Notify tells promoted attendees they have a seat now. The seats are taken
+// already, a message that can not be sent is only logged.
+func Notify(m mailer.Mailer, event *models.Event, promoted []models.Attendee) {
+	for _, attendee := range promoted {
+		if attendee.User.Email == "" {
+			continue
+		}
+		if err := m.Send(mailer.WaitlistPromotionMessage(attendee.User.Email, event.Name, event.ID)); err != nil {
+			log.Printf("Failed to notify attendee %d of event %d %s \n", attendee.ID, event.ID, err)
+		}
+	}
+}
+
+// Waitlist puts the attendee at the end of the waitlist, or keeps the place
+// of an attendee already on it.
+func Waitlist(attendee *models.Attendee, now time.Time) {
+	if attendee.Status != models.AttendeeWaitlisted || attendee.WaitlistedAt == nil {
+		attendee.WaitlistedAt = &now
+	}
+	attendee.Status = models.AttendeeWaitlisted
+}
@@ -0,0 +1,103 @@
+package attendance
+
+import (
+	"errors"
+	"site/database/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// NormalizeEmail is the form invitation emails are stored and matched in.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// FindInvitee returns the account registered with the email of the
+// invitation, if there is one. Only accounts that verified the address count,
+// registering somebody else's address does not take over their invitations.
+func FindInvitee(db *gorm.DB, invitation *models.Invitation) (*models.User, error) {
+	user := models.User{}
+	if err := db.Where("LOWER(email) = ? AND email_verified_at IS NOT NULL", invitation.Email).Limit(1).Find(&user).Error; err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+// AnswerInvitation records the answer to an invitation. When the invitee has
+// an account the answer becomes their RSVP, an accepted invitation that does
+// not fit lands on the waitlist. Only published events can be accepted, a
+// declined invitation is recorded even when the event does not take answers
+// anymore.
+func AnswerInvitation(db *gorm.DB, invitation *models.Invitation, user *models.User, status string) (Result, error) {
+	result := Result{Promoted: []models.Attendee{}}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if user == nil {
+			event := models.Event{}
+			if err := tx.Find(&event, invitation.EventID).Error; err != nil {
+				return err
+			}
+			if event.ID == 0 {
+				return ErrEventNotFound
+			}
+			if status == models.InvitationAccepted && event.Status != models.EventPublished {
+				return ErrEventClosed
+			}
+		} else {
+			answer := Answer{Status: models.AttendeeGoing}
+			if status == models.InvitationDeclined {
+				answer.Status = models.AttendeeDeclined
+			}
+
+			var err error
+			result, err = Respond(tx, invitation.EventID, user, answer)
+			if err != nil && !(status == models.InvitationDeclined && errors.Is(err, ErrEventClosed)) {
+				return err
+			}
+			invitation.UserID = &user.ID
+		}
+
+		if invitation.Status != status || invitation.RespondedAt == nil {
+			now := time.Now()
+			invitation.RespondedAt = &now
+		}
+		invitation.Status = status
+		return tx.Save(invitation).Error
+	})
+	return result, err
+}
+
+// AcceptInvitations turns the invitations accepted for the email of an
+// account into RSVPs once the account has verified it. Invitations to events
+// that do not take answers anymore stay as they are.
+func AcceptInvitations(db *gorm.DB, user *models.User) ([]Result, error) {
+	if user.EmailVerifiedAt == nil {
+		return []Result{}, nil
+	}
+
+	invitations := []models.Invitation{}
+	err := db.Where("email = ? AND status = ? AND user_id IS NULL", NormalizeEmail(user.Email), models.InvitationAccepted).
+		Order("id").
+		Find(&invitations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Result{}
+	for i := range invitations {
+		result, err := AnswerInvitation(db, &invitations[i], user, models.InvitationAccepted)
+		if errors.Is(err, ErrEventNotFound) || errors.Is(err, ErrEventClosed) {
+			continue
+		}
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
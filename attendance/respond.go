@@ -0,0 +1,125 @@
+package attendance
+
+import (
+	"encoding/json"
+	"errors"
+	"site/database/models"
+	"site/validation"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrEventNotFound = errors.New("the event can not be found")
+	ErrEventClosed   = errors.New("the event does not take answers")
+)
+
+// InvalidAttendee carries the validation errors of an answer out of its
+// transaction.
+type InvalidAttendee map[string]string
+
+func (e InvalidAttendee) Error() string {
+	return "invalid attendee"
+}
+
+// Answer is what a user answers to an event.
+type Answer struct {
+	Status  string
+	Guests  int
+	Answers map[string]string
+}
+
+// Result is the saved answer and the attendees it promoted from the waitlist,
+// who still have to be notified.
+type Result struct {
+	Attendee models.Attendee
+	Event    models.Event
+	Promoted []models.Attendee
+}
+
+// Respond saves the answer of the user while the event is locked. Going
+// attendees that do not fit are waitlisted, a going attendee asking for more
+// seats than are left keeps the old ones and gets ErrNoSeats. Only published
+// events take answers.
+func Respond(db *gorm.DB, eventID uint, user *models.User, answer Answer) (Result, error) {
+	result := Result{Promoted: []models.Attendee{}}
+	attendee := &result.Attendee
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if result.Event, err = LockEvent(tx, eventID); err != nil {
+			return err
+		}
+		if result.Event.ID == 0 {
+			return ErrEventNotFound
+		}
+		if result.Event.Status != models.EventPublished {
+			return ErrEventClosed
+		}
+
+		if err := tx.Where("event_id = ? AND user_id = ?", eventID, user.ID).Limit(1).Find(attendee).Error; err != nil {
+			return err
+		}
+
+		attendee.EventID = eventID
+		attendee.UserID = user.ID
+		attendee.User = *user
+		attendee.Answers = ""
+		if answer.Answers != nil {
+			encoded, err := json.Marshal(answer.Answers)
+			if err != nil {
+				return err
+			}
+			attendee.Answers = string(encoded)
+		}
+
+		switch {
+		case answer.Status == models.AttendeeGoing && attendee.Status == models.AttendeeGoing:
+			// a going attendee keeps the seat, even when more do not fit
+			taken, err := Taken(tx, eventID, user.ID)
+			if err != nil {
+				return err
+			}
+			if !Fits(&result.Event, taken, 1+answer.Guests) {
+				return ErrNoSeats
+			}
+			attendee.Guests = answer.Guests
+
+		case answer.Status == models.AttendeeGoing:
+			// the waitlist is served in order, Promote seats the attendee
+			// right away when nobody waits and the seats are free
+			attendee.Guests = answer.Guests
+			Waitlist(attendee, time.Now())
+
+		default:
+			attendee.Status = answer.Status
+			attendee.Guests = answer.Guests
+			attendee.WaitlistedAt = nil
+			if answer.Status == models.AttendeeDeclined {
+				attendee.Guests = 0
+			}
+		}
+
+		if errors := validation.Validate(*attendee); len(errors) != 0 {
+			return InvalidAttendee(errors)
+		}
+		if err := tx.Omit("User").Save(attendee).Error; err != nil {
+			return err
+		}
+
+		seated, err := Promote(tx, &result.Event)
+		if err != nil {
+			return err
+		}
+		for _, a := range seated {
+			if a.ID == attendee.ID {
+				attendee.Status, attendee.WaitlistedAt = a.Status, a.WaitlistedAt
+				continue
+			}
+			result.Promoted = append(result.Promoted, a)
+		}
+		return nil
+	})
+	return result, err
+}
@@ -0,0 +1,30 @@
+package audit
+
+import (
+	"site/database/models"
+
+	"gorm.io/gorm"
+)
+
+// Actions written to the audit log.
+const (
+	ActionAccountLocked   = "account.locked"
+	ActionIPLocked        = "ip.locked"
+	ActionAccountUnlocked = "account.unlocked"
+)
+
+type Logger interface {
+	Record(entry models.AuditLog) error
+}
+
+func NewGormLogger(connection *gorm.DB) *GormLogger {
+	return &GormLogger{connection: connection}
+}
+
+type GormLogger struct {
+	connection *gorm.DB
+}
+
+func (l *GormLogger) Record(entry models.AuditLog) error {
+	return l.connection.Create(&entry).Error
+}
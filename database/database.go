@@ -33,8 +33,37 @@ func CloseDatabaseConnection(connection *gorm.DB) {
 }
 
 func RunMigrations(connection *gorm.DB) error {
+	// events created before they had a status were visible, keep them so
+	statusExists := connection.Migrator().HasColumn(&models.Event{}, "Status")
 	connection.AutoMigrate(&models.Event{})
+	if !statusExists {
+		connection.Model(&models.Event{}).Where("1 = 1").Update("status", models.EventPublished)
+	}
+
+	// accounts created before emails had to be verified stay usable
+	verifiedAtExists := connection.Migrator().HasColumn(&models.User{}, "EmailVerifiedAt")
 	connection.AutoMigrate(&models.User{})
+	if !verifiedAtExists {
+		connection.Model(&models.User{}).Where("1 = 1").Update("email_verified_at", gorm.Expr("created_at"))
+	}
+
+	connection.AutoMigrate(&models.EventException{})
+	connection.AutoMigrate(&models.EventAttachment{})
+	connection.AutoMigrate(&models.Attendee{})
+	connection.AutoMigrate(&models.Invitation{})
+	connection.AutoMigrate(&models.EventCollaborator{})
 	connection.AutoMigrate(&models.Media{})
+	connection.AutoMigrate(&models.RefreshToken{})
+	connection.AutoMigrate(&models.RevokedToken{})
+	connection.AutoMigrate(&models.EmailVerification{})
+	connection.AutoMigrate(&models.PasswordReset{})
+	connection.AutoMigrate(&models.LoginAttempt{})
+	connection.AutoMigrate(&models.AuditLog{})
+	connection.AutoMigrate(&models.RecoveryCode{})
+	connection.AutoMigrate(&models.APIKey{})
+	connection.AutoMigrate(&models.OAuthState{})
+	connection.AutoMigrate(&models.ExternalIdentity{})
+	connection.AutoMigrate(&models.ExportJob{})
+	connection.AutoMigrate(&models.CalendarFeed{})
 	return nil
 }
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type APIKey struct {
+	gorm.Model
+	UserID     uint `gorm:"index"`
+	Label      string
+	Prefix     string `gorm:"size:16"`
+	KeyHash    string `gorm:"size:64;uniqueIndex"`
+	Scopes     string
+	ExpiresAt  *time.Time
+	RevokedAt  *time.Time
+	LastUsedAt *time.Time
+}
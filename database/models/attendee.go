@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AttendeeGoing      = "going"
+	AttendeeMaybe      = "maybe"
+	AttendeeDeclined   = "declined"
+	AttendeeWaitlisted = "waitlisted"
+)
+
+// Attendee is the answer of a user to an event. Going attendees take a seat
+// for themselves and one for each guest. Those who asked for seats when the
+// event was full wait in the order of WaitlistedAt. Answers is a JSON object
+// of the questions asked by the organizer and the answers.
+type Attendee struct {
+	gorm.Model
+	EventID      uint `gorm:"uniqueIndex:idx_event_attendee"`
+	UserID       uint `gorm:"uniqueIndex:idx_event_attendee"`
+	User         User
+	Status       string `gorm:"size:16" validate:"oneof=going maybe declined waitlisted"`
+	Guests       int    `validate:"min=0,max=10"`
+	Answers      string `gorm:"type:text" validate:"max=65535"`
+	WaitlistedAt *time.Time
+}
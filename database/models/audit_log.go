@@ -0,0 +1,13 @@
+package models
+
+import "gorm.io/gorm"
+
+type AuditLog struct {
+	gorm.Model
+	Action  string `gorm:"size:64;index"`
+	UserID  *uint  `gorm:"index"`
+	ActorID *uint
+	Subject string
+	IP      string `gorm:"size:64"`
+	Details string
+}
@@ -0,0 +1,13 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// CalendarFeed is the secret link a user subscribes to their events with.
+// Only the digest of the token is stored.
+type CalendarFeed struct {
+	gorm.Model
+	UserID    uint   `gorm:"uniqueIndex"`
+	TokenHash string `gorm:"size:64;uniqueIndex"`
+}
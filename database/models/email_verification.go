@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type EmailVerification struct {
+	gorm.Model
+	UserID    uint `gorm:"index"`
+	Email     string
+	TokenID   string `gorm:"size:64;uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
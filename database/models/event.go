@@ -1,9 +1,54 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	EventDraft     = "draft"
+	EventPublished = "published"
+	EventCancelled = "cancelled"
+)
+
+const (
+	// EventPrivate events are only shown to the people working on them.
+	EventPrivate = "private"
+	// EventUnlisted events are shown to anybody with their share link.
+	EventUnlisted = "unlisted"
+	// EventPublic events are shown to every user and through their share
+	// link.
+	EventPublic = "public"
+)
 
 type Event struct {
 	gorm.Model
-	Name   string `validate:"required,min=6"`
-	UserID uint
+	Name        string     `validate:"required,min=6"`
+	Description string     `gorm:"type:text" validate:"max=10000"`
+	StartsAt    *time.Time `validate:"required_with=EndsAt RecurrenceRule RecurrenceDates"`
+	EndsAt      *time.Time `validate:"omitempty,gtfield=StartsAt"`
+	TimeZone    string     `gorm:"size:64" validate:"omitempty,timezone"`
+	AllDay      bool
+	Venue       string   `validate:"max=255"`
+	Address     string   `validate:"max=500"`
+	Latitude    *float64 `validate:"omitempty,latitude,paired=Longitude"`
+	Longitude   *float64 `validate:"omitempty,longitude,paired=Latitude"`
+	Capacity    int      `validate:"min=0"`
+	Status      string   `gorm:"size:16;default:draft" validate:"omitempty,oneof=draft published cancelled"`
+	Visibility  string   `gorm:"size:16;default:private" validate:"omitempty,oneof=private unlisted public"`
+	// ShareSlug is the unguessable part of the share link of an unlisted or
+	// public event.
+	ShareSlug *string `gorm:"size:32;uniqueIndex"`
+	UserID    uint
+	// RecurrenceRule is an RFC 5545 RRULE repeating the event from StartsAt,
+	// RecurrenceDates and ExceptionDates are the RDATE and EXDATE lists of
+	// UTC date-times added to and removed from the series.
+	RecurrenceRule  string `gorm:"size:255" validate:"omitempty,rrule"`
+	RecurrenceDates string `gorm:"type:text" validate:"omitempty,datelist"`
+	ExceptionDates  string `gorm:"type:text" validate:"omitempty,datelist"`
+	// UID identifies the event in calendars. Imported events keep the UID of
+	// their source, so importing them again updates them.
+	UID         string `gorm:"size:255;index"`
+	Attachments []EventAttachment
 }
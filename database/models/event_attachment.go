@@ -0,0 +1,13 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// EventAttachment is a link to a document of an event, e.g. the agenda of an
+// imported meeting.
+type EventAttachment struct {
+	gorm.Model
+	EventID uint   `gorm:"index"`
+	URL     string `gorm:"size:2048"`
+}
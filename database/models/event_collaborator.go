@@ -0,0 +1,20 @@
+package models
+
+import "gorm.io/gorm"
+
+const (
+	CollaboratorEditor        = "editor"
+	CollaboratorMediaUploader = "media-uploader"
+	CollaboratorViewer        = "viewer"
+)
+
+// EventCollaborator shares an event with another user. Every collaborator
+// sees the event, the role decides what else the user may do with it.
+type EventCollaborator struct {
+	gorm.Model
+	EventID   uint `gorm:"uniqueIndex:idx_event_collaborator"`
+	UserID    uint `gorm:"uniqueIndex:idx_event_collaborator"`
+	User      User
+	Role      string `gorm:"size:32" validate:"oneof=editor media-uploader viewer"`
+	AddedByID uint
+}
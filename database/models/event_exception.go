@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EventException changes or cancels a single occurrence of a recurring event.
+// The occurrence is the one the series scheduled at OccurrenceAt, empty fields
+// keep the values of the event.
+type EventException struct {
+	gorm.Model
+	EventID      uint      `gorm:"uniqueIndex:idx_event_occurrence"`
+	OccurrenceAt time.Time `gorm:"uniqueIndex:idx_event_occurrence"`
+	Cancelled    bool
+	Name         string     `validate:"omitempty,min=6"`
+	Description  string     `gorm:"type:text" validate:"max=10000"`
+	StartsAt     *time.Time `validate:"required_with=EndsAt"`
+	EndsAt       *time.Time `validate:"omitempty,gtfield=StartsAt"`
+	Venue        string     `validate:"max=255"`
+	Address      string     `validate:"max=500"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ExportPending   = "pending"
+	ExportRunning   = "running"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+	ExportExpired   = "expired"
+)
+
+type ExportJob struct {
+	gorm.Model
+	UserID      uint   `gorm:"index"`
+	Status      string `gorm:"size:16;index"`
+	Path        string
+	Size        int64
+	Error       string
+	CompletedAt *time.Time
+	ExpiresAt   *time.Time
+}
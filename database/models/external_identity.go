@@ -0,0 +1,13 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+type ExternalIdentity struct {
+	gorm.Model
+	UserID   uint   `gorm:"index"`
+	Provider string `gorm:"size:64;uniqueIndex:idx_provider_subject"`
+	Subject  string `gorm:"size:255;uniqueIndex:idx_provider_subject"`
+	Email    string
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+)
+
+// Invitation asks somebody to attend an event, whether or not they have an
+// account. The emailed link carries a signed token, TokenID is its ID and
+// changes whenever the invitation is sent again. UserID is the account the
+// answer has been turned into an RSVP for.
+type Invitation struct {
+	gorm.Model
+	EventID     uint   `gorm:"uniqueIndex:idx_event_invitation"`
+	Email       string `gorm:"size:255;uniqueIndex:idx_event_invitation"`
+	InvitedByID uint
+	UserID      *uint  `gorm:"index"`
+	Status      string `gorm:"size:16;default:pending"`
+	TokenID     string `gorm:"size:64;uniqueIndex"`
+	ExpiresAt   time.Time
+	SentAt      *time.Time
+	RespondedAt *time.Time
+}
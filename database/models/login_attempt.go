@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type LoginAttempt struct {
+	gorm.Model
+	Subject     string `gorm:"size:255;uniqueIndex"`
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type OAuthState struct {
+	gorm.Model
+	StateHash    string `gorm:"size:64;uniqueIndex"`
+	Provider     string `gorm:"size:64"`
+	Nonce        string
+	CodeVerifier string
+	UserID       *uint
+	// BindingHash is the digest of the cookie of the browser that started
+	// the flow, only that browser can complete it.
+	BindingHash string `gorm:"size:64"`
+	ExpiresAt   time.Time
+	UsedAt      *time.Time
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RefreshToken struct {
+	gorm.Model
+	UserID       uint   `gorm:"index"`
+	TokenHash    string `gorm:"size:64;uniqueIndex"`
+	FamilyID     string `gorm:"size:64;index"`
+	ExpiresAt    time.Time
+	RevokedAt    *time.Time
+	ReplacedByID *uint
+}
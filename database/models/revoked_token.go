@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RevokedToken struct {
+	gorm.Model
+	JTI       string `gorm:"size:64;uniqueIndex"`
+	UserID    uint   `gorm:"index"`
+	ExpiresAt time.Time
+}
@@ -1,10 +1,26 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RoleAdmin     = "admin"
+	RoleOrganizer = "organizer"
+	RoleViewer    = "viewer"
+)
 
 type User struct {
 	gorm.Model
-	Email    string
-	Password string
-	Events   []Event
+	Email           string
+	Password        string
+	EmailVerifiedAt *time.Time
+	Role            string `gorm:"size:32;default:organizer"`
+	TokenGeneration uint
+	TOTPSecret      string `gorm:"size:64"`
+	TOTPEnabledAt   *time.Time
+	TOTPLastStep    int64
+	Events          []Event
 }
@@ -0,0 +1,148 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"site/database/models"
+	"site/uploader"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Profile is the account as it appears in an export. Secrets are left out.
+type Profile struct {
+	ID               uint       `json:"id"`
+	Email            string     `json:"email"`
+	Role             string     `json:"role"`
+	EmailVerifiedAt  *time.Time `json:"email_verified_at"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// Media is the metadata of an uploaded file. File is the location of its
+// content inside the archive, empty when the file could not be read.
+type Media struct {
+	ID        uint      `json:"id"`
+	EventID   uint      `json:"event_id"`
+	Name      string    `json:"name"`
+	Size      int       `json:"size"`
+	Order     int       `json:"order"`
+	CreatedAt time.Time `json:"created_at"`
+	File      string    `json:"file"`
+}
+
+// writeArchive writes the profile, events and media of the user into a ZIP
+// archive. Media files that can not be read, or that media of other users
+// points at as well, are listed in the metadata without a file instead of
+// failing the export.
+func writeArchive(w io.Writer, connection *gorm.DB, files uploader.Uploader, user *models.User) error {
+	archive := zip.NewWriter(w)
+
+	err := writeJSON(archive, "profile.json", Profile{
+		ID:               user.ID,
+		Email:            user.Email,
+		Role:             user.Role,
+		EmailVerifiedAt:  user.EmailVerifiedAt,
+		TwoFactorEnabled: user.TOTPEnabledAt != nil,
+		CreatedAt:        user.CreatedAt,
+		UpdatedAt:        user.UpdatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	events := []models.Event{}
+	if err := connection.Where("user_id = ?", user.ID).Order("id").Find(&events).Error; err != nil {
+		return err
+	}
+
+	if err := writeJSON(archive, "events.json", events); err != nil {
+		return err
+	}
+
+	stored := []models.Media{}
+	err = connection.Where("event_id IN (?)", connection.Model(&models.Event{}).Select("id").Where("user_id = ?", user.ID)).
+		Order("id").Find(&stored).Error
+	if err != nil {
+		return err
+	}
+
+	media := make([]Media, 0, len(stored))
+	for _, m := range stored {
+		exported := Media{
+			ID:        m.ID,
+			EventID:   m.EventId,
+			Name:      m.Name,
+			Size:      m.Size,
+			Order:     m.Order,
+			CreatedAt: m.CreatedAt,
+		}
+
+		shared, err := sharedWithOthers(connection, user, m.Path)
+		if err != nil {
+			return err
+		}
+
+		name := fmt.Sprintf("media/%d-%s", m.ID, path.Base("/"+m.Name))
+		if !shared {
+			if err := copyFile(archive, files, m.Path, name); err == nil {
+				exported.File = name
+			}
+		}
+
+		media = append(media, exported)
+	}
+
+	if err := writeJSON(archive, "media.json", media); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// sharedWithOthers reports whether media of another user's event points at
+// the file. Uploads made before they had names of their own share one file,
+// whose content may be anybody's.
+func sharedWithOthers(connection *gorm.DB, user *models.User, file string) (bool, error) {
+	var count int64
+	err := connection.Unscoped().Model(&models.Media{}).
+		Where("path = ? AND event_id NOT IN (?)", file, connection.Unscoped().Model(&models.Event{}).Select("id").Where("user_id = ?", user.ID)).
+		Count(&count).Error
+	return count != 0, err
+}
+
+func writeJSON(archive *zip.Writer, name string, value interface{}) error {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func copyFile(archive *zip.Writer, files uploader.Uploader, source string, name string) error {
+	if source == "" {
+		return fmt.Errorf("%s has no file", name)
+	}
+
+	file, err := files.Open(source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, file)
+	return err
+}
@@ -0,0 +1,194 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"site/database/models"
+	"site/uploader"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Retention is how long a finished export can be downloaded.
+	Retention = 7 * 24 * time.Hour
+	// sweepInterval is how often pending jobs are picked up and expired
+	// archives removed, in case a job was not handed to the worker directly.
+	sweepInterval = time.Minute
+)
+
+var errJobTaken = errors.New("the export job is not pending")
+
+// Queue accepts export jobs to be processed in the background.
+type Queue interface {
+	Enqueue(jobID uint)
+}
+
+func NewWorker(connection *gorm.DB, files uploader.Uploader, dir string) *Worker {
+	return &Worker{
+		connection: connection,
+		files:      files,
+		dir:        dir,
+		jobs:       make(chan uint, 100),
+		now:        time.Now,
+	}
+}
+
+// Worker builds export archives one at a time. Jobs live in the database, so
+// pending jobs survive a restart and are picked up by the next sweep.
+type Worker struct {
+	connection *gorm.DB
+	files      uploader.Uploader
+	dir        string
+	jobs       chan uint
+	now        func() time.Time
+}
+
+// Enqueue hands a job to the running worker without blocking. A job that does
+// not fit into the queue stays pending until the next sweep.
+func (w *Worker) Enqueue(jobID uint) {
+	select {
+	case w.jobs <- jobID:
+	default:
+	}
+}
+
+// Start processes jobs until the context is cancelled. Jobs left running by
+// a previous process are started over.
+func (w *Worker) Start(ctx context.Context) {
+	w.connection.Model(&models.ExportJob{}).Where("status = ?", models.ExportRunning).Update("status", models.ExportPending)
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	w.sweep()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case jobID := <-w.jobs:
+			if err := w.Process(jobID); err != nil && !errors.Is(err, errJobTaken) {
+				log.Printf("Failed to export job %d %s \n", jobID, err)
+			}
+		case <-ticker.C:
+			w.sweep()
+		}
+	}
+}
+
+func (w *Worker) sweep() {
+	pending := []models.ExportJob{}
+	w.connection.Where("status = ?", models.ExportPending).Order("id").Find(&pending)
+	for _, job := range pending {
+		if err := w.Process(job.ID); err != nil && !errors.Is(err, errJobTaken) {
+			log.Printf("Failed to export job %d %s \n", job.ID, err)
+		}
+	}
+
+	if err := w.Cleanup(); err != nil {
+		log.Printf("Failed to remove expired exports %s \n", err)
+	}
+}
+
+// Process builds the archive of a pending job. Failures are stored on the job.
+func (w *Worker) Process(jobID uint) error {
+	result := w.connection.Model(&models.ExportJob{}).
+		Where("id = ? AND status = ?", jobID, models.ExportPending).
+		Update("status", models.ExportRunning)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return errJobTaken
+	}
+
+	job := models.ExportJob{}
+	w.connection.First(&job, jobID)
+
+	path, size, err := w.build(&job)
+	if err != nil {
+		w.connection.Model(&job).Updates(map[string]interface{}{
+			"status": models.ExportFailed,
+			"error":  err.Error(),
+		})
+		return err
+	}
+
+	now := w.now()
+	return w.connection.Model(&job).Updates(map[string]interface{}{
+		"status":       models.ExportCompleted,
+		"path":         path,
+		"size":         size,
+		"completed_at": now,
+		"expires_at":   now.Add(Retention),
+	}).Error
+}
+
+// build writes the archive to a temporary file first, so a download never
+// sees a partial archive.
+func (w *Worker) build(job *models.ExportJob) (string, int64, error) {
+	user := models.User{}
+	w.connection.Find(&user, job.UserID)
+	if user.ID == 0 {
+		return "", 0, fmt.Errorf("the user %d does not exist", job.UserID)
+	}
+
+	if err := os.MkdirAll(w.dir, 0700); err != nil {
+		return "", 0, err
+	}
+
+	file, err := ioutil.TempFile(w.dir, "export-*.tmp")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(file.Name())
+
+	if err := writeArchive(file, w.connection, w.files, &user); err != nil {
+		file.Close()
+		return "", 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return "", 0, err
+	}
+
+	if err := file.Close(); err != nil {
+		return "", 0, err
+	}
+
+	path := filepath.Join(w.dir, fmt.Sprintf("export-%d.zip", job.ID))
+	if err := os.Rename(file.Name(), path); err != nil {
+		return "", 0, err
+	}
+
+	return path, info.Size(), nil
+}
+
+// Cleanup removes the archives of expired exports.
+func (w *Worker) Cleanup() error {
+	expired := []models.ExportJob{}
+	result := w.connection.Where("status = ? AND expires_at < ?", models.ExportCompleted, w.now()).Find(&expired)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	for _, job := range expired {
+		if err := os.Remove(job.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := w.connection.Model(&job).Updates(map[string]interface{}{"status": models.ExportExpired, "path": ""}).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
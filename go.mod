@@ -6,13 +6,13 @@ require (
 	github.com/DATA-DOG/go-txdb v0.1.5 // indirect
 	github.com/go-playground/validator v9.31.0+incompatible
 	github.com/go-playground/validator/v10 v10.10.0
-	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
-	github.com/gorilla/mux v1.8.0 // indirect
+	github.com/golang-jwt/jwt v3.2.2+incompatible
+	github.com/gorilla/mux v1.8.0
 	github.com/jinzhu/now v1.1.4 // indirect
 	golang.org/x/crypto v0.0.0-20211215153901-e495a2d5b3d3
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 	gorm.io/driver/mysql v1.2.2
-	gorm.io/driver/sqlite v1.2.6 // indirect
+	gorm.io/driver/sqlite v1.2.6
 	gorm.io/gorm v1.22.4
 	modernc.org/ql v1.4.1 // indirect
 )
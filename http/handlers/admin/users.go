@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"net/http"
+	"site/database/models"
+	"site/http/middlewares"
+	"site/http/responses"
+	"site/lockout"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// UnlockUser lifts the login lockout of the {user} account.
+func UnlockUser(connection *gorm.DB, guard *lockout.Guard) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		userId, err := strconv.Atoi(mux.Vars(r)["user"])
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+			return
+		}
+
+		user := models.User{}
+		result := connection.Find(&user, userId)
+		if result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if user.ID == 0 {
+			responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+			return
+		}
+
+		actor, _ := middlewares.CurrentUser(r)
+		if err := guard.Unlock(user.Email, actor); err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	})
+}
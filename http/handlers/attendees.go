@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"site/attendance"
+	"site/database/models"
+	"site/http/middlewares"
+	"site/http/pagination"
+	"site/http/responses"
+	"site/mailer"
+	"site/validation"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RSVPRequest is the answer of the authenticated user to an event. Going
+// attendees may bring guests, Answers holds the replies to the questions of
+// the organizer.
+type RSVPRequest struct {
+	Status  string            `json:"status" validate:"required,oneof=going maybe declined"`
+	Guests  int               `json:"guests" validate:"min=0,max=10"`
+	Answers map[string]string `json:"answers" validate:"max=20,dive,keys,min=1,max=255,endkeys,max=2000"`
+}
+
+type AttendeeResponse struct {
+	ID               uint              `json:"id"`
+	EventID          uint              `json:"event_id"`
+	UserID           uint              `json:"user_id"`
+	Email            string            `json:"email,omitempty"`
+	Status           string            `json:"status"`
+	Guests           int               `json:"guests"`
+	Answers          map[string]string `json:"answers"`
+	WaitlistedAt     *time.Time        `json:"waitlisted_at,omitempty"`
+	WaitlistPosition int               `json:"waitlist_position,omitempty"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+}
+
+func newAttendeeResponse(a *models.Attendee) AttendeeResponse {
+	answers := map[string]string{}
+	if a.Answers != "" {
+		json.Unmarshal([]byte(a.Answers), &answers)
+	}
+
+	return AttendeeResponse{
+		ID:           a.ID,
+		EventID:      a.EventID,
+		UserID:       a.UserID,
+		Email:        a.User.Email,
+		Status:       a.Status,
+		Guests:       a.Guests,
+		Answers:      answers,
+		WaitlistedAt: a.WaitlistedAt,
+		CreatedAt:    a.CreatedAt,
+		UpdatedAt:    a.UpdatedAt,
+	}
+}
+
+// attendeeListOptions are the sort keys of the attendee list.
+var attendeeListOptions = pagination.Options{
+	Sorts: map[string]pagination.Column{
+		"created_at": {Name: "created_at", Field: "CreatedAt", Time: true},
+		"id":         {Name: "id", Field: "ID"},
+	},
+	DefaultSort: "created_at",
+}
+
+// RSVP shows the answer of the authenticated user to an event on GET, answers
+// on PUT and withdraws the answer on DELETE. Going attendees that do not fit
+// are waitlisted and get the seats that free up in the order they asked for
+// them. Only published events take answers, and only from those who may see
+// the event or have answered already, e.g. through an invitation.
+func RSVP(connection *gorm.DB, m mailer.Mailer) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		event, ok := findEvent(rw, r, connection, false, func(p *middlewares.Principal, e *models.Event, role string) bool {
+			if canViewEvent(p, e, role) {
+				return true
+			}
+			var answered int64
+			connection.Model(&models.Attendee{}).Where("event_id = ? AND user_id = ?", e.ID, p.User.ID).Count(&answered)
+			return answered != 0
+		}, http.StatusNotFound)
+		if !ok {
+			return
+		}
+		eventId := event.ID
+
+		user, ok := middlewares.CurrentUser(r)
+		if !ok {
+			responses.NewJsonResponse(rw, http.StatusUnauthorized, nil)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			attendee := models.Attendee{}
+			result := connection.Preload("User").Where("event_id = ? AND user_id = ?", eventId, user.ID).Limit(1).Find(&attendee)
+			if result.Error != nil {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+			if attendee.ID == 0 {
+				responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+				return
+			}
+			writeAttendee(rw, connection, &attendee)
+
+		case http.MethodPut:
+			request := RSVPRequest{}
+			if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, maxEventBodySize)).Decode(&request); err != nil {
+				responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, nil)
+				return
+			}
+
+			errors := validation.Validate(request)
+			if len(errors) != 0 {
+				responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, errors)
+				return
+			}
+
+			result, err := attendance.Respond(connection, eventId, user, attendance.Answer{
+				Status:  request.Status,
+				Guests:  request.Guests,
+				Answers: request.Answers,
+			})
+			if err != nil {
+				answerFailed(rw, err)
+				return
+			}
+
+			attendance.Notify(m, &result.Event, result.Promoted)
+			writeAttendee(rw, connection, &result.Attendee)
+
+		case http.MethodDelete:
+			locked := models.Event{}
+			promoted := []models.Attendee{}
+			deleted := false
+			err := connection.Transaction(func(tx *gorm.DB) error {
+				var err error
+				if locked, err = attendance.LockEvent(tx, eventId); err != nil {
+					return err
+				}
+
+				// unscoped, the unique index would keep a soft deleted answer
+				result := tx.Unscoped().Where("event_id = ? AND user_id = ?", eventId, user.ID).Delete(&models.Attendee{})
+				if result.Error != nil || result.RowsAffected == 0 {
+					return result.Error
+				}
+				deleted = true
+
+				if locked.ID == 0 {
+					return nil
+				}
+				promoted, err = attendance.Promote(tx, &locked)
+				return err
+			})
+			if err != nil {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+			if !deleted {
+				responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+				return
+			}
+
+			attendance.Notify(m, &locked, promoted)
+			rw.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
+
+// answerFailed responds to an answer attendance.Respond did not save.
+func answerFailed(rw http.ResponseWriter, err error) {
+	fields := attendance.InvalidAttendee{}
+	switch {
+	case errors.Is(err, attendance.ErrEventNotFound):
+		responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+	case errors.Is(err, attendance.ErrEventClosed):
+		responses.NewJsonResponse(rw, http.StatusConflict, map[string]string{
+			"error": "The event does not take answers!",
+		})
+	case errors.Is(err, attendance.ErrNoSeats):
+		responses.NewJsonResponse(rw, http.StatusConflict, map[string]string{
+			"error": "Not enough seats are left!",
+		})
+	case errors.As(err, &fields):
+		responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, fields)
+	default:
+		responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+	}
+}
+
+func writeAttendee(rw http.ResponseWriter, connection *gorm.DB, attendee *models.Attendee) {
+	position, err := attendance.Position(connection, attendee)
+	if err != nil {
+		responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	response := newAttendeeResponse(attendee)
+	response.WaitlistPosition = position
+	responses.NewJsonResponse(rw, http.StatusOK, response)
+}
+
+// GetAttendees lists the attendees of an event to its organizer a page at a
+// time. The list can be filtered by status.
+func GetAttendees(connection *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		event, ok := findManagedEvent(rw, r, connection, false)
+		if !ok {
+			return
+		}
+
+		query, err := pagination.Parse(r, attendeeListOptions)
+		if err != nil {
+			invalidListParameter(rw, err)
+			return
+		}
+
+		filtered, err := filterAttendees(r, connection.Model(&models.Attendee{}).Where("event_id = ?", event.ID))
+		if err != nil {
+			invalidListParameter(rw, err)
+			return
+		}
+
+		attendees := []models.Attendee{}
+		page, err := pagination.Find(filtered.Preload("User"), query, &attendees)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		data := make([]AttendeeResponse, len(attendees))
+		for i := range attendees {
+			data[i] = newAttendeeResponse(&attendees[i])
+		}
+		page.Data = data
+
+		pagination.SetLinks(rw, r, page)
+		responses.NewJsonResponse(rw, http.StatusOK, page)
+	})
+}
+
+// filterAttendees applies the "status" query parameter.
+func filterAttendees(r *http.Request, query *gorm.DB) (*gorm.DB, error) {
+	status, err := pagination.OneOfParam(r, "status",
+		models.AttendeeGoing, models.AttendeeMaybe, models.AttendeeDeclined, models.AttendeeWaitlisted)
+	if err != nil {
+		return nil, err
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	return query, nil
+}
+
+// ExportAttendees sends the attendees of an event to its organizer as CSV.
+// Every question answered by somebody gets a column.
+func ExportAttendees(connection *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		event, ok := findManagedEvent(rw, r, connection, false)
+		if !ok {
+			return
+		}
+
+		filtered, err := filterAttendees(r, connection.Where("event_id = ?", event.ID))
+		if err != nil {
+			invalidListParameter(rw, err)
+			return
+		}
+
+		attendees := []models.Attendee{}
+		if result := filtered.Preload("User").Order("created_at, id").Find(&attendees); result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		rows := make([]AttendeeResponse, len(attendees))
+		questions := []string{}
+		asked := map[string]bool{}
+		for i := range attendees {
+			rows[i] = newAttendeeResponse(&attendees[i])
+			for question := range rows[i].Answers {
+				if !asked[question] {
+					asked[question] = true
+					questions = append(questions, question)
+				}
+			}
+		}
+		sort.Strings(questions)
+
+		rw.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		rw.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="event-%d-attendees.csv"`, event.ID))
+		rw.WriteHeader(http.StatusOK)
+
+		writer := csv.NewWriter(rw)
+		header := []string{"id", "user_id", "email", "status", "guests", "waitlisted_at", "created_at"}
+		for _, question := range questions {
+			header = append(header, csvCell(question))
+		}
+		writer.Write(header)
+		for _, row := range rows {
+			waitlistedAt := ""
+			if row.WaitlistedAt != nil {
+				waitlistedAt = row.WaitlistedAt.UTC().Format(time.RFC3339)
+			}
+
+			record := []string{
+				strconv.Itoa(int(row.ID)),
+				strconv.Itoa(int(row.UserID)),
+				csvCell(row.Email),
+				row.Status,
+				strconv.Itoa(row.Guests),
+				waitlistedAt,
+				row.CreatedAt.UTC().Format(time.RFC3339),
+			}
+			for _, question := range questions {
+				record = append(record, csvCell(row.Answers[question]))
+			}
+			writer.Write(record)
+		}
+		writer.Flush()
+	})
+}
+
+// csvCell keeps spreadsheets from running text entered by users as a formula.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
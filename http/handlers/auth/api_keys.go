@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"site/database/models"
+	"site/http/middlewares"
+	"site/http/responses"
+	"site/policy"
+	"site/security"
+	"site/validation"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type APIKeyRequest struct {
+	Label     string     `json:"label" validate:"required,max=100"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type APIKeyUpdateRequest struct {
+	Label string `json:"label" validate:"required,max=100"`
+}
+
+type APIKeyResponse struct {
+	ID         uint       `json:"id"`
+	Label      string     `json:"label"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// CreatedAPIKeyResponse is the only response that ever contains the key.
+type CreatedAPIKeyResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+func newAPIKeyResponse(apiKey *models.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         apiKey.ID,
+		Label:      apiKey.Label,
+		Prefix:     apiKey.Prefix,
+		Scopes:     security.APIKeyScopes(apiKey),
+		CreatedAt:  apiKey.CreatedAt,
+		ExpiresAt:  apiKey.ExpiresAt,
+		LastUsedAt: apiKey.LastUsedAt,
+		RevokedAt:  apiKey.RevokedAt,
+	}
+}
+
+// APIKeys lists the keys of the user on GET and creates one on POST.
+func APIKeys(connection *gorm.DB, keys security.APIKeys) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		user, ok := middlewares.InteractiveUser(rw, r)
+		if !ok {
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			apiKeys := []models.APIKey{}
+			result := connection.Where("user_id = ?", user.ID).Order("id").Find(&apiKeys)
+			if result.Error != nil {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+
+			response := make([]APIKeyResponse, len(apiKeys))
+			for i := range apiKeys {
+				response[i] = newAPIKeyResponse(&apiKeys[i])
+			}
+
+			responses.NewJsonResponse(rw, http.StatusOK, response)
+			return
+		}
+
+		var request APIKeyRequest
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, struct{}{})
+			return
+		}
+
+		validationErrors := validation.Validate(request)
+		for _, scope := range request.Scopes {
+			if !policy.Can(user, policy.Permission(scope)) {
+				validationErrors["Scopes"] = "permission"
+			}
+		}
+		if request.ExpiresAt != nil && request.ExpiresAt.Before(time.Now()) {
+			validationErrors["ExpiresAt"] = "future"
+		}
+		if len(validationErrors) != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, validationErrors)
+			return
+		}
+
+		key, apiKey, err := keys.Create(user.ID, request.Label, request.Scopes, request.ExpiresAt)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusCreated, CreatedAPIKeyResponse{
+			APIKeyResponse: newAPIKeyResponse(apiKey),
+			Key:            key,
+		})
+	})
+}
+
+// APIKey relabels the {key} API key on PATCH and revokes it on DELETE.
+func APIKey(connection *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch && r.Method != http.MethodDelete {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		user, ok := middlewares.InteractiveUser(rw, r)
+		if !ok {
+			return
+		}
+
+		keyId, err := strconv.Atoi(mux.Vars(r)["key"])
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+			return
+		}
+
+		apiKey := models.APIKey{}
+		result := connection.Where("id = ? AND user_id = ?", keyId, user.ID).Limit(1).Find(&apiKey)
+		if result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if apiKey.ID == 0 {
+			responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			if apiKey.RevokedAt == nil {
+				now := time.Now()
+				apiKey.RevokedAt = &now
+			}
+		} else {
+			var request APIKeyUpdateRequest
+			defer r.Body.Close()
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, struct{}{})
+				return
+			}
+
+			validationErrors := validation.Validate(request)
+			if len(validationErrors) != 0 {
+				responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, validationErrors)
+				return
+			}
+
+			apiKey.Label = request.Label
+		}
+
+		result = connection.Model(&apiKey).Select("label", "revoked_at").Updates(&apiKey)
+		if result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, newAPIKeyResponse(&apiKey))
+	})
+}
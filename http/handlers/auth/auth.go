@@ -3,11 +3,17 @@ package auth
 import (
 	"encoding/json"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"site/database/models"
 	"site/http/responses"
+	"site/lockout"
+	"site/mailer"
+	"site/passwords"
 	"site/security"
 	"site/validation"
+	"strconv"
 
 	"github.com/go-playground/validator"
 	"golang.org/x/crypto/bcrypt"
@@ -16,8 +22,8 @@ import (
 
 type PossibleUser struct {
 	Name                 string `validate:"required,min=6,email"`
-	Password             string `validate:"required,min=6,eqcsfield=PasswordConfirmation"`
-	PasswordConfirmation string `validate:"required,min=6"`
+	Password             string `validate:"required,eqcsfield=PasswordConfirmation"`
+	PasswordConfirmation string `validate:"required"`
 }
 
 type UserLogin struct {
@@ -25,7 +31,16 @@ type UserLogin struct {
 	Password string `validate:"required"`
 }
 
-func Register(connection *gorm.DB) http.Handler {
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// Register creates an unverified account and mails a verification link to it.
+// The password has to satisfy the password policy.
+func Register(connection *gorm.DB, tokens security.PurposeTokens, m mailer.Mailer, checker passwords.Validator) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			rw.WriteHeader(http.StatusMethodNotAllowed)
@@ -40,6 +55,11 @@ func Register(connection *gorm.DB) http.Handler {
 		}
 
 		validationErrors := validation.Validate(user)
+		if err := validatePassword(validationErrors, checker, "Password", user.Password, user.Name); err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
 		if len(validationErrors) != 0 {
 			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, validationErrors)
 			return
@@ -71,11 +91,20 @@ func Register(connection *gorm.DB) http.Handler {
 			return
 		}
 
-		responses.NewJsonResponse(rw, http.StatusOK, modelUser)
+		// the account exists already, a failed delivery can be retried
+		// through the resend endpoint
+		if err := sendVerification(connection, tokens, m, &modelUser, modelUser.Email); err != nil {
+			log.Printf("Failed to send the verification email %s \n", err)
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, newUserResponse(&modelUser))
 	})
 }
 
-func Login(connection *gorm.DB, t security.TokenSecurity) http.Handler {
+// Login exchanges credentials for tokens. Attempts are throttled per account
+// and per client IP by the guard, which counts every attempt before the
+// password is checked and takes it back when the login succeeds.
+func Login(connection *gorm.DB, t security.TokenSecurity, refreshTokens security.RefreshTokens, guard lockout.LoginGuard) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
@@ -98,6 +127,21 @@ func Login(connection *gorm.DB, t security.TokenSecurity) http.Handler {
 			return
 		}
 
+		ip := clientIP(r)
+		wait, err := guard.Attempt(user.Name, ip)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if wait > 0 {
+			rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			responses.NewJsonResponse(rw, http.StatusTooManyRequests, map[string]string{
+				"error": "Too many failed login attempts!",
+			})
+			return
+		}
+
 		userCheck := models.User{}
 		connection.Find(&userCheck, "email=?", user.Name)
 
@@ -117,14 +161,68 @@ func Login(connection *gorm.DB, t security.TokenSecurity) http.Handler {
 			return
 		}
 
-		tokenString, err := t.CreateToken(&userCheck)
+		if err := guard.Success(user.Name, ip); err != nil {
+			log.Printf("Failed to reset failed logins %s \n", err)
+		}
+
+		if userCheck.EmailVerifiedAt == nil {
+			responses.NewJsonResponse(rw, http.StatusForbidden, map[string]string{
+				"error": "The email address is not verified!",
+				"code":  "email_not_verified",
+			})
+			return
+		}
+
+		if userCheck.TOTPEnabledAt != nil {
+			challenge, err := newMFAChallenge(t, &userCheck)
+			if err != nil {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+
+			responses.NewJsonResponse(rw, http.StatusOK, challenge)
+			return
+		}
+
+		response, err := issueTokens(&userCheck, t, refreshTokens)
 		if err != nil {
 			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
 			return
 		}
 
-		responses.NewJsonResponse(rw, http.StatusOK, map[string]string{
-			"token": tokenString,
-		})
+		responses.NewJsonResponse(rw, http.StatusOK, response)
 	})
 }
+
+// clientIP returns the address of the connected client. Forwarding headers are
+// ignored since any client can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func issueTokens(u *models.User, t security.TokenSecurity, refreshTokens security.RefreshTokens) (TokenResponse, error) {
+	tokenString, err := t.CreateToken(u)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	refreshToken, err := refreshTokens.Issue(u)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	return newTokenResponse(t, tokenString, refreshToken), nil
+}
+
+func newTokenResponse(t security.TokenSecurity, token string, refreshToken string) TokenResponse {
+	return TokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(t.TTL().Seconds()),
+	}
+}
@@ -0,0 +1,21 @@
+package auth
+
+import (
+	"net/http"
+	"site/http/responses"
+	"site/security"
+)
+
+// JWKS publishes the public signing keys as a JSON Web Key Set so other
+// services can verify the tokens issued here.
+func JWKS(keys security.KeyPublisher) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		rw.Header().Set("Cache-Control", "public, max-age=300")
+		responses.NewJsonResponse(rw, http.StatusOK, keys.JWKS())
+	})
+}
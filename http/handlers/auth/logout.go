@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"site/http/middlewares"
+	"site/http/responses"
+	"site/security"
+)
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Logout revokes the access token used for the request and, when it is sent
+// along, the refresh token of the same session.
+func Logout(t security.TokenSecurity, refreshTokens security.RefreshTokens) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		var request LogoutRequest
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, struct{}{})
+			return
+		}
+
+		principal, ok := middlewares.PrincipalFromContext(r.Context())
+		if !ok {
+			responses.NewJsonResponse(rw, http.StatusUnauthorized, nil)
+			return
+		}
+
+		if err := t.Revoke(principal.Token); err != nil {
+			if errors.Is(err, security.ErrInvalidToken) {
+				responses.NewJsonResponse(rw, http.StatusUnauthorized, nil)
+				return
+			}
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if request.RefreshToken != "" {
+			err := refreshTokens.Revoke(request.RefreshToken)
+			if err != nil && !errors.Is(err, security.ErrInvalidRefreshToken) {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// LogoutAll revokes every access and refresh token issued to the user.
+func LogoutAll(t security.TokenSecurity, refreshTokens security.RefreshTokens) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		user, ok := middlewares.InteractiveUser(rw, r)
+		if !ok {
+			return
+		}
+
+		if err := t.RevokeAll(user.ID); err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if err := refreshTokens.RevokeUser(user.ID); err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	})
+}
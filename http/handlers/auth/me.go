@@ -0,0 +1,355 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"site/attendance"
+	"site/database/models"
+	"site/http/middlewares"
+	"site/http/responses"
+	"site/mailer"
+	"site/media"
+	"site/passwords"
+	"site/security"
+	"site/uploader"
+	"site/validation"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// UserResponse is the public representation of an account. It never contains
+// secrets like the password hash or the TOTP secret.
+type UserResponse struct {
+	ID               uint      `json:"id"`
+	Email            string    `json:"email"`
+	PendingEmail     string    `json:"pending_email,omitempty"`
+	Role             string    `json:"role"`
+	EmailVerified    bool      `json:"email_verified"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	HasPassword      bool      `json:"has_password"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type UpdateProfileRequest struct {
+	Email                string `json:"email" validate:"omitempty,email"`
+	Password             string `json:"password" validate:"omitempty,eqcsfield=PasswordConfirmation"`
+	PasswordConfirmation string `json:"password_confirmation"`
+	CurrentPassword      string `json:"current_password"`
+}
+
+type DeleteAccountRequest struct {
+	CurrentPassword string `json:"current_password"`
+}
+
+func newUserResponse(u *models.User) UserResponse {
+	role := u.Role
+	if role == "" {
+		role = models.RoleOrganizer
+	}
+
+	return UserResponse{
+		ID:               u.ID,
+		Email:            u.Email,
+		Role:             role,
+		EmailVerified:    u.EmailVerifiedAt != nil,
+		TwoFactorEnabled: u.TOTPEnabledAt != nil,
+		HasPassword:      u.Password != "",
+		CreatedAt:        u.CreatedAt,
+	}
+}
+
+// pendingEmail returns the address an email change waits to be confirmed for.
+func pendingEmail(connection *gorm.DB, u *models.User) string {
+	verification := models.EmailVerification{}
+	connection.Where("user_id = ? AND used_at IS NULL AND expires_at > ? AND email <> ?", u.ID, time.Now(), u.Email).
+		Order("created_at desc").Limit(1).Find(&verification)
+	return verification.Email
+}
+
+// currentPasswordMatches confirms sensitive changes. Accounts created through
+// a provider have no password to confirm with.
+func currentPasswordMatches(u *models.User, password string) bool {
+	if u.Password == "" {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) == nil
+}
+
+// Me shows the account of the authenticated user on GET, changes its email or
+// password on PATCH and deletes it on DELETE. Changes require the current
+// password. A new email only replaces the old one once the link mailed to it
+// has been followed, and a new password signs the user out of every session.
+func Me(connection *gorm.DB, t security.TokenSecurity, refreshTokens security.RefreshTokens, m mailer.Mailer, files uploader.Uploader, checker passwords.Validator) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			user, ok := middlewares.CurrentUser(r)
+			if !ok {
+				responses.NewJsonResponse(rw, http.StatusUnauthorized, nil)
+				return
+			}
+
+			response := newUserResponse(user)
+			response.PendingEmail = pendingEmail(connection, user)
+			responses.NewJsonResponse(rw, http.StatusOK, response)
+
+		case http.MethodPatch:
+			user, ok := middlewares.InteractiveUser(rw, r)
+			if !ok {
+				return
+			}
+			updateProfile(rw, r, connection, t, refreshTokens, m, checker, user)
+
+		case http.MethodDelete:
+			user, ok := middlewares.InteractiveUser(rw, r)
+			if !ok {
+				return
+			}
+			deleteAccount(rw, r, connection, t, refreshTokens, m, files, user)
+
+		default:
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+		}
+	})
+}
+
+func updateProfile(rw http.ResponseWriter, r *http.Request, connection *gorm.DB, t security.TokenSecurity, refreshTokens security.RefreshTokens, m mailer.Mailer, checker passwords.Validator, user *models.User) {
+	var request UpdateProfileRequest
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, struct{}{})
+		return
+	}
+
+	request.Email = strings.TrimSpace(request.Email)
+	if strings.EqualFold(request.Email, user.Email) {
+		request.Email = ""
+	}
+
+	validationErrors := validation.Validate(request)
+	if request.Password != "" {
+		if err := validatePassword(validationErrors, checker, "Password", request.Password, user.Email); err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+	}
+
+	if request.Email == "" && request.Password == "" && len(validationErrors) == 0 {
+		responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, map[string]string{
+			"error": "Nothing to change!",
+		})
+		return
+	}
+
+	if user.Password != "" && request.CurrentPassword == "" {
+		validationErrors["CurrentPassword"] = "required"
+	}
+
+	if len(validationErrors) != 0 {
+		responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, validationErrors)
+		return
+	}
+
+	if !currentPasswordMatches(user, request.CurrentPassword) {
+		responses.NewJsonResponse(rw, http.StatusForbidden, map[string]string{
+			"error": "The current password is incorrect!",
+		})
+		return
+	}
+
+	if request.Email != "" {
+		userCheck := models.User{}
+		connection.Find(&userCheck, "email=?", request.Email)
+		if userCheck.ID != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, map[string]string{
+				"error": "The email is already taken!",
+			})
+			return
+		}
+
+		// only the latest requested address can be confirmed
+		result := connection.Model(&models.EmailVerification{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if err := sendVerification(connection, t, m, user, request.Email); err != nil {
+			log.Printf("Failed to send the verification email %s \n", err)
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+	}
+
+	if request.Password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		result := connection.Model(&models.User{}).Where("id = ?", user.ID).Update("password", string(hashedPassword))
+		if result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+		user.Password = string(hashedPassword)
+
+		if err := t.RevokeAll(user.ID); err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if err := refreshTokens.RevokeUser(user.ID); err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+	}
+
+	response := newUserResponse(user)
+	response.PendingEmail = pendingEmail(connection, user)
+	responses.NewJsonResponse(rw, http.StatusOK, response)
+}
+
+// deleteAccount removes the events and media of the user together with every
+// credential, and anonymizes the account so the address can be registered
+// again. The media files are removed once the records are gone.
+func deleteAccount(rw http.ResponseWriter, r *http.Request, connection *gorm.DB, t security.TokenSecurity, refreshTokens security.RefreshTokens, m mailer.Mailer, files uploader.Uploader, user *models.User) {
+	var request DeleteAccountRequest
+	defer r.Body.Close()
+	// accounts without a password need no body
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, struct{}{})
+		return
+	}
+
+	if user.Password != "" && request.CurrentPassword == "" {
+		responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, map[string]string{
+			"CurrentPassword": "required",
+		})
+		return
+	}
+
+	if !currentPasswordMatches(user, request.CurrentPassword) {
+		responses.NewJsonResponse(rw, http.StatusForbidden, map[string]string{
+			"error": "The current password is incorrect!",
+		})
+		return
+	}
+
+	// revoke first, the generation of a deleted account can not be bumped
+	if err := t.RevokeAll(user.ID); err != nil {
+		responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	if err := refreshTokens.RevokeUser(user.ID); err != nil {
+		responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	notifications := []func(){}
+	removed := []models.Media{}
+	err := connection.Transaction(func(tx *gorm.DB) error {
+		// the seats of the account go to the waitlists
+		attending := []uint{}
+		err := tx.Model(&models.Attendee{}).Where("user_id = ? AND status = ?", user.ID, models.AttendeeGoing).
+			Pluck("event_id", &attending).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.Attendee{}).Error; err != nil {
+			return err
+		}
+		for _, eventID := range attending {
+			event, err := attendance.LockEvent(tx, eventID)
+			if err != nil {
+				return err
+			}
+			if event.ID == 0 {
+				continue
+			}
+
+			promoted, err := attendance.Promote(tx, &event)
+			if err != nil {
+				return err
+			}
+			notifications = append(notifications, func() { attendance.Notify(m, &event, promoted) })
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.EventCollaborator{}).Error; err != nil {
+			return err
+		}
+
+		// the account can not be restored, neither can its media
+		events := tx.Unscoped().Model(&models.Event{}).Select("id").Where("user_id = ?", user.ID)
+		if err := tx.Unscoped().Where("event_id IN (?)", events).Find(&removed).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("event_id IN (?)", events).Delete(&models.Media{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.Event{}).Error; err != nil {
+			return err
+		}
+
+		// invitations name the address they were sent to
+		invitations := tx.Unscoped().Where("user_id = ? OR email = ?", user.ID, attendance.NormalizeEmail(user.Email))
+		if err := invitations.Delete(&models.Invitation{}).Error; err != nil {
+			return err
+		}
+
+		credentials := []interface{}{
+			&models.APIKey{},
+			&models.RecoveryCode{},
+			&models.ExternalIdentity{},
+			&models.EmailVerification{},
+			&models.PasswordReset{},
+			&models.CalendarFeed{},
+		}
+		for _, credential := range credentials {
+			if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(credential).Error; err != nil {
+				return err
+			}
+		}
+
+		// the export worker removes expired archives
+		result := tx.Model(&models.ExportJob{}).Where("user_id = ? AND status = ?", user.ID, models.ExportCompleted).
+			Update("expires_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"email":           fmt.Sprintf("deleted-%d@invalid", user.ID),
+			"password":        "",
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		return tx.Delete(&models.User{}, user.ID).Error
+	})
+	if err != nil {
+		responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	media.RemoveFiles(connection, files, removed)
+	for _, notify := range notifications {
+		notify()
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
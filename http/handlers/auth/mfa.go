@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"site/database/models"
+	"site/http/middlewares"
+	"site/http/responses"
+	"site/lockout"
+	"site/security"
+	"site/security/totp"
+	"site/validation"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MFATokenTTL is how long the second step of a login may take.
+	MFATokenTTL = 5 * time.Minute
+	// RecoveryCodeCount is the number of recovery codes handed out when TOTP
+	// is enabled.
+	RecoveryCodeCount = 10
+)
+
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type TOTPEnrollmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type TOTPConfirmRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func issuer() string {
+	if name := os.Getenv("APP_NAME"); name != "" {
+		return name
+	}
+	return "site"
+}
+
+// newMFAChallenge is the response of the first login step for accounts with a
+// second factor.
+func newMFAChallenge(t security.PurposeTokens, u *models.User) (MFAChallengeResponse, error) {
+	_, token, err := t.CreatePurposeToken(security.PurposeMFA, strconv.Itoa(int(u.ID)), MFATokenTTL)
+	if err != nil {
+		return MFAChallengeResponse{}, err
+	}
+
+	return MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int(MFATokenTTL.Seconds()),
+	}, nil
+}
+
+// EnrollTOTP generates a new TOTP secret for the authenticated user. The
+// secret is only used once the first code has been confirmed.
+func EnrollTOTP(connection *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		user, ok := middlewares.InteractiveUser(rw, r)
+		if !ok {
+			return
+		}
+
+		if user.TOTPEnabledAt != nil {
+			responses.NewJsonResponse(rw, http.StatusConflict, map[string]string{
+				"error": "Two-factor authentication is already enabled!",
+			})
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		result := connection.Model(&models.User{}).Where("id = ?", user.ID).Update("totp_secret", secret)
+		if result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, TOTPEnrollmentResponse{
+			Secret:          secret,
+			ProvisioningURI: totp.ProvisioningURI(secret, issuer(), user.Email),
+		})
+	})
+}
+
+// ConfirmTOTP enables TOTP once the user proves their authenticator app
+// produces valid codes, and hands out the recovery codes.
+func ConfirmTOTP(connection *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		user, ok := middlewares.InteractiveUser(rw, r)
+		if !ok {
+			return
+		}
+
+		var request TOTPConfirmRequest
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, struct{}{})
+			return
+		}
+
+		validationErrors := validation.Validate(request)
+		if len(validationErrors) != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, validationErrors)
+			return
+		}
+
+		if user.TOTPEnabledAt != nil || user.TOTPSecret == "" {
+			responses.NewJsonResponse(rw, http.StatusConflict, map[string]string{
+				"error": "There is no pending two-factor enrollment!",
+			})
+			return
+		}
+
+		step, valid := totp.Validate(user.TOTPSecret, request.Code, time.Now())
+		if !valid {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, map[string]string{
+				"error": "Invalid code!",
+			})
+			return
+		}
+
+		codes := make([]string, RecoveryCodeCount)
+		err := connection.Transaction(func(tx *gorm.DB) error {
+			now := time.Now()
+			result := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+				"totp_enabled_at": now,
+				"totp_last_step":  step,
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+				return err
+			}
+
+			for i := range codes {
+				code, err := newRecoveryCode()
+				if err != nil {
+					return err
+				}
+				codes[i] = code
+
+				if err := tx.Create(&models.RecoveryCode{UserID: user.ID, CodeHash: security.HashToken(code)}).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+	})
+}
+
+// LoginMFA is the second login step. It exchanges the token returned by Login
+// and a TOTP or recovery code for access and refresh tokens. Invalid codes
+// count as failed logins.
+func LoginMFA(connection *gorm.DB, t security.TokenSecurity, refreshTokens security.RefreshTokens, guard lockout.LoginGuard) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		var request MFALoginRequest
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, struct{}{})
+			return
+		}
+
+		validationErrors := validation.Validate(request)
+		if len(validationErrors) != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, validationErrors)
+			return
+		}
+
+		claims, err := t.ParsePurposeToken(request.MFAToken, security.PurposeMFA)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnauthorized, map[string]string{
+				"error": "The login has expired, please start again!",
+			})
+			return
+		}
+
+		user := models.User{}
+		connection.Find(&user, claims.Subject)
+		if user.ID == 0 || user.TOTPEnabledAt == nil {
+			responses.NewJsonResponse(rw, http.StatusUnauthorized, map[string]string{
+				"error": "The login has expired, please start again!",
+			})
+			return
+		}
+
+		ip := clientIP(r)
+		wait, err := guard.Attempt(user.Email, ip)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if wait > 0 {
+			rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			responses.NewJsonResponse(rw, http.StatusTooManyRequests, map[string]string{
+				"error": "Too many failed login attempts!",
+			})
+			return
+		}
+
+		var valid bool
+		if request.Code != "" {
+			valid, err = useTOTPCode(connection, &user, request.Code)
+		} else {
+			valid, err = useRecoveryCode(connection, &user, request.RecoveryCode)
+		}
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if !valid {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, map[string]string{
+				"error": "Invalid code!",
+			})
+			return
+		}
+
+		if err := guard.Success(user.Email, ip); err != nil {
+			log.Printf("Failed to reset failed logins %s \n", err)
+		}
+
+		response, err := issueTokens(&user, t, refreshTokens)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, response)
+	})
+}
+
+// useTOTPCode accepts every code only once, even within its validity window.
+func useTOTPCode(connection *gorm.DB, user *models.User, code string) (bool, error) {
+	step, valid := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !valid {
+		return false, nil
+	}
+
+	result := connection.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+func useRecoveryCode(connection *gorm.DB, user *models.User, code string) (bool, error) {
+	result := connection.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, security.HashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// newRecoveryCode returns a code formatted as two groups of five characters.
+func newRecoveryCode() (string, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(secret[:10])
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) == 10 {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
@@ -0,0 +1,400 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"site/database/models"
+	"site/http/middlewares"
+	"site/http/responses"
+	"site/oidc"
+	"site/security"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// OAuthStateTTL is how long a user may take to sign in at the provider.
+const OAuthStateTTL = 10 * time.Minute
+
+// OAuthBindingCookie binds a sign in to the browser that started it, so a
+// callback started by somebody else can not be completed in the browser of a
+// victim.
+const OAuthBindingCookie = "oauth_binding"
+
+var errOAuthState = errors.New("invalid or expired state")
+
+type AuthorizationURLResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+type LinkedIdentityResponse struct {
+	Provider string `json:"provider"`
+	Email    string `json:"email"`
+}
+
+func provider(rw http.ResponseWriter, r *http.Request, providers oidc.Providers) (*oidc.Provider, bool) {
+	p, ok := providers.Get(mux.Vars(r)["provider"])
+	if !ok {
+		responses.NewJsonResponse(rw, http.StatusNotFound, map[string]string{
+			"error": "Unknown provider!",
+		})
+		return nil, false
+	}
+	return p, true
+}
+
+// setBindingCookie stores the binding of a sign in in the browser. The
+// cookie is sent along with the top level redirect back from the provider.
+func setBindingCookie(rw http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(rw, &http.Cookie{
+		Name:     OAuthBindingCookie,
+		Value:    value,
+		Path:     "/oauth/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   !strings.HasPrefix(os.Getenv("APP_URL"), "http://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// authorizationURL stores a new state, nonce and PKCE verifier and returns
+// the URL the user has to visit. States created for a user link the identity
+// to that user instead of logging in. The state is bound to the browser
+// through the binding cookie it sets.
+func authorizationURL(rw http.ResponseWriter, connection *gorm.DB, p *oidc.Provider, userID *uint) (string, error) {
+	state, err := security.NewRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	binding, err := security.NewRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := security.NewRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	codeVerifier, err := security.NewRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	result := connection.Create(&models.OAuthState{
+		StateHash:    security.HashToken(state),
+		Provider:     p.Name(),
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		UserID:       userID,
+		BindingHash:  security.HashToken(binding),
+		ExpiresAt:    time.Now().Add(OAuthStateTTL),
+	})
+	if result.Error != nil {
+		return "", result.Error
+	}
+
+	target, err := p.AuthCodeURL(state, nonce, codeVerifier)
+	if err != nil {
+		return "", err
+	}
+
+	setBindingCookie(rw, binding, int(OAuthStateTTL.Seconds()))
+	return target, nil
+}
+
+// useOAuthState consumes a state. Every state is accepted only once, and only
+// from the browser that started the sign in.
+func useOAuthState(connection *gorm.DB, p *oidc.Provider, state string, r *http.Request) (*models.OAuthState, error) {
+	stored := models.OAuthState{}
+	connection.Where("state_hash = ? AND provider = ?", security.HashToken(state), p.Name()).Find(&stored)
+	if stored.ID == 0 || stored.UsedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, errOAuthState
+	}
+
+	cookie, err := r.Cookie(OAuthBindingCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(security.HashToken(cookie.Value)), []byte(stored.BindingHash)) != 1 {
+		return nil, errOAuthState
+	}
+
+	result := connection.Model(&models.OAuthState{}).
+		Where("id = ? AND used_at IS NULL", stored.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected != 1 {
+		return nil, errOAuthState
+	}
+
+	return &stored, nil
+}
+
+// OIDCLogin redirects the browser to the provider to sign in.
+func OIDCLogin(connection *gorm.DB, providers oidc.Providers) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		p, ok := provider(rw, r, providers)
+		if !ok {
+			return
+		}
+
+		target, err := authorizationURL(rw, connection, p, nil)
+		if err != nil {
+			log.Printf("Failed to start the %s login %s \n", p.Name(), err)
+			responses.NewJsonResponse(rw, http.StatusBadGateway, map[string]string{
+				"error": "The provider is not available!",
+			})
+			return
+		}
+
+		http.Redirect(rw, r, target, http.StatusFound)
+	})
+}
+
+// OIDCLink returns the URL that links an identity of the provider to the
+// authenticated user on POST and removes the link on DELETE. The link has to
+// be completed in the browser that received the response.
+func OIDCLink(connection *gorm.DB, providers oidc.Providers) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		user, ok := middlewares.InteractiveUser(rw, r)
+		if !ok {
+			return
+		}
+
+		p, ok := provider(rw, r, providers)
+		if !ok {
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			unlinkIdentity(rw, connection, user, p)
+			return
+		}
+
+		target, err := authorizationURL(rw, connection, p, &user.ID)
+		if err != nil {
+			log.Printf("Failed to start linking %s %s \n", p.Name(), err)
+			responses.NewJsonResponse(rw, http.StatusBadGateway, map[string]string{
+				"error": "The provider is not available!",
+			})
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, AuthorizationURLResponse{AuthorizationURL: target})
+	})
+}
+
+// unlinkIdentity refuses to remove the last way an account without a
+// password can sign in.
+func unlinkIdentity(rw http.ResponseWriter, connection *gorm.DB, user *models.User, p *oidc.Provider) {
+	var others int64
+	connection.Model(&models.ExternalIdentity{}).Where("user_id = ? AND provider <> ?", user.ID, p.Name()).Count(&others)
+	if user.Password == "" && others == 0 {
+		responses.NewJsonResponse(rw, http.StatusConflict, map[string]string{
+			"error": "Set a password before removing the last sign in method!",
+		})
+		return
+	}
+
+	result := connection.Unscoped().Where("user_id = ? AND provider = ?", user.ID, p.Name()).Delete(&models.ExternalIdentity{})
+	if result.Error != nil {
+		responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		responses.NewJsonResponse(rw, http.StatusNotFound, map[string]string{
+			"error": "The provider is not linked!",
+		})
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// OIDCCallback completes the authorization code flow. Depending on the state
+// it either links the identity to the user that started the flow or logs the
+// owner of the identity in, creating an account on first sign in. Existing
+// accounts with the same email are never linked implicitly, the owner has to
+// log in and link the provider themselves.
+func OIDCCallback(connection *gorm.DB, providers oidc.Providers, t security.TokenSecurity, refreshTokens security.RefreshTokens) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		p, ok := provider(rw, r, providers)
+		if !ok {
+			return
+		}
+
+		query := r.URL.Query()
+		if providerError := query.Get("error"); providerError != "" {
+			responses.NewJsonResponse(rw, http.StatusBadRequest, map[string]string{
+				"error": "The provider declined the sign in!",
+				"code":  providerError,
+			})
+			return
+		}
+
+		state, err := useOAuthState(connection, p, query.Get("state"), r)
+		setBindingCookie(rw, "", -1)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusBadRequest, map[string]string{
+				"error": "The sign in has expired, please start again!",
+			})
+			return
+		}
+
+		claims, err := p.Exchange(query.Get("code"), state.CodeVerifier, state.Nonce)
+		if err != nil {
+			log.Printf("Failed to complete the %s sign in %s \n", p.Name(), err)
+			responses.NewJsonResponse(rw, http.StatusUnauthorized, map[string]string{
+				"error": "The sign in could not be verified!",
+			})
+			return
+		}
+
+		identity := models.ExternalIdentity{}
+		connection.Where("provider = ? AND subject = ?", p.Name(), claims.Subject).Find(&identity)
+
+		if state.UserID != nil {
+			linkIdentity(rw, connection, *state.UserID, p, claims, &identity)
+			return
+		}
+
+		user := models.User{}
+		if identity.ID != 0 {
+			connection.Find(&user, identity.UserID)
+		} else {
+			user, err = createExternalUser(rw, connection, p, claims)
+			if err != nil {
+				return
+			}
+		}
+
+		if user.ID == 0 {
+			responses.NewJsonResponse(rw, http.StatusUnauthorized, map[string]string{
+				"error": "Invalid User!",
+			})
+			return
+		}
+
+		if user.TOTPEnabledAt != nil {
+			challenge, err := newMFAChallenge(t, &user)
+			if err != nil {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+
+			responses.NewJsonResponse(rw, http.StatusOK, challenge)
+			return
+		}
+
+		response, err := issueTokens(&user, t, refreshTokens)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, response)
+	})
+}
+
+func linkIdentity(rw http.ResponseWriter, connection *gorm.DB, userID uint, p *oidc.Provider, claims *oidc.IDTokenClaims, identity *models.ExternalIdentity) {
+	if identity.ID != 0 {
+		if identity.UserID != userID {
+			responses.NewJsonResponse(rw, http.StatusConflict, map[string]string{
+				"error": "The identity is linked to another account!",
+			})
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, LinkedIdentityResponse{Provider: p.Name(), Email: identity.Email})
+		return
+	}
+
+	var existing int64
+	connection.Model(&models.ExternalIdentity{}).Where("user_id = ? AND provider = ?", userID, p.Name()).Count(&existing)
+	if existing != 0 {
+		responses.NewJsonResponse(rw, http.StatusConflict, map[string]string{
+			"error": "Another identity of the provider is linked already!",
+		})
+		return
+	}
+
+	linked := models.ExternalIdentity{
+		UserID:   userID,
+		Provider: p.Name(),
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if result := connection.Create(&linked); result.Error != nil {
+		responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	responses.NewJsonResponse(rw, http.StatusOK, LinkedIdentityResponse{Provider: p.Name(), Email: linked.Email})
+}
+
+// createExternalUser signs up a user on their first sign in. The account has
+// no password, so it can only be used through the provider until one is set.
+func createExternalUser(rw http.ResponseWriter, connection *gorm.DB, p *oidc.Provider, claims *oidc.IDTokenClaims) (models.User, error) {
+	email := strings.TrimSpace(claims.Email)
+	if email == "" || !claims.EmailVerified {
+		responses.NewJsonResponse(rw, http.StatusForbidden, map[string]string{
+			"error": "The provider did not confirm your email address!",
+			"code":  "email_not_verified",
+		})
+		return models.User{}, errors.New("unverified email")
+	}
+
+	userCheck := models.User{}
+	connection.Find(&userCheck, "email=?", email)
+	if userCheck.ID != 0 {
+		responses.NewJsonResponse(rw, http.StatusConflict, map[string]string{
+			"error": "An account with this email exists, log in and link the provider!",
+			"code":  "account_exists",
+		})
+		return models.User{}, errors.New("account exists")
+	}
+
+	now := time.Now()
+	user := models.User{Email: email, EmailVerifiedAt: &now}
+	err := connection.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.ExternalIdentity{
+			UserID:   user.ID,
+			Provider: p.Name(),
+			Subject:  claims.Subject,
+			Email:    email,
+		}).Error
+	})
+	if err != nil {
+		responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+		return models.User{}, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"site/passwords"
+	"strings"
+)
+
+// validatePassword adds the rules a new password violates to the validation
+// errors of its field, comma separated.
+func validatePassword(validationErrors map[string]string, checker passwords.Validator, field string, password string, email string) error {
+	if _, ok := validationErrors[field]; ok {
+		return nil
+	}
+
+	violations, err := checker.Validate(password, email)
+	if err != nil {
+		return err
+	}
+
+	if len(violations) != 0 {
+		validationErrors[field] = strings.Join(violations, ",")
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"site/database/models"
+	"site/http/responses"
+	"site/mailer"
+	"site/passwords"
+	"site/security"
+	"site/validation"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// PasswordResetTTL is how long a password reset link can be used.
+const PasswordResetTTL = time.Hour
+
+var errPasswordResetUsed = errors.New("the password reset has already been used")
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token                string `json:"token" validate:"required"`
+	Password             string `json:"password" validate:"required,eqcsfield=PasswordConfirmation"`
+	PasswordConfirmation string `json:"password_confirmation" validate:"required"`
+}
+
+// ForgotPassword mails a single use reset link. It answers the same way, and
+// as fast, whether or not an account exists for the address.
+func ForgotPassword(connection *gorm.DB, m mailer.Mailer) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		var request ForgotPasswordRequest
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, struct{}{})
+			return
+		}
+
+		validationErrors := validation.Validate(request)
+		if len(validationErrors) != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, validationErrors)
+			return
+		}
+
+		// the reset runs after the response, so the response time does not
+		// tell whether the account exists
+		go sendPasswordReset(connection, m, request.Email)
+
+		responses.NewJsonResponse(rw, http.StatusAccepted, map[string]string{
+			"message": "If an account exists for this address, a reset link has been sent.",
+		})
+	})
+}
+
+// sendPasswordReset mails a reset link to the account of the address, if
+// there is one. Failures are only logged, nobody waits for them.
+func sendPasswordReset(connection *gorm.DB, m mailer.Mailer, email string) {
+	user := models.User{}
+	if result := connection.Find(&user, "email=?", email); result.Error != nil || user.ID == 0 {
+		return
+	}
+
+	token, err := security.NewRandomToken(32)
+	if err != nil {
+		log.Printf("Failed to create a password reset token %s \n", err)
+		return
+	}
+
+	err = connection.Transaction(func(tx *gorm.DB) error {
+		// only the latest link stays usable
+		result := tx.Model(&models.PasswordReset{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+
+		return tx.Create(&models.PasswordReset{
+			UserID:    user.ID,
+			TokenHash: security.HashToken(token),
+			ExpiresAt: time.Now().Add(PasswordResetTTL),
+		}).Error
+	})
+	if err != nil {
+		log.Printf("Failed to store the password reset %s \n", err)
+		return
+	}
+
+	if err := m.Send(mailer.PasswordResetMessage(user.Email, token)); err != nil {
+		log.Printf("Failed to send the password reset email %s \n", err)
+	}
+}
+
+// ResetPassword sets a new password using a reset token and signs the user
+// out of every session. The password has to satisfy the password policy.
+func ResetPassword(connection *gorm.DB, t security.TokenSecurity, refreshTokens security.RefreshTokens, checker passwords.Validator) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		var request ResetPasswordRequest
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, struct{}{})
+			return
+		}
+
+		validationErrors := validation.Validate(request)
+		if len(validationErrors) != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, validationErrors)
+			return
+		}
+
+		invalid := map[string]string{
+			"error": "The reset link is invalid or has expired!",
+		}
+
+		reset := models.PasswordReset{}
+		result := connection.Where("token_hash = ?", security.HashToken(request.Token)).Limit(1).Find(&reset)
+		if result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if reset.ID == 0 || reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, invalid)
+			return
+		}
+
+		user := models.User{}
+		connection.Find(&user, reset.UserID)
+
+		passwordErrors := map[string]string{}
+		if err := validatePassword(passwordErrors, checker, "Password", request.Password, user.Email); err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if len(passwordErrors) != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, passwordErrors)
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		err = connection.Transaction(func(tx *gorm.DB) error {
+			now := time.Now()
+			result := tx.Model(&models.PasswordReset{}).
+				Where("id = ? AND used_at IS NULL", reset.ID).
+				Update("used_at", now)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected != 1 {
+				return errPasswordResetUsed
+			}
+
+			if err := tx.Model(&models.User{}).Where("id = ?", reset.UserID).Update("password", string(hashedPassword)).Error; err != nil {
+				return err
+			}
+
+			// following the emailed link proves the address belongs to the user
+			return tx.Model(&models.User{}).
+				Where("id = ? AND email_verified_at IS NULL", reset.UserID).
+				Update("email_verified_at", now).Error
+		})
+
+		if errors.Is(err, errPasswordResetUsed) {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, invalid)
+			return
+		}
+
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if err := t.RevokeAll(reset.UserID); err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if err := refreshTokens.RevokeUser(reset.UserID); err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, map[string]string{
+			"message": "The password has been changed!",
+		})
+	})
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"site/database/models"
+	"site/http/responses"
+	"site/security"
+	"site/validation"
+
+	"gorm.io/gorm"
+)
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// Refresh exchanges a refresh token for a new access token. The presented
+// refresh token is rotated, so every refresh token can be used only once.
+func Refresh(connection *gorm.DB, t security.TokenSecurity, refreshTokens security.RefreshTokens) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		var request RefreshRequest
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, struct{}{})
+			return
+		}
+
+		validationErrors := validation.Validate(request)
+		if len(validationErrors) != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, validationErrors)
+			return
+		}
+
+		refreshToken, userId, err := refreshTokens.Rotate(request.RefreshToken)
+		if errors.Is(err, security.ErrInvalidRefreshToken) || errors.Is(err, security.ErrRefreshTokenReused) {
+			responses.NewJsonResponse(rw, http.StatusUnauthorized, map[string]string{
+				"error": "Invalid refresh token!",
+			})
+			return
+		}
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		user := models.User{}
+		result := connection.Find(&user, userId)
+		if result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if user.ID == 0 {
+			responses.NewJsonResponse(rw, http.StatusUnauthorized, map[string]string{
+				"error": "Invalid refresh token!",
+			})
+			return
+		}
+
+		tokenString, err := t.CreateToken(&user)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, newTokenResponse(t, tokenString, refreshToken))
+	})
+}
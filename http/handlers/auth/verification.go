@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"site/attendance"
+	"site/database/models"
+	"site/http/responses"
+	"site/mailer"
+	"site/security"
+	"site/validation"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// VerificationTTL is how long a verification link can be used.
+	VerificationTTL = 24 * time.Hour
+	// VerificationResendInterval is the minimum time between two
+	// verification emails sent to the same account.
+	VerificationResendInterval = time.Minute
+)
+
+var (
+	errVerificationUsed = errors.New("the verification has already been used")
+	errEmailTaken       = errors.New("the email is already taken")
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// sendVerification stores a single use verification for the address and
+// mails the signed token to it.
+func sendVerification(connection *gorm.DB, tokens security.PurposeTokens, m mailer.Mailer, user *models.User, email string) error {
+	claims, token, err := tokens.CreatePurposeToken(security.PurposeEmailVerification, strconv.Itoa(int(user.ID)), VerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	verification := models.EmailVerification{
+		UserID:    user.ID,
+		Email:     email,
+		TokenID:   claims.ID,
+		ExpiresAt: claims.ExpiresAt,
+	}
+	if result := connection.Create(&verification); result.Error != nil {
+		return result.Error
+	}
+
+	return m.Send(mailer.VerificationMessage(email, token))
+}
+
+// VerifyEmail marks the address of a verification token as verified, which
+// replaces the email of the account when it was sent for an email change. The
+// token is read from the "token" query parameter so the emailed link works, or
+// from a JSON body. Invitations accepted for the address become RSVPs of the
+// account once it is proven to belong to it.
+func VerifyEmail(connection *gorm.DB, tokens security.PurposeTokens, m mailer.Mailer) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		request := VerifyEmailRequest{Token: r.URL.Query().Get("token")}
+		if request.Token == "" && r.Method == http.MethodPost {
+			defer r.Body.Close()
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, struct{}{})
+				return
+			}
+		}
+
+		validationErrors := validation.Validate(request)
+		if len(validationErrors) != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, validationErrors)
+			return
+		}
+
+		invalid := map[string]string{
+			"error": "The verification link is invalid or has expired!",
+		}
+
+		claims, err := tokens.ParsePurposeToken(request.Token, security.PurposeEmailVerification)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, invalid)
+			return
+		}
+
+		verification := models.EmailVerification{}
+		result := connection.Where("token_id = ?", claims.ID).Limit(1).Find(&verification)
+		if result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if verification.ID == 0 || verification.UsedAt != nil || strconv.Itoa(int(verification.UserID)) != claims.Subject {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, invalid)
+			return
+		}
+
+		err = connection.Transaction(func(tx *gorm.DB) error {
+			now := time.Now()
+			result := tx.Model(&models.EmailVerification{}).
+				Where("id = ? AND used_at IS NULL", verification.ID).
+				Update("used_at", now)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected != 1 {
+				return errVerificationUsed
+			}
+
+			// a verification for another address confirms an email change
+			var taken int64
+			tx.Model(&models.User{}).Where("email = ? AND id <> ?", verification.Email, verification.UserID).Count(&taken)
+			if taken != 0 {
+				return errEmailTaken
+			}
+
+			return tx.Model(&models.User{}).
+				Where("id = ?", verification.UserID).
+				Updates(map[string]interface{}{"email": verification.Email, "email_verified_at": now}).Error
+		})
+
+		if errors.Is(err, errVerificationUsed) {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, invalid)
+			return
+		}
+
+		if errors.Is(err, errEmailTaken) {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, map[string]string{
+				"error": "The email is already taken!",
+			})
+			return
+		}
+
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		// the address is verified, the invitations are accepted on a best
+		// effort basis
+		user := models.User{}
+		if result := connection.Find(&user, verification.UserID); result.Error != nil || user.ID == 0 {
+			log.Printf("Failed to load user %d to accept their invitations \n", verification.UserID)
+		} else {
+			results, err := attendance.AcceptInvitations(connection, &user)
+			if err != nil {
+				log.Printf("Failed to accept the invitations of user %d %s \n", user.ID, err)
+			}
+			for _, accepted := range results {
+				attendance.Notify(m, &accepted.Event, accepted.Promoted)
+			}
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, map[string]string{
+			"message": "The email address has been verified!",
+		})
+	})
+}
+
+// ResendVerification mails a new verification link. The response is the same
+// whether or not an unverified account exists for the address.
+func ResendVerification(connection *gorm.DB, tokens security.PurposeTokens, m mailer.Mailer) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, struct{}{})
+			return
+		}
+
+		var request ResendVerificationRequest
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, struct{}{})
+			return
+		}
+
+		validationErrors := validation.Validate(request)
+		if len(validationErrors) != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, validationErrors)
+			return
+		}
+
+		accepted := map[string]string{
+			"message": "If the account exists and is not verified yet, a new link has been sent.",
+		}
+
+		user := models.User{}
+		connection.Find(&user, "email=?", request.Email)
+		if user.ID == 0 || user.EmailVerifiedAt != nil {
+			responses.NewJsonResponse(rw, http.StatusAccepted, accepted)
+			return
+		}
+
+		last := models.EmailVerification{}
+		connection.Where("user_id = ?", user.ID).Order("created_at desc").Limit(1).Find(&last)
+		if last.ID != 0 {
+			wait := VerificationResendInterval - time.Since(last.CreatedAt)
+			if wait > 0 {
+				rw.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+				responses.NewJsonResponse(rw, http.StatusTooManyRequests, map[string]string{
+					"error": "Please wait before requesting another verification email!",
+				})
+				return
+			}
+		}
+
+		if err := sendVerification(connection, tokens, m, &user, user.Email); err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusAccepted, accepted)
+	})
+}
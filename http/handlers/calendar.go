@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"site/database/models"
+	"site/http/middlewares"
+	"site/http/responses"
+	"site/ical"
+	"site/security"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type CalendarFeedResponse struct {
+	Active    bool       `json:"active"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	URL       string     `json:"url,omitempty"`
+}
+
+// prodID names the application as the producer of calendars.
+func prodID() string {
+	name := os.Getenv("APP_NAME")
+	if name == "" {
+		name = "site"
+	}
+	return "-//" + name + "//events//EN"
+}
+
+// eventUID is the globally unique identifier of an event in calendars. Events
+// created here get one scoped to the host of APP_URL.
+func eventUID(event *models.Event) string {
+	if event.UID != "" {
+		return event.UID
+	}
+
+	host := "site"
+	if appURL, err := url.Parse(os.Getenv("APP_URL")); err == nil && appURL.Hostname() != "" {
+		host = appURL.Hostname()
+	}
+	return fmt.Sprintf("event-%d@%s", event.ID, host)
+}
+
+func calendarStatus(status string) string {
+	switch status {
+	case models.EventDraft:
+		return ical.StatusTentative
+	case models.EventCancelled:
+		return ical.StatusCancelled
+	default:
+		return ical.StatusConfirmed
+	}
+}
+
+func calendarLocation(venue string, address string) string {
+	parts := []string{}
+	for _, part := range []string{venue, address} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// calendarEvents converts an event with its exceptions to VEVENTs. Cancelled
+// occurrences of a series become EXDATEs, changed ones get a VEVENT with a
+// RECURRENCE-ID. Events without a start are left out.
+func calendarEvents(event *models.Event, exceptions []models.EventException, stamp time.Time) []ical.Event {
+	set, ok := recurrenceSet(event)
+	if !ok {
+		return nil
+	}
+
+	master := ical.Event{
+		UID:             eventUID(event),
+		Stamp:           stamp,
+		Created:         event.CreatedAt,
+		LastModified:    event.UpdatedAt,
+		Start:           *event.StartsAt,
+		End:             event.EndsAt,
+		AllDay:          event.AllDay,
+		TimeZone:        event.TimeZone,
+		Summary:         event.Name,
+		Description:     event.Description,
+		Location:        calendarLocation(event.Venue, event.Address),
+		Latitude:        event.Latitude,
+		Longitude:       event.Longitude,
+		Status:          calendarStatus(event.Status),
+		RecurrenceDates: set.RDates,
+		ExceptionDates:  set.ExDates,
+	}
+	for _, attachment := range event.Attachments {
+		master.Attachments = append(master.Attachments, attachment.URL)
+	}
+	if set.Rule != nil {
+		master.RecurrenceRule = set.Rule.Resolve(set.Start.Location(), event.AllDay).String()
+	}
+	recurring := set.Rule != nil || len(set.RDates) != 0
+
+	changed := []ical.Event{}
+	for i := range exceptions {
+		exception := &exceptions[i]
+		if !set.Contains(exception.OccurrenceAt) {
+			continue
+		}
+		occurrence := newOccurrenceResponse(event, exception.OccurrenceAt, exception)
+
+		if recurring && exception.Cancelled {
+			master.ExceptionDates = append(master.ExceptionDates, exception.OccurrenceAt)
+			continue
+		}
+
+		e := master
+		if recurring {
+			recurrenceID := exception.OccurrenceAt
+			e.RecurrenceID = &recurrenceID
+			e.RecurrenceRule, e.RecurrenceDates, e.ExceptionDates = "", nil, nil
+		}
+		e.Start = occurrence.StartsAt
+		e.End = occurrence.EndsAt
+		e.Summary = occurrence.Name
+		e.Description = occurrence.Description
+		e.Location = calendarLocation(occurrence.Venue, occurrence.Address)
+		e.LastModified = exception.UpdatedAt
+		if occurrence.Cancelled {
+			e.Status = ical.StatusCancelled
+		}
+
+		if !recurring {
+			// a single event takes the changes itself
+			master = e
+			continue
+		}
+		changed = append(changed, e)
+	}
+
+	return append([]ical.Event{master}, changed...)
+}
+
+// findExceptions loads the exceptions of the events grouped by event.
+func findExceptions(connection *gorm.DB, events []models.Event) (map[uint][]models.EventException, error) {
+	grouped := map[uint][]models.EventException{}
+	if len(events) == 0 {
+		return grouped, nil
+	}
+
+	ids := make([]uint, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+
+	exceptions := []models.EventException{}
+	if result := connection.Where("event_id IN ?", ids).Order("occurrence_at").Find(&exceptions); result.Error != nil {
+		return nil, result.Error
+	}
+	for _, exception := range exceptions {
+		grouped[exception.EventID] = append(grouped[exception.EventID], exception)
+	}
+	return grouped, nil
+}
+
+func writeCalendar(rw http.ResponseWriter, calendar ical.Calendar, filename string) {
+	rw.Header().Set("Content-Type", ical.ContentType)
+	if filename != "" {
+		rw.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	}
+	rw.WriteHeader(http.StatusOK)
+	calendar.WriteTo(rw)
+}
+
+// EventCalendar exports an event as an iCalendar file. Drafts and events that
+// are not public are only exported to those who may see them.
+func EventCalendar(connection *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		event, ok := findEvent(rw, r, connection, false, canViewEvent, http.StatusNotFound)
+		if !ok {
+			return
+		}
+		if result := connection.Where("event_id = ?", event.ID).Find(&event.Attachments); result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+		if event.StartsAt == nil {
+			responses.NewJsonResponse(rw, http.StatusNotFound, map[string]string{
+				"error": "The event is not scheduled yet!",
+			})
+			return
+		}
+
+		exceptions, err := findExceptions(connection, []models.Event{*event})
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		writeCalendar(rw, ical.Calendar{
+			ProdID: prodID(),
+			Name:   event.Name,
+			Events: calendarEvents(event, exceptions[event.ID], time.Now()),
+		}, fmt.Sprintf("event-%d.ics", event.ID))
+	})
+}
+
+// feedURL is the subscription link of a feed token.
+func feedURL(token string) string {
+	return os.Getenv("APP_URL") + "/calendar/" + token + ".ics"
+}
+
+// ManageCalendarFeed shows whether the authenticated user has a calendar
+// feed, creates one or replaces its link on POST and revokes it on DELETE.
+// The link is only shown when it is created.
+func ManageCalendarFeed(connection *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		user, ok := middlewares.InteractiveUser(rw, r)
+		if !ok {
+			return
+		}
+
+		feed := models.CalendarFeed{}
+		if result := connection.Where("user_id = ?", user.ID).Limit(1).Find(&feed); result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			response := CalendarFeedResponse{Active: feed.ID != 0}
+			if feed.ID != 0 {
+				response.CreatedAt = &feed.UpdatedAt
+			}
+			responses.NewJsonResponse(rw, http.StatusOK, response)
+
+		case http.MethodPost:
+			token, err := security.NewRandomToken(32)
+			if err != nil {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+
+			feed.UserID = user.ID
+			feed.TokenHash = security.HashToken(token)
+			if result := connection.Save(&feed); result.Error != nil {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+
+			responses.NewJsonResponse(rw, http.StatusCreated, CalendarFeedResponse{
+				Active:    true,
+				CreatedAt: &feed.UpdatedAt,
+				URL:       feedURL(token),
+			})
+
+		case http.MethodDelete:
+			if feed.ID == 0 {
+				responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+				return
+			}
+
+			if result := connection.Unscoped().Delete(&feed); result.Error != nil {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+			rw.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
+
+// CalendarFeed serves the events of a user to calendar apps. The secret token
+// of the link is the only credential, since calendar apps can not log in.
+func CalendarFeed(connection *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		feed := models.CalendarFeed{}
+		result := connection.Where("token_hash = ?", security.HashToken(mux.Vars(r)["token"])).Limit(1).Find(&feed)
+		if result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+		if feed.ID == 0 {
+			responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+			return
+		}
+
+		events := []models.Event{}
+		result = connection.Preload("Attachments").Where("user_id = ? AND starts_at IS NOT NULL", feed.UserID).Order("starts_at").Find(&events)
+		if result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		exceptions, err := findExceptions(connection, events)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		now := time.Now()
+		calendar := ical.Calendar{ProdID: prodID(), Name: "Events"}
+		for i := range events {
+			calendar.Events = append(calendar.Events, calendarEvents(&events[i], exceptions[events[i].ID], now)...)
+		}
+
+		// the link is a credential, keep it out of shared caches
+		rw.Header().Set("Cache-Control", "private, no-cache")
+		writeCalendar(rw, calendar, "")
+	})
+}
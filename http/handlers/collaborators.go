@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"site/database/models"
+	"site/http/middlewares"
+	"site/http/responses"
+	"site/policy"
+	"site/validation"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// CollaboratorRequest shares an event with the account of the email.
+type CollaboratorRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"required,oneof=editor media-uploader viewer"`
+}
+
+// CollaboratorRoleRequest changes the role of a collaborator.
+type CollaboratorRoleRequest struct {
+	Role string `json:"role" validate:"required,oneof=editor media-uploader viewer"`
+}
+
+type CollaboratorResponse struct {
+	UserID    uint      `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newCollaboratorResponse(c *models.EventCollaborator) CollaboratorResponse {
+	return CollaboratorResponse{
+		UserID:    c.UserID,
+		Email:     c.User.Email,
+		Role:      c.Role,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+	}
+}
+
+// Collaborators lists the collaborators of an event on GET and shares the
+// event with another account on POST. Only those who manage the event share
+// it, collaborators see who else works on it.
+func Collaborators(connection *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			event, ok := findEvent(rw, r, connection, false, policy.CanViewEvent, http.StatusForbidden)
+			if !ok {
+				return
+			}
+
+			collaborators := []models.EventCollaborator{}
+			if result := connection.Preload("User").Where("event_id = ?", event.ID).Order("id").Find(&collaborators); result.Error != nil {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+
+			response := make([]CollaboratorResponse, len(collaborators))
+			for i := range collaborators {
+				response[i] = newCollaboratorResponse(&collaborators[i])
+			}
+			responses.NewJsonResponse(rw, http.StatusOK, response)
+			return
+		}
+
+		event, ok := findManagedEvent(rw, r, connection, false)
+		if !ok {
+			return
+		}
+
+		request := CollaboratorRequest{}
+		if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, maxEventBodySize)).Decode(&request); err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, nil)
+			return
+		}
+
+		errors := validation.Validate(request)
+		if len(errors) != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, errors)
+			return
+		}
+
+		user := models.User{}
+		email := strings.ToLower(strings.TrimSpace(request.Email))
+		if result := connection.Where("LOWER(email) = ?", email).Limit(1).Find(&user); result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+		if user.ID == 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, map[string]string{
+				"error": "There is no account with this email!",
+			})
+			return
+		}
+		if user.ID == event.UserID {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, map[string]string{
+				"error": "The owner can not be a collaborator!",
+			})
+			return
+		}
+
+		role, err := collaboratorRole(connection, event.ID, user.ID)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+		if role != "" {
+			responses.NewJsonResponse(rw, http.StatusConflict, map[string]string{
+				"error": "The user is a collaborator already!",
+			})
+			return
+		}
+
+		current, ok := middlewares.CurrentUser(r)
+		if !ok {
+			responses.NewJsonResponse(rw, http.StatusUnauthorized, nil)
+			return
+		}
+
+		collaborator := models.EventCollaborator{
+			EventID:   event.ID,
+			UserID:    user.ID,
+			User:      user,
+			Role:      request.Role,
+			AddedByID: current.ID,
+		}
+		if result := connection.Omit("User").Create(&collaborator); result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusCreated, newCollaboratorResponse(&collaborator))
+	})
+}
+
+// Collaborator changes the role of a collaborator on PUT and stops sharing
+// the event with the collaborator on DELETE. Collaborators may leave an event
+// themselves.
+func Collaborator(connection *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		userId, err := strconv.Atoi(mux.Vars(r)["user"])
+		if err != nil || userId == 0 {
+			responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+			return
+		}
+
+		allowed := canManageEvent
+		if r.Method == http.MethodDelete {
+			allowed = func(p *middlewares.Principal, e *models.Event, role string) bool {
+				return (role != "" && p.User.ID == uint(userId)) || policy.CanManageEvent(p, e)
+			}
+		}
+
+		event, ok := findEvent(rw, r, connection, false, allowed, http.StatusForbidden)
+		if !ok {
+			return
+		}
+
+		collaborator := models.EventCollaborator{}
+		result := connection.Preload("User").Where("event_id = ? AND user_id = ?", event.ID, userId).Limit(1).Find(&collaborator)
+		if result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+		if collaborator.ID == 0 {
+			responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			// unscoped, the unique index would keep a soft deleted collaborator
+			if result := connection.Unscoped().Delete(&collaborator); result.Error != nil {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		request := CollaboratorRoleRequest{}
+		if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, maxEventBodySize)).Decode(&request); err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, nil)
+			return
+		}
+
+		errors := validation.Validate(request)
+		if len(errors) != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, errors)
+			return
+		}
+
+		collaborator.Role = request.Role
+		if result := connection.Omit("User").Save(&collaborator); result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, newCollaboratorResponse(&collaborator))
+	})
+}
@@ -2,53 +2,64 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
 	"net/http"
 	"regexp"
+	"site/attendance"
 	"site/database/models"
 	"site/http/middlewares"
 	"site/http/responses"
+	"site/mailer"
+	"site/media"
+	"site/mergepatch"
+	"site/policy"
+	"site/recurrence"
 	"site/security"
+	"site/uploader"
 	"site/validation"
 	"strconv"
+	"time"
 
+	"github.com/gorilla/mux"
 	"gorm.io/gorm"
 )
 
-func EventCreate(connection *gorm.DB, tokenService security.TokenSecurity) http.Handler {
+func EventCreate(connection *gorm.DB) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
 			return
 		}
 
-		event := models.Event{}
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		fields := eventFields{}
+		if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
 			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, nil)
 			return
 		}
 
+		event := models.Event{}
+		fields.apply(&event)
+
 		errors := validation.Validate(event)
 		if len(errors) != 0 {
 			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, errors)
 			return
 		}
 
-		authHeader := r.Header.Get(middlewares.AuthorizationHeader)
-		if authHeader == "" {
+		user, ok := middlewares.CurrentUser(r)
+		if !ok {
 			responses.NewJsonResponse(rw, http.StatusUnauthorized, nil)
 			return
 		}
+		event.UserID = user.ID
 
-		userId, err := tokenService.GetIdentifier(authHeader)
-		if err != nil {
-			responses.NewJsonResponse(rw, http.StatusUnauthorized, nil)
+		if err := shareEvent(&event); err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
 			return
 		}
 
-		user := models.User{}
-		connection.Find(&user, userId)
-		event.UserID = user.ID
-
 		result := connection.Save(&event)
 		if result.Error != nil {
 			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
@@ -58,6 +69,8 @@ func EventCreate(connection *gorm.DB, tokenService security.TokenSecurity) http.
 	})
 }
 
+// GetEvent shows an event. Drafts and events that are not public are only
+// shown to its owner, collaborators and admins.
 func GetEvent(connection *gorm.DB) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -65,33 +78,408 @@ func GetEvent(connection *gorm.DB) http.Handler {
 			return
 		}
 
-		eventId, err := ParseEventId(r)
+		event, ok := findEvent(rw, r, connection, false, canViewEvent, http.StatusNotFound)
+		if !ok {
+			return
+		}
 
-		if err != nil || eventId == 0 {
-			responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+		if result := connection.Where("event_id = ?", event.ID).Find(&event.Attachments); result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
 			return
 		}
 
-		event := models.Event{}
-		result := connection.Find(&event, eventId)
-		if result.Error != nil {
+		responses.NewJsonResponse(rw, http.StatusOK, event)
+	})
+}
+
+// maxEventBodySize limits the size of event update requests.
+const maxEventBodySize = 1 << 20
+
+// eventFields are the fields of an event its owner may change.
+type eventFields struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	StartsAt    *time.Time `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at"`
+	TimeZone    string     `json:"time_zone"`
+	AllDay      bool       `json:"all_day"`
+	Venue       string     `json:"venue"`
+	Address     string     `json:"address"`
+	Latitude    *float64   `json:"latitude"`
+	Longitude   *float64   `json:"longitude"`
+	Capacity    int        `json:"capacity"`
+	Status      string     `json:"status"`
+	Visibility  string     `json:"visibility"`
+
+	RecurrenceRule  string      `json:"recurrence_rule"`
+	RecurrenceDates []time.Time `json:"recurrence_dates"`
+	ExceptionDates  []time.Time `json:"exception_dates"`
+}
+
+func newEventFields(e *models.Event) eventFields {
+	recurrenceDates, _ := recurrence.ParseDates(e.RecurrenceDates)
+	exceptionDates, _ := recurrence.ParseDates(e.ExceptionDates)
+
+	return eventFields{
+		Name:        e.Name,
+		Description: e.Description,
+		StartsAt:    e.StartsAt,
+		EndsAt:      e.EndsAt,
+		TimeZone:    e.TimeZone,
+		AllDay:      e.AllDay,
+		Venue:       e.Venue,
+		Address:     e.Address,
+		Latitude:    e.Latitude,
+		Longitude:   e.Longitude,
+		Capacity:    e.Capacity,
+		Status:      e.Status,
+		Visibility:  e.Visibility,
+
+		RecurrenceRule:  e.RecurrenceRule,
+		RecurrenceDates: recurrenceDates,
+		ExceptionDates:  exceptionDates,
+	}
+}
+
+// apply copies the fields to the event. Times are stored in UTC, the zone
+// they are meant in defaults to UTC as well. The zone also decides the time
+// of day a recurring event keeps across DST changes.
+func (f eventFields) apply(e *models.Event) {
+	e.Name = f.Name
+	e.Description = f.Description
+	e.StartsAt = utc(f.StartsAt)
+	e.EndsAt = utc(f.EndsAt)
+	e.TimeZone = f.TimeZone
+	e.AllDay = f.AllDay
+	e.Venue = f.Venue
+	e.Address = f.Address
+	e.Latitude = f.Latitude
+	e.Longitude = f.Longitude
+	e.Capacity = f.Capacity
+	e.Status = f.Status
+	e.Visibility = f.Visibility
+	e.RecurrenceDates = recurrence.FormatDates(f.RecurrenceDates)
+	e.ExceptionDates = recurrence.FormatDates(f.ExceptionDates)
+
+	// a valid rule is stored in its canonical form, an invalid one as it is
+	// so validation reports it
+	e.RecurrenceRule = f.RecurrenceRule
+	if rule, err := recurrence.ParseRule(f.RecurrenceRule); err == nil {
+		e.RecurrenceRule = rule.String()
+	}
+
+	if e.TimeZone == "" && e.StartsAt != nil {
+		e.TimeZone = "UTC"
+	}
+
+	if e.Status == "" {
+		e.Status = models.EventDraft
+	}
+
+	if e.Visibility == "" {
+		e.Visibility = models.EventPrivate
+	}
+}
+
+// shareEvent gives an unlisted or public event its share link. Making the
+// event private drops the link, sharing it again creates a new one so links
+// that went too far stop working.
+func shareEvent(e *models.Event) error {
+	if e.Visibility == models.EventPrivate {
+		e.ShareSlug = nil
+		return nil
+	}
+	if e.ShareSlug != nil {
+		return nil
+	}
+
+	slug, err := security.NewRandomToken(16)
+	if err != nil {
+		return err
+	}
+	e.ShareSlug = &slug
+	return nil
+}
+
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	converted := t.UTC()
+	return &converted
+}
+
+// eventAccess decides whether the principal may use the event. The role is the
+// one of the user as a collaborator of the event, empty for everybody else.
+type eventAccess func(p *middlewares.Principal, e *models.Event, role string) bool
+
+func canManageEvent(p *middlewares.Principal, e *models.Event, role string) bool {
+	return policy.CanManageEvent(p, e)
+}
+
+// canViewEvent shows public events that are not drafts to every user, the
+// others only to the people working on them. Unlisted events are reached
+// through their share link instead.
+func canViewEvent(p *middlewares.Principal, e *models.Event, role string) bool {
+	return (e.Status != models.EventDraft && e.Visibility == models.EventPublic) || policy.CanViewEvent(p, e, role)
+}
+
+// collaboratorRole returns the role of the user as a collaborator of the
+// event, empty when the user is not one.
+func collaboratorRole(connection *gorm.DB, eventID uint, userID uint) (string, error) {
+	collaborator := models.EventCollaborator{}
+	result := connection.Where("event_id = ? AND user_id = ?", eventID, userID).Limit(1).Find(&collaborator)
+	return collaborator.Role, result.Error
+}
+
+// findEvent loads the event of the request and makes sure the user may use it.
+// Users that may not get the denied status. Deleted events are only found when
+// deleted is set.
+func findEvent(rw http.ResponseWriter, r *http.Request, connection *gorm.DB, deleted bool, allowed eventAccess, denied int) (*models.Event, bool) {
+	eventId, err := ParseEventId(r)
+	if err != nil || eventId == 0 {
+		responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+		return nil, false
+	}
+
+	event := models.Event{}
+	query := connection
+	if deleted {
+		query = connection.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	result := query.Find(&event, eventId)
+	if result.Error != nil {
+		responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+		return nil, false
+	}
+
+	if event.ID == 0 {
+		responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+		return nil, false
+	}
+
+	principal, ok := middlewares.PrincipalFromContext(r.Context())
+	if !ok {
+		responses.NewJsonResponse(rw, http.StatusUnauthorized, nil)
+		return nil, false
+	}
+
+	role, err := collaboratorRole(connection, event.ID, principal.User.ID)
+	if err != nil {
+		responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+		return nil, false
+	}
+
+	if !allowed(principal, &event, role) {
+		responses.NewJsonResponse(rw, denied, nil)
+		return nil, false
+	}
+
+	return &event, true
+}
+
+// findManagedEvent loads the event of the request and makes sure the user may
+// manage it. Deleted events are only found when deleted is set.
+func findManagedEvent(rw http.ResponseWriter, r *http.Request, connection *gorm.DB, deleted bool) (*models.Event, bool) {
+	return findEvent(rw, r, connection, deleted, canManageEvent, http.StatusForbidden)
+}
+
+// UpdateEvent replaces the fields of an event on PUT and applies a JSON Merge
+// Patch to them on PATCH. Editors may update the events shared with them, but
+// not their visibility.
+// Seats added to the capacity go to the waitlist.
+func UpdateEvent(connection *gorm.DB, m mailer.Mailer) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		if r.Method == http.MethodPatch {
+			if contentType := r.Header.Get("Content-Type"); contentType != "" {
+				mediaType, _, err := mime.ParseMediaType(contentType)
+				if err != nil || (mediaType != mergepatch.ContentType && mediaType != "application/json") {
+					responses.NewJsonResponse(rw, http.StatusUnsupportedMediaType, map[string]string{
+						"error": "Use " + mergepatch.ContentType + "!",
+					})
+					return
+				}
+			}
+		}
+
+		event, ok := findEvent(rw, r, connection, false, policy.CanEditEvent, http.StatusForbidden)
+		if !ok {
+			return
+		}
+
+		defer r.Body.Close()
+		body, err := ioutil.ReadAll(http.MaxBytesReader(rw, r.Body, maxEventBodySize))
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusRequestEntityTooLarge, nil)
+			return
+		}
+
+		if r.Method == http.MethodPatch {
+			document, err := json.Marshal(newEventFields(event))
+			if err != nil {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+
+			body, err = mergepatch.Apply(document, body)
+			if err != nil {
+				responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, nil)
+				return
+			}
+		}
+
+		fields := eventFields{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, nil)
+			return
+		}
+		// a PUT without a visibility keeps the event shared as it is
+		visibility := event.Visibility
+		if fields.Visibility == "" {
+			fields.Visibility = visibility
+		}
+		fields.apply(event)
+
+		// sharing the event is left to those who manage it
+		if principal, ok := middlewares.PrincipalFromContext(r.Context()); !ok || (event.Visibility != visibility && !policy.CanManageEvent(principal, event)) {
+			responses.NewJsonResponse(rw, http.StatusForbidden, map[string]string{
+				"error": "Only those who manage the event can change its visibility!",
+			})
+			return
+		}
+
+		errors := validation.Validate(*event)
+		if len(errors) != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, errors)
+			return
+		}
+
+		if err := shareEvent(event); err != nil {
 			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
 			return
 		}
 
-		if event.ID == 0 {
-			responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+		promoted := []models.Attendee{}
+		err = connection.Transaction(func(tx *gorm.DB) error {
+			locked, err := attendance.LockEvent(tx, event.ID)
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Save(event).Error; err != nil {
+				return err
+			}
+
+			// lowering the capacity keeps the seats already taken
+			if event.Capacity == locked.Capacity {
+				return nil
+			}
+			promoted, err = attendance.Promote(tx, event)
+			return err
+		})
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
 			return
 		}
 
+		attendance.Notify(m, event, promoted)
 		responses.NewJsonResponse(rw, http.StatusOK, event)
+	})
+}
+
+// DeleteEvent soft deletes an event. Its media is kept or removed according to
+// the retention.
+func DeleteEvent(connection *gorm.DB, uploaderService uploader.Uploader, retention MediaRetention) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		event, ok := findManagedEvent(rw, r, connection, false)
+		if !ok {
+			return
+		}
+
+		removed := []models.Media{}
+		err := connection.Transaction(func(tx *gorm.DB) error {
+			if retention == RemoveMedia {
+				if err := tx.Where("event_id = ?", event.ID).Find(&removed).Error; err != nil {
+					return err
+				}
 
+				if err := tx.Unscoped().Where("event_id = ?", event.ID).Delete(&models.Media{}).Error; err != nil {
+					return err
+				}
+			}
+
+			return tx.Delete(event).Error
+		})
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		media.RemoveFiles(connection, uploaderService, removed)
+
+		rw.WriteHeader(http.StatusNoContent)
 	})
 }
 
+// RestoreEvent brings back a deleted event. Events of deleted accounts are
+// gone for good, their media has been removed with the account.
+func RestoreEvent(connection *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		event, ok := findManagedEvent(rw, r, connection, true)
+		if !ok {
+			return
+		}
+
+		var owners int64
+		if result := connection.Model(&models.User{}).Where("id = ?", event.UserID).Count(&owners); result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+		if owners == 0 {
+			responses.NewJsonResponse(rw, http.StatusConflict, map[string]string{
+				"error": "The owner of the event has deleted the account!",
+			})
+			return
+		}
+
+		result := connection.Unscoped().Model(event).Update("deleted_at", nil)
+		if result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+		event.DeletedAt = gorm.DeletedAt{}
+
+		responses.NewJsonResponse(rw, http.StatusOK, event)
+	})
+}
+
+var eventPathRegex = regexp.MustCompile(`\/event\/(\d+)(\/|$)`)
+
+// ParseEventId reads the {event} route variable. Handlers called without the
+// router fall back to parsing the path.
 func ParseEventId(r *http.Request) (int, error) {
-	regex := regexp.MustCompile(`\/event\/(\d+)\/?$`)
-	regexResult := regex.FindAllStringSubmatch(r.URL.Path, 1)
-	eventIdString := regexResult[0][1]
-	return strconv.Atoi(eventIdString)
+	if eventIdString, ok := mux.Vars(r)["event"]; ok {
+		return strconv.Atoi(eventIdString)
+	}
+
+	regexResult := eventPathRegex.FindStringSubmatch(r.URL.Path)
+	if regexResult == nil {
+		return 0, errors.New("the path does not contain an event")
+	}
+	return strconv.Atoi(regexResult[1])
 }
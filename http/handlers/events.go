@@ -1,41 +1,118 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"site/database/models"
 	"site/http/middlewares"
+	"site/http/pagination"
 	"site/http/responses"
-	"site/security"
 
 	"gorm.io/gorm"
 )
 
-func GetEvents(connection *gorm.DB, t security.TokenSecurity) http.Handler {
+// eventListOptions are the sort keys of the event list.
+var eventListOptions = pagination.Options{
+	Sorts: map[string]pagination.Column{
+		"created_at": {Name: "created_at", Field: "CreatedAt", Time: true},
+		"starts_at":  {Name: "starts_at", Field: "StartsAt", Nullable: true, Time: true},
+		"name":       {Name: "name", Field: "Name"},
+		"id":         {Name: "id", Field: "ID"},
+	},
+	DefaultSort: "created_at",
+}
+
+// GetEvents lists the events of the authenticated user a page at a time. The
+// list can be filtered by name, status, the start of the event and when it was
+// created.
+func GetEvents(connection *gorm.DB) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
 			return
 		}
 
-		token := r.Header.Get(middlewares.AuthorizationHeader)
-		identifier, err := t.GetIdentifier(token)
-		if err != nil {
+		user, ok := middlewares.CurrentUser(r)
+		if !ok {
 			responses.NewJsonResponse(rw, http.StatusUnauthorized, nil)
 			return
 		}
 
-		user := models.User{}
-		result := connection.Preload("Events").Find(&user, identifier)
-		if result.Error != nil {
-			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+		query, err := pagination.Parse(r, eventListOptions)
+		if err != nil {
+			invalidListParameter(rw, err)
 			return
 		}
 
-		if user.ID == 0 {
-			responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+		filtered, err := filterEvents(r, connection.Model(&models.Event{}).Where("user_id = ?", user.ID))
+		if err != nil {
+			invalidListParameter(rw, err)
+			return
+		}
+
+		events := []models.Event{}
+		page, err := pagination.Find(filtered, query, &events)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
 			return
 		}
 
-		responses.NewJsonResponse(rw, http.StatusOK, user.Events)
+		pagination.SetLinks(rw, r, page)
+		responses.NewJsonResponse(rw, http.StatusOK, page)
+	})
+}
+
+// filterEvents applies the "name", "status", "from", "to" and
+// "created_after" query parameters.
+func filterEvents(r *http.Request, query *gorm.DB) (*gorm.DB, error) {
+	if name := r.URL.Query().Get("name"); name != "" {
+		condition, pattern := pagination.Contains("name", name)
+		query = query.Where(condition, pattern)
+	}
+
+	status, err := pagination.OneOfParam(r, "status", models.EventDraft, models.EventPublished, models.EventCancelled)
+	if err != nil {
+		return nil, err
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	from, err := pagination.TimeParam(r, "from")
+	if err != nil {
+		return nil, err
+	}
+	if from != nil {
+		query = query.Where("starts_at >= ?", from.UTC())
+	}
+
+	to, err := pagination.TimeParam(r, "to")
+	if err != nil {
+		return nil, err
+	}
+	if to != nil {
+		query = query.Where("starts_at < ?", to.UTC())
+	}
+
+	createdAfter, err := pagination.TimeParam(r, "created_after")
+	if err != nil {
+		return nil, err
+	}
+	if createdAfter != nil {
+		query = query.Where("created_at > ?", createdAfter.Local())
+	}
+
+	return query, nil
+}
+
+func invalidListParameter(rw http.ResponseWriter, err error) {
+	parameterError := pagination.ParameterError{}
+	if !errors.As(err, &parameterError) {
+		responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	responses.NewJsonResponse(rw, http.StatusBadRequest, map[string]string{
+		"error": "Invalid " + parameterError.Name + "!",
 	})
 }
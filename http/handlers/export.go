@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"site/database/models"
+	"site/export"
+	"site/http/middlewares"
+	"site/http/responses"
+	"site/security"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// ExportLinkTTL is how long a download link handed out by GetExport works.
+const ExportLinkTTL = 15 * time.Minute
+
+type ExportResponse struct {
+	ID          uint       `json:"id"`
+	Status      string     `json:"status"`
+	Size        int64      `json:"size,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+func newExportResponse(job *models.ExportJob) ExportResponse {
+	return ExportResponse{
+		ID:          job.ID,
+		Status:      job.Status,
+		Size:        job.Size,
+		CreatedAt:   job.CreatedAt,
+		CompletedAt: job.CompletedAt,
+		ExpiresAt:   job.ExpiresAt,
+	}
+}
+
+func exportPath(job *models.ExportJob) string {
+	return fmt.Sprintf("/me/export/%d", job.ID)
+}
+
+// RequestExport starts an export of the data of the authenticated user. Only
+// one export runs at a time, requesting another one while it is pending
+// returns the pending export.
+func RequestExport(connection *gorm.DB, queue export.Queue) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		user, ok := middlewares.InteractiveUser(rw, r)
+		if !ok {
+			return
+		}
+
+		job := models.ExportJob{}
+		connection.Where("user_id = ? AND status IN ?", user.ID, []string{models.ExportPending, models.ExportRunning}).
+			Limit(1).Find(&job)
+
+		if job.ID == 0 {
+			job = models.ExportJob{UserID: user.ID, Status: models.ExportPending}
+			if result := connection.Create(&job); result.Error != nil {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+			queue.Enqueue(job.ID)
+		}
+
+		rw.Header().Set("Location", exportPath(&job))
+		responses.NewJsonResponse(rw, http.StatusAccepted, newExportResponse(&job))
+	})
+}
+
+// GetExport shows the status of an export of the authenticated user. Once the
+// archive is ready the response contains a short lived download link.
+func GetExport(connection *gorm.DB, tokens security.PurposeTokens) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		user, ok := middlewares.InteractiveUser(rw, r)
+		if !ok {
+			return
+		}
+
+		job := models.ExportJob{}
+		connection.Where("id = ? AND user_id = ?", mux.Vars(r)["export"], user.ID).Find(&job)
+		if job.ID == 0 {
+			responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+			return
+		}
+
+		response := newExportResponse(&job)
+		if job.Status == models.ExportCompleted {
+			_, token, err := tokens.CreatePurposeToken(security.PurposeExportDownload, strconv.Itoa(int(job.ID)), ExportLinkTTL)
+			if err != nil {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+			response.DownloadURL = exportPath(&job) + "/download?" + url.Values{"token": {token}}.Encode()
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, response)
+	})
+}
+
+// DownloadExport serves the archive of a finished export. The signed token of
+// the link is the only credential, so the link works from a browser.
+func DownloadExport(connection *gorm.DB, tokens security.PurposeTokens) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		invalid := map[string]string{
+			"error": "The download link is invalid or has expired!",
+		}
+
+		claims, err := tokens.ParsePurposeToken(r.URL.Query().Get("token"), security.PurposeExportDownload)
+		if err != nil || claims.Subject != mux.Vars(r)["export"] {
+			responses.NewJsonResponse(rw, http.StatusForbidden, invalid)
+			return
+		}
+
+		job := models.ExportJob{}
+		connection.Find(&job, claims.Subject)
+		if job.ID == 0 || job.Status != models.ExportCompleted || job.ExpiresAt == nil || time.Now().After(*job.ExpiresAt) {
+			responses.NewJsonResponse(rw, http.StatusGone, invalid)
+			return
+		}
+
+		file, err := os.Open(job.Path)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusGone, invalid)
+			return
+		}
+		defer file.Close()
+
+		rw.Header().Set("Content-Type", "application/zip")
+		rw.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%d.zip"`, job.ID))
+		rw.Header().Set("Cache-Control", "no-store")
+		http.ServeContent(rw, r, "", *job.CompletedAt, file)
+	})
+}
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"site/database/models"
+	"site/http/middlewares"
+	"site/http/responses"
+	"site/ical"
+	"site/validation"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxImportSize limits the size of imported calendars.
+const maxImportSize = 5 << 20
+
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportSkipped = "skipped"
+)
+
+// ImportItem reports what happened to an event of an imported calendar.
+type ImportItem struct {
+	UID     string `json:"uid"`
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	EventID uint   `json:"event_id,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+type ImportReport struct {
+	Created int          `json:"created"`
+	Updated int          `json:"updated"`
+	Skipped int          `json:"skipped"`
+	Items   []ImportItem `json:"items"`
+}
+
+func (report *ImportReport) add(item ImportItem) {
+	switch item.Status {
+	case ImportCreated:
+		report.Created++
+	case ImportUpdated:
+		report.Updated++
+	default:
+		report.Skipped++
+	}
+	report.Items = append(report.Items, item)
+}
+
+// ImportEvents creates the events of an iCalendar file for the authenticated
+// user, sent as the "file" of a form or as a text/calendar body. Events are
+// matched by UID, importing a calendar again updates the events that changed.
+// Changed and cancelled occurrences become exceptions of their series.
+func ImportEvents(connection *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		user, ok := middlewares.CurrentUser(r)
+		if !ok {
+			responses.NewJsonResponse(rw, http.StatusUnauthorized, nil)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(rw, r.Body, maxImportSize)
+		var body io.Reader
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "multipart/form-data":
+			if err := r.ParseMultipartForm(maxImportSize); err != nil {
+				responses.NewJsonResponse(rw, http.StatusRequestEntityTooLarge, nil)
+				return
+			}
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, map[string]string{
+					"file": "required",
+				})
+				return
+			}
+			defer file.Close()
+			body = file
+		case "text/calendar":
+			body = r.Body
+		default:
+			responses.NewJsonResponse(rw, http.StatusUnsupportedMediaType, map[string]string{
+				"error": "Upload a text/calendar file!",
+			})
+			return
+		}
+
+		entries, err := ical.ReadEvents(body)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, map[string]string{
+				"error": "The file is not a valid iCalendar file!",
+			})
+			return
+		}
+
+		report := ImportReport{Items: []ImportItem{}}
+		err = connection.Transaction(func(tx *gorm.DB) error {
+			return importEvents(tx, user.ID, entries, &report)
+		})
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, report)
+	})
+}
+
+func importEvents(tx *gorm.DB, userID uint, entries []ical.Entry, report *ImportReport) error {
+	skip := func(e ical.Event, reason string) {
+		report.add(ImportItem{UID: e.UID, Name: e.Summary, Status: ImportSkipped, Reason: reason})
+	}
+
+	series := []ical.Event{}
+	occurrences := map[string][]ical.Event{}
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		switch {
+		case entry.Err != nil:
+			skip(entry.Event, entry.Err.Error())
+		case entry.Event.RecurrenceID != nil:
+			occurrences[entry.Event.UID] = append(occurrences[entry.Event.UID], entry.Event)
+		case seen[entry.Event.UID]:
+			skip(entry.Event, "the UID is repeated")
+		default:
+			seen[entry.Event.UID] = true
+			series = append(series, entry.Event)
+		}
+	}
+
+	for _, e := range series {
+		item, err := importEvent(tx, userID, e, occurrences[e.UID])
+		if err != nil {
+			return err
+		}
+		report.add(item)
+	}
+
+	for _, entry := range entries {
+		if entry.Err == nil && entry.Event.RecurrenceID != nil && !seen[entry.Event.UID] {
+			skip(entry.Event, "the calendar does not contain the recurring event")
+		}
+	}
+	return nil
+}
+
+// importedStatus maps the STATUS of a VEVENT, events without one are
+// published.
+func importedStatus(status string) string {
+	switch status {
+	case ical.StatusTentative:
+		return models.EventDraft
+	case ical.StatusCancelled:
+		return models.EventCancelled
+	default:
+		return models.EventPublished
+	}
+}
+
+// importSnapshot is what an import may change, it tells whether importing an
+// event again changed it.
+type importSnapshot struct {
+	Fields      eventFields
+	Attachments []string
+	Exceptions  []importedException
+}
+
+type importedException struct {
+	OccurrenceAt time.Time
+	Cancelled    bool
+	Name         string
+	Description  string
+	StartsAt     *time.Time
+	EndsAt       *time.Time
+	Venue        string
+}
+
+func newImportSnapshot(event *models.Event, attachments []string, exceptions []models.EventException) string {
+	fields := newEventFields(event)
+	fields.StartsAt, fields.EndsAt = utc(fields.StartsAt), utc(fields.EndsAt)
+
+	snapshot := importSnapshot{Fields: fields, Attachments: attachments, Exceptions: []importedException{}}
+	for _, e := range exceptions {
+		snapshot.Exceptions = append(snapshot.Exceptions, importedException{
+			OccurrenceAt: e.OccurrenceAt.UTC(),
+			Cancelled:    e.Cancelled,
+			Name:         e.Name,
+			Description:  e.Description,
+			StartsAt:     utc(e.StartsAt),
+			EndsAt:       utc(e.EndsAt),
+			Venue:        e.Venue,
+		})
+	}
+	sort.Slice(snapshot.Exceptions, func(i, j int) bool {
+		return snapshot.Exceptions[i].OccurrenceAt.Before(snapshot.Exceptions[j].OccurrenceAt)
+	})
+
+	encoded, _ := json.Marshal(snapshot)
+	return string(encoded)
+}
+
+// importEvent creates or updates the event with the UID of e. Occurrences
+// that are not part of the series are left out.
+func importEvent(tx *gorm.DB, userID uint, e ical.Event, occurrences []ical.Event) (ImportItem, error) {
+	item := ImportItem{UID: e.UID, Name: e.Summary, Status: ImportCreated}
+
+	event := models.Event{}
+	if result := tx.Where("user_id = ? AND uid = ?", userID, e.UID).Limit(1).Find(&event); result.Error != nil {
+		return item, result.Error
+	}
+
+	previous := models.Event{}
+	previousAttachments := []string{}
+	previousExceptions := []models.EventException{}
+	if event.ID != 0 {
+		item.Status, item.EventID = ImportUpdated, event.ID
+		previous = event
+
+		attachments := []models.EventAttachment{}
+		if result := tx.Where("event_id = ?", event.ID).Order("id").Find(&attachments); result.Error != nil {
+			return item, result.Error
+		}
+		for _, attachment := range attachments {
+			previousAttachments = append(previousAttachments, attachment.URL)
+		}
+		if result := tx.Where("event_id = ?", event.ID).Find(&previousExceptions); result.Error != nil {
+			return item, result.Error
+		}
+	}
+
+	end := e.End
+	if end != nil && !end.After(e.Start) {
+		end = nil
+	}
+	fields := eventFields{
+		Name:            e.Summary,
+		Description:     e.Description,
+		StartsAt:        &e.Start,
+		EndsAt:          end,
+		TimeZone:        e.TimeZone,
+		AllDay:          e.AllDay,
+		Venue:           e.Location,
+		Latitude:        e.Latitude,
+		Longitude:       e.Longitude,
+		Capacity:        event.Capacity,
+		Status:          importedStatus(e.Status),
+		Visibility:      event.Visibility,
+		RecurrenceRule:  e.RecurrenceRule,
+		RecurrenceDates: e.RecurrenceDates,
+		ExceptionDates:  e.ExceptionDates,
+	}
+	fields.apply(&event)
+	event.UserID = userID
+	event.UID = e.UID
+
+	if errors := validation.Validate(event); len(errors) != 0 {
+		item.Status = ImportSkipped
+		item.Reason = invalidFields(errors)
+		return item, nil
+	}
+
+	exceptions := importedExceptions(&event, occurrences)
+	attachments := e.Attachments
+	if attachments == nil {
+		attachments = []string{}
+	}
+
+	if event.ID != 0 && newImportSnapshot(&previous, previousAttachments, previousExceptions) == newImportSnapshot(&event, attachments, exceptions) {
+		item.Status = ImportSkipped
+		item.Reason = "unchanged"
+		return item, nil
+	}
+
+	if result := tx.Omit("Attachments").Save(&event); result.Error != nil {
+		return item, result.Error
+	}
+	item.EventID = event.ID
+
+	// unscoped, the unique index would keep soft deleted exceptions
+	if result := tx.Unscoped().Where("event_id = ?", event.ID).Delete(&models.EventException{}); result.Error != nil {
+		return item, result.Error
+	}
+	for i := range exceptions {
+		exceptions[i].EventID = event.ID
+		if result := tx.Create(&exceptions[i]); result.Error != nil {
+			return item, result.Error
+		}
+	}
+
+	if result := tx.Unscoped().Where("event_id = ?", event.ID).Delete(&models.EventAttachment{}); result.Error != nil {
+		return item, result.Error
+	}
+	for _, url := range attachments {
+		if result := tx.Create(&models.EventAttachment{EventID: event.ID, URL: url}); result.Error != nil {
+			return item, result.Error
+		}
+	}
+
+	return item, nil
+}
+
+// importedExceptions turns the changed occurrences of a series into
+// exceptions. Only what differs from the series is kept.
+func importedExceptions(event *models.Event, occurrences []ical.Event) []models.EventException {
+	exceptions := []models.EventException{}
+	set, ok := recurrenceSet(event)
+	if !ok {
+		return exceptions
+	}
+
+	seen := map[int64]bool{}
+	for _, o := range occurrences {
+		at := o.RecurrenceID.UTC()
+		if seen[at.UnixNano()] || !set.Contains(at) {
+			continue
+		}
+		seen[at.UnixNano()] = true
+
+		exception := models.EventException{OccurrenceAt: at, Cancelled: o.Status == ical.StatusCancelled}
+		if o.Summary != event.Name {
+			exception.Name = o.Summary
+		}
+		if o.Description != event.Description {
+			exception.Description = o.Description
+		}
+		if o.Location != event.Venue {
+			exception.Venue = o.Location
+		}
+
+		scheduled := newOccurrenceResponse(event, at, nil)
+		moved := !o.Start.Equal(scheduled.StartsAt)
+		if o.End != nil && o.End.After(o.Start) && (scheduled.EndsAt == nil || o.End.Sub(o.Start) != scheduled.EndsAt.Sub(scheduled.StartsAt)) {
+			moved = true
+		}
+		if moved {
+			exception.StartsAt = utc(&o.Start)
+			if o.End != nil && o.End.After(o.Start) {
+				exception.EndsAt = utc(o.End)
+			}
+		}
+
+		if len(validation.Validate(exception)) == 0 {
+			exceptions = append(exceptions, exception)
+		}
+	}
+	return exceptions
+}
+
+// invalidFields describes validation errors like "Name: min".
+func invalidFields(errors map[string]string) string {
+	fields := []string{}
+	for field, tag := range errors {
+		fields = append(fields, field+": "+tag)
+	}
+	sort.Strings(fields)
+	return "invalid " + strings.Join(fields, ", ")
+}
@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"site/attendance"
+	"site/database/models"
+	"site/http/middlewares"
+	"site/http/pagination"
+	"site/http/responses"
+	"site/mailer"
+	"site/security"
+	"site/validation"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// InvitationTTL is how long the links of an invitation work.
+const InvitationTTL = 30 * 24 * time.Hour
+
+const (
+	InvitationSent    = "invited"
+	InvitationResent  = "resent"
+	InvitationSkipped = "skipped"
+	InvitationFailed  = "failed"
+)
+
+// InviteRequest invites people to an event by email.
+type InviteRequest struct {
+	Emails []string `json:"emails" validate:"required,min=1,max=100,dive,required,email,max=255"`
+}
+
+// InviteItem reports what happened to an address of an InviteRequest.
+type InviteItem struct {
+	Email        string `json:"email"`
+	Status       string `json:"status"`
+	InvitationID uint   `json:"invitation_id,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+type InviteReport struct {
+	Invited int          `json:"invited"`
+	Resent  int          `json:"resent"`
+	Skipped int          `json:"skipped"`
+	Failed  int          `json:"failed"`
+	Items   []InviteItem `json:"items"`
+}
+
+func (report *InviteReport) add(item InviteItem) {
+	switch item.Status {
+	case InvitationSent:
+		report.Invited++
+	case InvitationResent:
+		report.Resent++
+	case InvitationFailed:
+		report.Failed++
+	default:
+		report.Skipped++
+	}
+	report.Items = append(report.Items, item)
+}
+
+type InvitationResponse struct {
+	ID          uint       `json:"id"`
+	EventID     uint       `json:"event_id"`
+	Email       string     `json:"email"`
+	Status      string     `json:"status"`
+	UserID      *uint      `json:"user_id,omitempty"`
+	SentAt      *time.Time `json:"sent_at,omitempty"`
+	RespondedAt *time.Time `json:"responded_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func newInvitationResponse(invitation *models.Invitation) InvitationResponse {
+	return InvitationResponse{
+		ID:          invitation.ID,
+		EventID:     invitation.EventID,
+		Email:       invitation.Email,
+		Status:      invitation.Status,
+		UserID:      invitation.UserID,
+		SentAt:      invitation.SentAt,
+		RespondedAt: invitation.RespondedAt,
+		ExpiresAt:   invitation.ExpiresAt,
+		CreatedAt:   invitation.CreatedAt,
+	}
+}
+
+// invitationListOptions are the sort keys of the invitation list.
+var invitationListOptions = pagination.Options{
+	Sorts: map[string]pagination.Column{
+		"created_at": {Name: "created_at", Field: "CreatedAt", Time: true},
+		"email":      {Name: "email", Field: "Email"},
+		"id":         {Name: "id", Field: "ID"},
+	},
+	DefaultSort: "created_at",
+}
+
+// Invitations lists the invitations of an event to its organizer on GET and
+// invites a list of addresses on POST. Pending invitations are sent again
+// with a new link, which replaces the old one. Answered invitations are left
+// alone.
+func Invitations(connection *gorm.DB, tokens security.PurposeTokens, m mailer.Mailer) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		event, ok := findManagedEvent(rw, r, connection, false)
+		if !ok {
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			listInvitations(rw, r, connection, event)
+			return
+		}
+
+		request := InviteRequest{}
+		if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, maxEventBodySize)).Decode(&request); err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, nil)
+			return
+		}
+
+		errors := validation.Validate(request)
+		if len(errors) != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, errors)
+			return
+		}
+
+		user, ok := middlewares.CurrentUser(r)
+		if !ok {
+			responses.NewJsonResponse(rw, http.StatusUnauthorized, nil)
+			return
+		}
+
+		report := InviteReport{Items: []InviteItem{}}
+		seen := map[string]bool{}
+		for _, address := range request.Emails {
+			email := attendance.NormalizeEmail(address)
+			if seen[email] {
+				report.add(InviteItem{Email: email, Status: InvitationSkipped, Reason: "the email is repeated"})
+				continue
+			}
+			seen[email] = true
+
+			item, err := invite(connection, tokens, m, event, user, email)
+			if err != nil {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+			report.add(item)
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, report)
+	})
+}
+
+// invite stores the invitation of the address and mails it. The invitation
+// is kept when the message can not be sent, inviting the address again
+// retries it.
+func invite(connection *gorm.DB, tokens security.PurposeTokens, m mailer.Mailer, event *models.Event, user *models.User, email string) (InviteItem, error) {
+	item := InviteItem{Email: email, Status: InvitationSent}
+
+	invitation := models.Invitation{}
+	if result := connection.Where("event_id = ? AND email = ?", event.ID, email).Limit(1).Find(&invitation); result.Error != nil {
+		return item, result.Error
+	}
+	if invitation.ID != 0 {
+		item.InvitationID = invitation.ID
+		if invitation.Status != models.InvitationPending {
+			item.Status, item.Reason = InvitationSkipped, "the invitation has been "+invitation.Status
+			return item, nil
+		}
+		item.Status = InvitationResent
+	}
+
+	invitation.EventID = event.ID
+	invitation.Email = email
+	invitation.InvitedByID = user.ID
+	invitation.Status = models.InvitationPending
+
+	// the token names the invitation, a new one is stored before it is
+	// signed with a placeholder for the unique token ID
+	if invitation.ID == 0 {
+		placeholder, err := security.NewRandomToken(16)
+		if err != nil {
+			return item, err
+		}
+		invitation.TokenID = placeholder
+		invitation.ExpiresAt = time.Now().Add(InvitationTTL)
+		if result := connection.Create(&invitation); result.Error != nil {
+			return item, result.Error
+		}
+		item.InvitationID = invitation.ID
+	}
+
+	claims, token, err := tokens.CreatePurposeToken(security.PurposeInvitation, strconv.Itoa(int(invitation.ID)), InvitationTTL)
+	if err != nil {
+		return item, err
+	}
+
+	now := time.Now()
+	invitation.TokenID = claims.ID
+	invitation.ExpiresAt = claims.ExpiresAt
+	invitation.SentAt = &now
+	if result := connection.Save(&invitation); result.Error != nil {
+		return item, result.Error
+	}
+
+	if err := m.Send(mailer.InvitationMessage(email, event.Name, token)); err != nil {
+		log.Printf("Failed to send the invitation %d %s \n", invitation.ID, err)
+		connection.Model(&invitation).Update("sent_at", nil)
+		item.Status, item.Reason = InvitationFailed, "the email could not be sent"
+	}
+	return item, nil
+}
+
+// listInvitations lists the invitations of the event a page at a time. The
+// list can be filtered by status.
+func listInvitations(rw http.ResponseWriter, r *http.Request, connection *gorm.DB, event *models.Event) {
+	query, err := pagination.Parse(r, invitationListOptions)
+	if err != nil {
+		invalidListParameter(rw, err)
+		return
+	}
+
+	filtered := connection.Model(&models.Invitation{}).Where("event_id = ?", event.ID)
+	status, err := pagination.OneOfParam(r, "status", models.InvitationPending, models.InvitationAccepted, models.InvitationDeclined)
+	if err != nil {
+		invalidListParameter(rw, err)
+		return
+	}
+	if status != "" {
+		filtered = filtered.Where("status = ?", status)
+	}
+
+	invitations := []models.Invitation{}
+	page, err := pagination.Find(filtered, query, &invitations)
+	if err != nil {
+		responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+		return
+	}
+
+	data := make([]InvitationResponse, len(invitations))
+	for i := range invitations {
+		data[i] = newInvitationResponse(&invitations[i])
+	}
+	page.Data = data
+
+	pagination.SetLinks(rw, r, page)
+	responses.NewJsonResponse(rw, http.StatusOK, page)
+}
+
+type InvitationAnswerRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// InvitationAnswerResponse tells the invitee how the answer has been
+// recorded. Attendance is the status of the RSVP of an invitee with an
+// account, e.g. "waitlisted" when the event is full.
+type InvitationAnswerResponse struct {
+	Status     string     `json:"status"`
+	EventID    uint       `json:"event_id"`
+	EventName  string     `json:"event_name"`
+	StartsAt   *time.Time `json:"starts_at,omitempty"`
+	Attendance string     `json:"attendance,omitempty"`
+}
+
+// InvitationConfirmation is shown for an emailed invitation link. Nothing
+// has been answered yet, the answer is recorded by posting the token back.
+type InvitationConfirmation struct {
+	Answer    string     `json:"answer"`
+	Status    string     `json:"status"`
+	EventID   uint       `json:"event_id"`
+	EventName string     `json:"event_name"`
+	StartsAt  *time.Time `json:"starts_at,omitempty"`
+	Token     string     `json:"token"`
+}
+
+// AnswerInvitation accepts or declines an invitation without logging in. GET
+// only shows what the emailed link is about to answer, so mail scanners that
+// follow links answer nothing, and POST records the answer. The token is read
+// from the "token" query parameter so the emailed links work, or from a JSON
+// body. The answer can be changed through the other link until it expires.
+// Invitees with an account get an RSVP right away, others once they verify the
+// invited address.
+func AnswerInvitation(connection *gorm.DB, tokens security.PurposeTokens, m mailer.Mailer, status string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		request := InvitationAnswerRequest{Token: r.URL.Query().Get("token")}
+		if request.Token == "" && r.Method == http.MethodPost {
+			defer r.Body.Close()
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, nil)
+				return
+			}
+		}
+
+		validationErrors := validation.Validate(request)
+		if len(validationErrors) != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, validationErrors)
+			return
+		}
+
+		invalid := map[string]string{
+			"error": "The invitation link is invalid or has expired!",
+		}
+
+		claims, err := tokens.ParsePurposeToken(request.Token, security.PurposeInvitation)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, invalid)
+			return
+		}
+
+		invitation := models.Invitation{}
+		if result := connection.Where("token_id = ?", claims.ID).Limit(1).Find(&invitation); result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+		if invitation.ID == 0 || strconv.Itoa(int(invitation.ID)) != claims.Subject {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, invalid)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			event := models.Event{}
+			if result := connection.Find(&event, invitation.EventID); result.Error != nil || event.ID == 0 {
+				responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, invalid)
+				return
+			}
+
+			responses.NewJsonResponse(rw, http.StatusOK, InvitationConfirmation{
+				Answer:    status,
+				Status:    invitation.Status,
+				EventID:   event.ID,
+				EventName: event.Name,
+				StartsAt:  event.StartsAt,
+				Token:     request.Token,
+			})
+			return
+		}
+
+		invitee, err := attendance.FindInvitee(connection, &invitation)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		result, err := attendance.AnswerInvitation(connection, &invitation, invitee, status)
+		if errors.Is(err, attendance.ErrEventNotFound) {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, invalid)
+			return
+		}
+		if err != nil {
+			answerFailed(rw, err)
+			return
+		}
+		attendance.Notify(m, &result.Event, result.Promoted)
+
+		event := models.Event{}
+		if result := connection.Find(&event, invitation.EventID); result.Error != nil || event.ID == 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, invalid)
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, InvitationAnswerResponse{
+			Status:     invitation.Status,
+			EventID:    event.ID,
+			EventName:  event.Name,
+			StartsAt:   event.StartsAt,
+			Attendance: result.Attendee.Status,
+		})
+	})
+}
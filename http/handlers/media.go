@@ -3,18 +3,42 @@ package handlers
 import (
 	"log"
 	"net/http"
-	"os"
+	"path/filepath"
+	"regexp"
 	"site/database/models"
 	"site/http/middlewares"
 	"site/http/responses"
+	"site/policy"
 	"site/security"
 	"site/uploader"
 	"strconv"
+	"strings"
 
 	"gorm.io/gorm"
 )
 
-func CreateMedia(connection *gorm.DB, security security.TokenSecurity, uploaderService uploader.Uploader) http.Handler {
+// mediaExtensionRegex limits the extensions kept on stored media files.
+var mediaExtensionRegex = regexp.MustCompile(`^\.[a-z0-9]{1,10}$`)
+
+// mediaFileName is the name a media file is stored under. Names are random so
+// uploads never replace each other, only a plain extension of the uploaded
+// name is kept.
+func mediaFileName(uploaded string) (string, error) {
+	name, err := security.NewRandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	extension := strings.ToLower(filepath.Ext(uploaded))
+	if mediaExtensionRegex.MatchString(extension) {
+		name += extension
+	}
+	return name, nil
+}
+
+// CreateMedia stores a file uploaded to an event in a directory of the event
+// under mediaDir.
+func CreateMedia(connection *gorm.DB, uploaderService uploader.Uploader, mediaDir string) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
@@ -29,28 +53,26 @@ func CreateMedia(connection *gorm.DB, security security.TokenSecurity, uploaderS
 
 		event := models.Event{}
 		result := connection.Find(&event, eventId)
-		if result.Error != nil || event.ID != 0 {
+		if result.Error != nil || event.ID == 0 {
 			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, map[string]string{
-				"error": "The event can be found!",
+				"error": "The event can not be found!",
 			})
 			return
 		}
 
-		identifier := r.Header.Get(middlewares.AuthorizationHeader)
-		userId, err := security.GetIdentifier(identifier)
-		if err != nil {
+		principal, ok := middlewares.PrincipalFromContext(r.Context())
+		if !ok {
 			responses.NewJsonResponse(rw, http.StatusUnauthorized, nil)
 			return
 		}
 
-		user := models.User{}
-		result = connection.Find(&user, userId)
-		if result.Error != nil {
-			responses.NewJsonResponse(rw, http.StatusUnauthorized, nil)
+		role, err := collaboratorRole(connection, event.ID, principal.User.ID)
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
 			return
 		}
 
-		if event.UserID != user.ID {
+		if !policy.CanUploadMedia(principal, &event, role) {
 			responses.NewJsonResponse(rw, http.StatusForbidden, nil)
 			return
 		}
@@ -62,13 +84,13 @@ func CreateMedia(connection *gorm.DB, security security.TokenSecurity, uploaderS
 			return
 		}
 
-		dir, err := os.Getwd()
+		name, err := mediaFileName(fileHeader.Filename)
 		if err != nil {
 			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
 			return
 		}
 
-		path, err := uploaderService.Upload("test.jpg", dir+"/files/", file)
+		path, err := uploaderService.Upload(name, mediaDir+strconv.Itoa(int(event.ID))+"/", file)
 		if err != nil {
 			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, map[string]string{
 				"error": "File can not be uploaded",
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+)
+
+// MediaRetention decides what happens to the media of a deleted event.
+type MediaRetention string
+
+const (
+	// RetainMedia keeps the media, so it comes back when the event is
+	// restored.
+	RetainMedia MediaRetention = "retain"
+	// RemoveMedia deletes the media records and their files together with
+	// the event.
+	RemoveMedia MediaRetention = "remove"
+)
+
+// MediaRetentionFromEnv reads EVENT_MEDIA_RETENTION, defaulting to
+// RetainMedia.
+func MediaRetentionFromEnv() (MediaRetention, error) {
+	switch retention := MediaRetention(os.Getenv("EVENT_MEDIA_RETENTION")); retention {
+	case "":
+		return RetainMedia, nil
+	case RetainMedia, RemoveMedia:
+		return retention, nil
+	default:
+		return "", fmt.Errorf("unknown media retention %q", retention)
+	}
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"site/database/models"
+	"site/http/pagination"
+	"site/http/responses"
+	"site/policy"
+	"site/recurrence"
+	"site/validation"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxOccurrences limits the occurrences listed at once.
+	MaxOccurrences = 1000
+	// MaxOccurrenceWindow is the longest time span occurrences are listed for.
+	MaxOccurrenceWindow = 366 * 24 * time.Hour
+	// defaultOccurrenceWindow is listed when the request has no "to".
+	defaultOccurrenceWindow = 90 * 24 * time.Hour
+)
+
+// OccurrenceResponse is a single occurrence of an event with its exception
+// applied. OccurrenceAt is when the series scheduled it and identifies it,
+// StartsAt differs when the occurrence has been moved.
+type OccurrenceResponse struct {
+	EventID      uint       `json:"event_id"`
+	OccurrenceAt time.Time  `json:"occurrence_at"`
+	StartsAt     time.Time  `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at,omitempty"`
+	TimeZone     string     `json:"time_zone"`
+	AllDay       bool       `json:"all_day"`
+	Name         string     `json:"name"`
+	Description  string     `json:"description"`
+	Venue        string     `json:"venue"`
+	Address      string     `json:"address"`
+	Cancelled    bool       `json:"cancelled"`
+	Modified     bool       `json:"modified"`
+}
+
+// OccurrenceRequest changes a single occurrence. Empty fields keep the values
+// of the event.
+type OccurrenceRequest struct {
+	Cancelled   bool       `json:"cancelled"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	StartsAt    *time.Time `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at"`
+	Venue       string     `json:"venue"`
+	Address     string     `json:"address"`
+}
+
+// recurrenceSet returns the series of an event. Events without a start have
+// no occurrences.
+func recurrenceSet(event *models.Event) (recurrence.Set, bool) {
+	if event.StartsAt == nil {
+		return recurrence.Set{}, false
+	}
+
+	location, err := time.LoadLocation(event.TimeZone)
+	if err != nil || event.TimeZone == "" {
+		location = time.UTC
+	}
+
+	set := recurrence.Set{Start: event.StartsAt.In(location)}
+	if event.RecurrenceRule != "" {
+		if rule, err := recurrence.ParseRule(event.RecurrenceRule); err == nil {
+			set.Rule = &rule
+		}
+	}
+	set.RDates, _ = recurrence.ParseDates(event.RecurrenceDates)
+	set.ExDates, _ = recurrence.ParseDates(event.ExceptionDates)
+	return set, true
+}
+
+func newOccurrenceResponse(event *models.Event, at time.Time, exception *models.EventException) OccurrenceResponse {
+	response := OccurrenceResponse{
+		EventID:      event.ID,
+		OccurrenceAt: at.UTC(),
+		StartsAt:     at.UTC(),
+		TimeZone:     event.TimeZone,
+		AllDay:       event.AllDay,
+		Name:         event.Name,
+		Description:  event.Description,
+		Venue:        event.Venue,
+		Address:      event.Address,
+	}
+
+	duration := time.Duration(0)
+	if event.EndsAt != nil {
+		duration = event.EndsAt.Sub(*event.StartsAt)
+	}
+
+	if exception != nil {
+		response.Modified = true
+		response.Cancelled = exception.Cancelled
+		if exception.Name != "" {
+			response.Name = exception.Name
+		}
+		if exception.Description != "" {
+			response.Description = exception.Description
+		}
+		if exception.Venue != "" {
+			response.Venue = exception.Venue
+		}
+		if exception.Address != "" {
+			response.Address = exception.Address
+		}
+		if exception.StartsAt != nil {
+			response.StartsAt = exception.StartsAt.UTC()
+		}
+		if exception.EndsAt != nil {
+			endsAt := exception.EndsAt.UTC()
+			response.EndsAt = &endsAt
+			return response
+		}
+	}
+
+	if event.EndsAt != nil {
+		endsAt := response.StartsAt.Add(duration)
+		response.EndsAt = &endsAt
+	}
+	return response
+}
+
+// GetOccurrences lists the occurrences of an event starting between the
+// "from" and "to" query parameters, which default to now and 90 days later.
+// Cancelled occurrences are listed as such, moved ones at their new time.
+func GetOccurrences(connection *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		event, ok := findEvent(rw, r, connection, false, canViewEvent, http.StatusNotFound)
+		if !ok {
+			return
+		}
+
+		from, err := pagination.TimeParam(r, "from")
+		if err != nil {
+			invalidListParameter(rw, err)
+			return
+		}
+		if from == nil {
+			now := time.Now()
+			from = &now
+		}
+
+		to, err := pagination.TimeParam(r, "to")
+		if err != nil {
+			invalidListParameter(rw, err)
+			return
+		}
+		if to == nil {
+			end := from.Add(defaultOccurrenceWindow)
+			to = &end
+		}
+		if !to.After(*from) || to.Sub(*from) > MaxOccurrenceWindow {
+			invalidListParameter(rw, pagination.ParameterError{Name: "to"})
+			return
+		}
+
+		occurrences := []OccurrenceResponse{}
+		set, ok := recurrenceSet(event)
+		if !ok {
+			responses.NewJsonResponse(rw, http.StatusOK, occurrences)
+			return
+		}
+
+		exceptions := []models.EventException{}
+		if result := connection.Where("event_id = ?", event.ID).Find(&exceptions); result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+		byOccurrence := map[int64]*models.EventException{}
+		for i := range exceptions {
+			byOccurrence[exceptions[i].OccurrenceAt.UnixNano()] = &exceptions[i]
+		}
+
+		inWindow := func(t time.Time) bool {
+			return !t.Before(*from) && t.Before(*to)
+		}
+
+		for _, at := range set.Between(*from, *to, MaxOccurrences) {
+			occurrence := newOccurrenceResponse(event, at, byOccurrence[at.UnixNano()])
+			if inWindow(occurrence.StartsAt) {
+				occurrences = append(occurrences, occurrence)
+			}
+		}
+
+		// occurrences moved into the window from outside of it
+		for i := range exceptions {
+			exception := &exceptions[i]
+			if exception.StartsAt == nil || !inWindow(*exception.StartsAt) || inWindow(exception.OccurrenceAt) {
+				continue
+			}
+			if set.Contains(exception.OccurrenceAt) {
+				occurrences = append(occurrences, newOccurrenceResponse(event, exception.OccurrenceAt, exception))
+			}
+		}
+
+		sort.SliceStable(occurrences, func(i, j int) bool {
+			return occurrences[i].StartsAt.Before(occurrences[j].StartsAt)
+		})
+		if len(occurrences) > MaxOccurrences {
+			occurrences = occurrences[:MaxOccurrences]
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, occurrences)
+	})
+}
+
+// Occurrence changes or cancels a single occurrence of an event on PUT and
+// brings back the occurrence as the series schedules it on DELETE. The
+// occurrence is the RFC 3339 time the series scheduled it at. Editors may
+// change the occurrences of events shared with them.
+func Occurrence(connection *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		event, ok := findEvent(rw, r, connection, false, policy.CanEditEvent, http.StatusForbidden)
+		if !ok {
+			return
+		}
+
+		at, err := time.Parse(time.RFC3339Nano, mux.Vars(r)["occurrence"])
+		if err != nil {
+			responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+			return
+		}
+		at = at.UTC()
+
+		set, ok := recurrenceSet(event)
+		if !ok || !set.Contains(at) {
+			responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			// unscoped, the unique index would keep a soft deleted exception
+			result := connection.Unscoped().Where("event_id = ? AND occurrence_at = ?", event.ID, at).Delete(&models.EventException{})
+			if result.Error != nil {
+				responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+				return
+			}
+			if result.RowsAffected == 0 {
+				responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+				return
+			}
+
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		request := OccurrenceRequest{}
+		if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, maxEventBodySize)).Decode(&request); err != nil {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, nil)
+			return
+		}
+
+		exception := models.EventException{}
+		if result := connection.Where("event_id = ? AND occurrence_at = ?", event.ID, at).Find(&exception); result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		exception.EventID = event.ID
+		exception.OccurrenceAt = at
+		exception.Cancelled = request.Cancelled
+		exception.Name = request.Name
+		exception.Description = request.Description
+		exception.StartsAt = utc(request.StartsAt)
+		exception.EndsAt = utc(request.EndsAt)
+		exception.Venue = request.Venue
+		exception.Address = request.Address
+
+		errors := validation.Validate(exception)
+		if len(errors) != 0 {
+			responses.NewJsonResponse(rw, http.StatusUnprocessableEntity, errors)
+			return
+		}
+
+		if result := connection.Save(&exception); result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, newOccurrenceResponse(event, at, &exception))
+	})
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"site/database/models"
+	"site/http/responses"
+	"site/recurrence"
+	"site/uploader"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// PublicEventResponse is what anonymous visitors see of an event. It leaves
+// out who owns the event, its capacity and everything else only the people
+// working on it need.
+type PublicEventResponse struct {
+	Name            string                `json:"name"`
+	Description     string                `json:"description"`
+	StartsAt        *time.Time            `json:"starts_at,omitempty"`
+	EndsAt          *time.Time            `json:"ends_at,omitempty"`
+	TimeZone        string                `json:"time_zone,omitempty"`
+	AllDay          bool                  `json:"all_day"`
+	Venue           string                `json:"venue,omitempty"`
+	Address         string                `json:"address,omitempty"`
+	Latitude        *float64              `json:"latitude,omitempty"`
+	Longitude       *float64              `json:"longitude,omitempty"`
+	Status          string                `json:"status"`
+	RecurrenceRule  string                `json:"recurrence_rule,omitempty"`
+	RecurrenceDates []time.Time           `json:"recurrence_dates,omitempty"`
+	ExceptionDates  []time.Time           `json:"exception_dates,omitempty"`
+	Attachments     []string              `json:"attachments"`
+	Media           []PublicMediaResponse `json:"media"`
+}
+
+type PublicMediaResponse struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Size int    `json:"size"`
+	URL  string `json:"url"`
+}
+
+func publicMediaURL(slug string, media *models.Media) string {
+	return "/public/events/" + slug + "/media/" + strconv.Itoa(int(media.ID))
+}
+
+// findSharedEvent loads the event of the {slug} route variable. Drafts and
+// private events are not found.
+func findSharedEvent(rw http.ResponseWriter, r *http.Request, connection *gorm.DB) (*models.Event, bool) {
+	slug := mux.Vars(r)["slug"]
+	if slug == "" {
+		responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+		return nil, false
+	}
+
+	event := models.Event{}
+	result := connection.
+		Where("share_slug = ? AND visibility IN ? AND status <> ?", slug, []string{models.EventUnlisted, models.EventPublic}, models.EventDraft).
+		Limit(1).
+		Find(&event)
+	if result.Error != nil {
+		responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+		return nil, false
+	}
+	if event.ID == 0 {
+		responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+		return nil, false
+	}
+
+	return &event, true
+}
+
+// PublicEvent shows an unlisted or public event to anybody with its share
+// link, without logging in.
+func PublicEvent(connection *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		event, ok := findSharedEvent(rw, r, connection)
+		if !ok {
+			return
+		}
+
+		attachments := []models.EventAttachment{}
+		if result := connection.Where("event_id = ?", event.ID).Order("id").Find(&attachments); result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		media := []models.Media{}
+		if result := connection.Where("event_id = ?", event.ID).Order("`order`, id").Find(&media); result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+
+		recurrenceDates, _ := recurrence.ParseDates(event.RecurrenceDates)
+		exceptionDates, _ := recurrence.ParseDates(event.ExceptionDates)
+		response := PublicEventResponse{
+			Name:            event.Name,
+			Description:     event.Description,
+			StartsAt:        event.StartsAt,
+			EndsAt:          event.EndsAt,
+			TimeZone:        event.TimeZone,
+			AllDay:          event.AllDay,
+			Venue:           event.Venue,
+			Address:         event.Address,
+			Latitude:        event.Latitude,
+			Longitude:       event.Longitude,
+			Status:          event.Status,
+			RecurrenceRule:  event.RecurrenceRule,
+			RecurrenceDates: recurrenceDates,
+			ExceptionDates:  exceptionDates,
+			Attachments:     make([]string, len(attachments)),
+			Media:           make([]PublicMediaResponse, len(media)),
+		}
+		for i := range attachments {
+			response.Attachments[i] = attachments[i].URL
+		}
+		for i := range media {
+			response.Media[i] = PublicMediaResponse{
+				ID:   media[i].ID,
+				Name: media[i].Name,
+				Size: media[i].Size,
+				URL:  publicMediaURL(*event.ShareSlug, &media[i]),
+			}
+		}
+
+		responses.NewJsonResponse(rw, http.StatusOK, response)
+	})
+}
+
+// inlineMediaTypes are the types shown in the browser, everything else is
+// downloaded so uploaded pages and scripts never run on the site.
+var inlineMediaTypes = map[string]bool{
+	"image/gif":  true,
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// PublicMedia sends a media file of an event shown by PublicEvent.
+func PublicMedia(connection *gorm.DB, uploaderService uploader.Uploader) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			responses.NewJsonResponse(rw, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		event, ok := findSharedEvent(rw, r, connection)
+		if !ok {
+			return
+		}
+
+		media := models.Media{}
+		result := connection.Where("id = ? AND event_id = ?", mux.Vars(r)["media"], event.ID).Limit(1).Find(&media)
+		if result.Error != nil {
+			responses.NewJsonResponse(rw, http.StatusInternalServerError, nil)
+			return
+		}
+		if media.ID == 0 {
+			responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+			return
+		}
+
+		file, err := uploaderService.Open(media.Path)
+		if err != nil {
+			log.Printf("Failed to open the media file %s %s \n", media.Path, err)
+			responses.NewJsonResponse(rw, http.StatusNotFound, nil)
+			return
+		}
+		defer file.Close()
+
+		contentType := mime.TypeByExtension(filepath.Ext(media.Name))
+		disposition := "inline"
+		if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || !inlineMediaTypes[mediaType] {
+			contentType, disposition = "application/octet-stream", "attachment"
+		}
+
+		rw.Header().Set("Content-Type", contentType)
+		if header := mime.FormatMediaType(disposition, map[string]string{"filename": media.Name}); header != "" {
+			disposition = header
+		}
+		rw.Header().Set("Content-Disposition", disposition)
+		rw.Header().Set("X-Content-Type-Options", "nosniff")
+		rw.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(rw, file); err != nil {
+			log.Printf("Failed to send the media file %s %s \n", media.Path, err)
+		}
+	})
+}
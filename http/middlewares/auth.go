@@ -1,24 +1,91 @@
 package middlewares
 
 import (
+	"errors"
 	"net/http"
+	"site/http/responses"
 	"site/security"
+
+	"gorm.io/gorm"
 )
 
 type Middleware func(next http.Handler) http.Handler
 
-const AuthorizationHeader = "Authorization"
+const (
+	AuthorizationHeader = "Authorization"
+	APIKeyHeader        = "X-API-Key"
+)
+
+// AuthMiddleware authenticates the request and stores the Principal in its
+// context, so handlers never have to parse the token themselves. The token is
+// read through the given extractors, defaulting to the Bearer scheme. When
+// keys is not nil, an API key sent in the X-API-Key header is accepted
+// instead of a token.
+func AuthMiddleware(s security.TokenSecurity, keys security.APIKeys, connection *gorm.DB, extractors ...TokenExtractor) Middleware {
+	if len(extractors) == 0 {
+		extractors = []TokenExtractor{BearerToken}
+	}
 
-func AuthMiddleware(s security.TokenSecurity) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-			header := r.Header.Get(AuthorizationHeader)
-			if header == "" || !s.IsValid(header) {
-				rw.WriteHeader(http.StatusUnauthorized)
+			token, err := extractToken(r, extractors)
+
+			key := ""
+			if keys != nil {
+				key = r.Header.Get(APIKeyHeader)
+			}
+
+			if errors.Is(err, ErrCSRFToken) {
+				responses.NewJsonResponse(rw, http.StatusForbidden, map[string]string{
+					"error": "The CSRF token is missing or invalid!",
+				})
+				return
+			}
+
+			if err != nil || (token != "" && key != "") {
+				challenge(rw, http.StatusBadRequest, "invalid_request", "The access token is malformed or sent more than once")
+				return
+			}
+
+			if token == "" && key == "" {
+				challenge(rw, http.StatusUnauthorized, "", "")
+				return
+			}
+
+			principal := &Principal{Token: token}
+			var userId uint
+			if key != "" {
+				principal.APIKey, err = keys.Authenticate(key)
+				if errors.Is(err, security.ErrInvalidAPIKey) {
+					challenge(rw, http.StatusUnauthorized, "invalid_token", "The API key is invalid, expired or revoked")
+					return
+				}
+				if err != nil {
+					rw.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				userId = principal.APIKey.UserID
+			} else {
+				userId, err = s.GetIdentifier(token)
+				if err != nil {
+					challenge(rw, http.StatusUnauthorized, "invalid_token", "The access token is invalid or has expired")
+					return
+				}
+			}
+
+			result := connection.Find(&principal.User, userId)
+			if result.Error != nil {
+				rw.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if principal.User.ID == 0 {
+				challenge(rw, http.StatusUnauthorized, "invalid_token", "The access token is invalid or has expired")
 				return
 			}
 
-			next.ServeHTTP(rw, r)
+			ctx := WithPrincipal(r.Context(), principal)
+			next.ServeHTTP(rw, r.WithContext(ctx))
 		})
 	}
 }
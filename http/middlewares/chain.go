@@ -0,0 +1,13 @@
+package middlewares
+
+import "net/http"
+
+// Chain composes middlewares so that the first one runs first.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
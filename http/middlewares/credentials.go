@@ -0,0 +1,163 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Realm is announced in the WWW-Authenticate challenge.
+const Realm = "site"
+
+// CSRFCookie and CSRFHeader carry the double submitted CSRF token. Whoever
+// sets the access token cookie also sets the CSRF cookie, readable by the
+// scripts of the site, which repeat it in the header.
+const (
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+var (
+	ErrMalformedCredentials = errors.New("malformed credentials")
+	ErrCSRFToken            = errors.New("missing or invalid CSRF token")
+)
+
+// b64token is the token68 syntax RFC 6750 allows for bearer tokens.
+var b64token = regexp.MustCompile(`^[A-Za-z0-9\-._~+/]+=*$`)
+
+// TokenExtractor reads an access token from one credential carrier. It
+// returns an empty string when the request does not use that carrier.
+type TokenExtractor func(r *http.Request) (string, error)
+
+// BearerToken reads the token from an "Authorization: Bearer <token>" header
+// as described by RFC 6750. Other authentication schemes are ignored.
+func BearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get(AuthorizationHeader)
+	if header == "" {
+		return "", nil
+	}
+
+	parts := strings.SplitN(header, " ", 2)
+	if !strings.EqualFold(parts[0], "Bearer") {
+		return "", nil
+	}
+
+	if len(parts) != 2 {
+		return "", ErrMalformedCredentials
+	}
+
+	token := strings.TrimLeft(parts[1], " ")
+	if !b64token.MatchString(token) {
+		return "", ErrMalformedCredentials
+	}
+
+	return token, nil
+}
+
+// CookieToken reads the token from a cookie. The cookie is expected to be
+// HttpOnly and SameSite, since browsers send it along with every request.
+// Requests with unsafe methods also have to send the CSRF token, which
+// other sites can not read.
+func CookieToken(name string) TokenExtractor {
+	return func(r *http.Request) (string, error) {
+		cookie, err := r.Cookie(name)
+		if err != nil || cookie.Value == "" {
+			return "", nil
+		}
+
+		if !safeMethod(r.Method) && !validCSRFToken(r) {
+			return "", ErrCSRFToken
+		}
+
+		return cookie.Value, nil
+	}
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// validCSRFToken reports whether the CSRF header repeats the CSRF cookie.
+func validCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(CSRFCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.Header.Get(CSRFHeader))) == 1
+}
+
+// QueryToken reads the token from a query parameter. URLs end up in logs and
+// browser histories, so it is limited to safe methods and meant for download
+// links only.
+func QueryToken(param string) TokenExtractor {
+	return func(r *http.Request) (string, error) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			return "", nil
+		}
+
+		return r.URL.Query().Get(param), nil
+	}
+}
+
+// TokenExtractorsFromEnv always accepts Bearer tokens and additionally the
+// cookie named by AUTH_COOKIE_NAME when it is set.
+func TokenExtractorsFromEnv() []TokenExtractor {
+	extractors := []TokenExtractor{BearerToken}
+
+	if name := os.Getenv("AUTH_COOKIE_NAME"); name != "" {
+		extractors = append(extractors, CookieToken(name))
+	}
+
+	return extractors
+}
+
+// DownloadTokenExtractorsFromEnv are the extractors of download routes. They
+// additionally accept the query parameter named by AUTH_QUERY_PARAMETER when
+// it is set, so downloads can be started from plain links.
+func DownloadTokenExtractorsFromEnv() []TokenExtractor {
+	extractors := TokenExtractorsFromEnv()
+
+	if param := os.Getenv("AUTH_QUERY_PARAMETER"); param != "" {
+		extractors = append(extractors, QueryToken(param))
+	}
+
+	return extractors
+}
+
+// extractToken runs every extractor. Clients must not use more than one way of
+// transmitting the token in a single request.
+func extractToken(r *http.Request, extractors []TokenExtractor) (string, error) {
+	found := ""
+	for _, extract := range extractors {
+		token, err := extract(r)
+		if err != nil {
+			return "", err
+		}
+
+		if token == "" {
+			continue
+		}
+
+		if found != "" {
+			return "", ErrMalformedCredentials
+		}
+		found = token
+	}
+
+	return found, nil
+}
+
+// challenge writes an RFC 6750 error response.
+func challenge(rw http.ResponseWriter, status int, code string, description string) {
+	value := `Bearer realm="` + Realm + `"`
+	if code != "" {
+		value += `, error="` + code + `", error_description="` + description + `"`
+	}
+
+	rw.Header().Set("WWW-Authenticate", value)
+	rw.WriteHeader(status)
+}
@@ -0,0 +1,60 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"site/database/models"
+	"site/http/responses"
+)
+
+// Principal is the authenticated identity of a request. Requests
+// authenticated with an API key carry the key, and no token.
+type Principal struct {
+	User   models.User
+	Token  string
+	APIKey *models.APIKey
+}
+
+type contextKey int
+
+const principalKey contextKey = iota
+
+// WithPrincipal returns a copy of ctx carrying the principal.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalFromContext returns the principal stored by AuthMiddleware.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey).(*Principal)
+	return p, ok && p != nil
+}
+
+// CurrentUser returns the authenticated user of the request.
+func CurrentUser(r *http.Request) (*models.User, bool) {
+	p, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		return nil, false
+	}
+	return &p.User, true
+}
+
+// InteractiveUser returns the user of the request, unless the request has
+// been authenticated with an API key. Whatever their scopes, keys can not
+// manage the account itself: its credentials, sessions and personal data.
+func InteractiveUser(rw http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	p, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		responses.NewJsonResponse(rw, http.StatusUnauthorized, nil)
+		return nil, false
+	}
+
+	if p.APIKey != nil {
+		responses.NewJsonResponse(rw, http.StatusForbidden, map[string]string{
+			"error": "This can not be done with an API key!",
+		})
+		return nil, false
+	}
+
+	return &p.User, true
+}
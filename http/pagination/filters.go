@@ -0,0 +1,49 @@
+package pagination
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TimeParam reads an optional RFC 3339 timestamp or a date like "2022-03-01"
+// from the query. Dates are midnight UTC.
+func TimeParam(r *http.Request, name string) (*time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return &parsed, nil
+		}
+	}
+
+	return nil, ParameterError{name}
+}
+
+// OneOfParam reads an optional query parameter that has to be one of the
+// allowed values.
+func OneOfParam(r *http.Request, name string, allowed ...string) (string, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return "", nil
+	}
+
+	for _, candidate := range allowed {
+		if value == candidate {
+			return value, nil
+		}
+	}
+
+	return "", ParameterError{name}
+}
+
+// Contains returns the condition matching a column that contains the value,
+// with the wildcards of LIKE escaped. The escape character is "!", a backslash
+// would itself need escaping in MySQL string literals.
+func Contains(column string, value string) (string, string) {
+	escaped := strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(value)
+	return column + ` LIKE ? ESCAPE '!'`, "%" + escaped + "%"
+}
@@ -0,0 +1,268 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// ParameterError reports an invalid query parameter.
+type ParameterError struct {
+	Name string
+}
+
+func (e ParameterError) Error() string {
+	return fmt.Sprintf("invalid query parameter %q", e.Name)
+}
+
+// Column is a column a list can be sorted by. Field is the name of the struct
+// field holding its value, which the cursor of the next page is built from.
+type Column struct {
+	Name     string
+	Field    string
+	Nullable bool
+	Time     bool
+}
+
+// Options describe the sort keys a list endpoint offers.
+type Options struct {
+	Sorts       map[string]Column
+	DefaultSort string
+}
+
+// Query is a parsed page request. Lists are ordered by the sort column and
+// then by id, so the order is stable even for equal values.
+type Query struct {
+	Limit      int
+	Sort       string
+	Descending bool
+	column     Column
+	cursor     *cursor
+}
+
+// cursor is the position after the last item of a page. It remembers the sort
+// it was created for, so it can not be combined with another sort.
+type cursor struct {
+	Sort  string  `json:"s"`
+	Value *string `json:"v"`
+	ID    uint    `json:"id"`
+}
+
+// Parse reads the "limit", "sort" and "cursor" query parameters. The limit is
+// capped at MaxLimit, the sort is a key of the options prefixed with "-" for
+// descending order.
+func Parse(r *http.Request, options Options) (Query, error) {
+	values := r.URL.Query()
+	query := Query{Limit: DefaultLimit, Sort: options.DefaultSort}
+
+	if limit := values.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 {
+			return query, ParameterError{"limit"}
+		}
+		query.Limit = parsed
+		if query.Limit > MaxLimit {
+			query.Limit = MaxLimit
+		}
+	}
+
+	if sort := values.Get("sort"); sort != "" {
+		query.Sort = sort
+	}
+
+	key := strings.TrimPrefix(query.Sort, "-")
+	query.Descending = strings.HasPrefix(query.Sort, "-")
+	column, ok := options.Sorts[key]
+	if !ok {
+		return query, ParameterError{"sort"}
+	}
+	query.column = column
+
+	if encoded := values.Get("cursor"); encoded != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			return query, ParameterError{"cursor"}
+		}
+
+		c := cursor{}
+		if err := json.Unmarshal(decoded, &c); err != nil || c.Sort != query.Sort || c.ID == 0 {
+			return query, ParameterError{"cursor"}
+		}
+
+		if c.Value == nil && !column.Nullable {
+			return query, ParameterError{"cursor"}
+		}
+
+		if c.Value != nil && column.Time {
+			if _, err := time.Parse(time.RFC3339Nano, *c.Value); err != nil {
+				return query, ParameterError{"cursor"}
+			}
+		}
+
+		query.cursor = &c
+	}
+
+	return query, nil
+}
+
+// Page is the response envelope of a list.
+type Page struct {
+	Data       interface{} `json:"data"`
+	Total      int64       `json:"total"`
+	Limit      int         `json:"limit"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// Find loads one page of the filtered query into dest, a pointer to a slice of
+// structs, and counts every matching row.
+func Find(db *gorm.DB, query Query, dest interface{}) (Page, error) {
+	page := Page{Limit: query.Limit}
+
+	if err := db.Session(&gorm.Session{}).Count(&page.Total).Error; err != nil {
+		return page, err
+	}
+
+	paged := db.Session(&gorm.Session{})
+	if query.cursor != nil {
+		condition, args := query.after()
+		paged = paged.Where(condition, args...)
+	}
+
+	for _, order := range query.order() {
+		paged = paged.Order(order)
+	}
+
+	if err := paged.Limit(query.Limit + 1).Find(dest).Error; err != nil {
+		return page, err
+	}
+
+	items := reflect.ValueOf(dest).Elem()
+	if items.Len() > query.Limit {
+		items.Set(items.Slice(0, query.Limit))
+
+		next, err := query.next(items.Index(query.Limit - 1))
+		if err != nil {
+			return page, err
+		}
+		page.NextCursor = next
+	}
+
+	page.Data = items.Interface()
+	return page, nil
+}
+
+// order sorts null values last in ascending order and first in descending
+// order, so descending is the exact reverse.
+func (q Query) order() []string {
+	direction := "ASC"
+	if q.Descending {
+		direction = "DESC"
+	}
+
+	orders := []string{}
+	if q.column.Nullable {
+		orders = append(orders, fmt.Sprintf("%s IS NULL %s", q.column.Name, direction))
+	}
+	return append(orders, fmt.Sprintf("%s %s", q.column.Name, direction), "id "+direction)
+}
+
+// after selects the rows following the cursor in the order of the query.
+func (q Query) after() (string, []interface{}) {
+	column := q.column.Name
+	comparison, idComparison := ">", ">"
+	if q.Descending {
+		comparison, idComparison = "<", "<"
+	}
+
+	if q.cursor.Value == nil {
+		if q.Descending {
+			return fmt.Sprintf("(%s IS NULL AND id < ?) OR %s IS NOT NULL", column, column), []interface{}{q.cursor.ID}
+		}
+		return fmt.Sprintf("%s IS NULL AND id > ?", column), []interface{}{q.cursor.ID}
+	}
+
+	value := q.value()
+	condition := fmt.Sprintf("%s %s ? OR (%s = ? AND id %s ?)", column, comparison, column, idComparison)
+	if q.column.Nullable && !q.Descending {
+		condition = fmt.Sprintf("%s IS NULL OR %s", column, condition)
+	}
+	return "(" + condition + ")", []interface{}{value, value, q.cursor.ID}
+}
+
+func (q Query) value() interface{} {
+	if q.column.Time {
+		parsed, _ := time.Parse(time.RFC3339Nano, *q.cursor.Value)
+		return parsed.Local()
+	}
+	return *q.cursor.Value
+}
+
+func (q Query) next(last reflect.Value) (string, error) {
+	if last.Kind() == reflect.Ptr {
+		last = last.Elem()
+	}
+
+	c := cursor{Sort: q.Sort, ID: uint(last.FieldByName("ID").Uint())}
+
+	field := last.FieldByName(q.column.Field)
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			field = reflect.Value{}
+		} else {
+			field = field.Elem()
+		}
+	}
+
+	if field.IsValid() {
+		var value string
+		switch v := field.Interface().(type) {
+		case time.Time:
+			value = v.UTC().Format(time.RFC3339Nano)
+		default:
+			value = fmt.Sprint(v)
+		}
+		c.Value = &value
+	}
+
+	encoded, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+// SetLinks adds the Link header with the first and the next page of the list.
+func SetLinks(rw http.ResponseWriter, r *http.Request, page Page) {
+	link := func(cursor string, rel string) string {
+		values := url.Values{}
+		for name, value := range r.URL.Query() {
+			values[name] = value
+		}
+		values.Del("cursor")
+		if cursor != "" {
+			values.Set("cursor", cursor)
+		}
+
+		target := url.URL{Path: r.URL.Path, RawQuery: values.Encode()}
+		return fmt.Sprintf("<%s>; rel=\"%s\"", target.String(), rel)
+	}
+
+	links := []string{link("", "first")}
+	if page.NextCursor != "" {
+		links = append(links, link(page.NextCursor, "next"))
+	}
+	rw.Header().Set("Link", strings.Join(links, ", "))
+}
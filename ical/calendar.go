@@ -0,0 +1,189 @@
+package ical
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ContentType is the media type of an iCalendar object.
+const ContentType = "text/calendar; charset=utf-8"
+
+const (
+	StatusTentative = "TENTATIVE"
+	StatusConfirmed = "CONFIRMED"
+	StatusCancelled = "CANCELLED"
+)
+
+// Calendar is a VCALENDAR published to subscribers.
+type Calendar struct {
+	ProdID string
+	Name   string
+	Events []Event
+}
+
+// Event is a VEVENT. Times are written in TimeZone, which gets a VTIMEZONE,
+// or in UTC when it is empty. RecurrenceID marks the event as the changed
+// occurrence of the series with the same UID that was scheduled at that time.
+type Event struct {
+	UID          string
+	Stamp        time.Time
+	Created      time.Time
+	LastModified time.Time
+	Start        time.Time
+	End          *time.Time
+	AllDay       bool
+	TimeZone     string
+	Summary      string
+	Description  string
+	Location     string
+	Latitude     *float64
+	Longitude    *float64
+	Status       string
+	Sequence     int
+	// Attachments are the URIs of ATTACH properties.
+	Attachments []string
+
+	RecurrenceRule  string
+	RecurrenceDates []time.Time
+	ExceptionDates  []time.Time
+	RecurrenceID    *time.Time
+}
+
+// WriteTo writes the calendar as an RFC 5545 iCalendar object.
+func (c Calendar) WriteTo(w io.Writer) (int64, error) {
+	out := &writer{w: w}
+
+	out.property("BEGIN", "VCALENDAR")
+	out.property("VERSION", "2.0")
+	out.text("PRODID", c.ProdID)
+	out.property("CALSCALE", "GREGORIAN")
+	out.property("METHOD", "PUBLISH")
+	out.text("X-WR-CALNAME", c.Name)
+
+	for _, zone := range c.timeZones() {
+		writeTimeZone(out, zone.name, zone.from, zone.to)
+	}
+
+	for _, event := range c.Events {
+		event.write(out)
+	}
+
+	out.property("END", "VCALENDAR")
+	return out.n, out.err
+}
+
+type zoneRange struct {
+	name     string
+	from, to int
+}
+
+// timeZones returns the zones the events are written in with the years they
+// need to cover. Recurring events may go on, so the zones cover two more
+// years, which leaves the rules still in use open.
+func (c Calendar) timeZones() []zoneRange {
+	ranges := map[string]*zoneRange{}
+	names := []string{}
+
+	for _, event := range c.Events {
+		if event.AllDay || location(event.TimeZone) == time.UTC {
+			continue
+		}
+
+		times := append([]time.Time{event.Start}, event.RecurrenceDates...)
+		times = append(times, event.ExceptionDates...)
+		if event.RecurrenceID != nil {
+			times = append(times, *event.RecurrenceID)
+		}
+		if event.End != nil {
+			times = append(times, *event.End)
+		}
+
+		r, ok := ranges[event.TimeZone]
+		if !ok {
+			r = &zoneRange{name: event.TimeZone, from: event.Start.Year(), to: time.Now().Year()}
+			ranges[event.TimeZone] = r
+			names = append(names, event.TimeZone)
+		}
+		for _, t := range times {
+			if t.Year() < r.from {
+				r.from = t.Year()
+			}
+			if t.Year() > r.to {
+				r.to = t.Year()
+			}
+		}
+	}
+
+	sort.Strings(names)
+	zones := make([]zoneRange, len(names))
+	for i, name := range names {
+		zones[i] = *ranges[name]
+		zones[i].to += 2
+	}
+	return zones
+}
+
+func (e Event) write(out *writer) {
+	out.property("BEGIN", "VEVENT")
+	out.text("UID", e.UID)
+	out.property("DTSTAMP", e.Stamp.UTC().Format(utcDateTimeFormat))
+
+	if e.RecurrenceID != nil {
+		parameters, value := dateTime(*e.RecurrenceID, e.TimeZone, e.AllDay)
+		out.property("RECURRENCE-ID"+parameters, value)
+	}
+
+	parameters, value := dateTime(e.Start, e.TimeZone, e.AllDay)
+	out.property("DTSTART"+parameters, value)
+
+	end := e.End
+	if end == nil && e.AllDay {
+		nextDay := e.Start.AddDate(0, 0, 1)
+		end = &nextDay
+	}
+	if end != nil {
+		parameters, value := dateTime(*end, e.TimeZone, e.AllDay)
+		out.property("DTEND"+parameters, value)
+	}
+
+	out.text("SUMMARY", e.Summary)
+	out.text("DESCRIPTION", e.Description)
+	out.text("LOCATION", e.Location)
+	if e.Latitude != nil && e.Longitude != nil {
+		out.property("GEO", fmt.Sprintf("%s;%s", formatFloat(*e.Latitude), formatFloat(*e.Longitude)))
+	}
+	out.text("STATUS", e.Status)
+	if e.Sequence != 0 {
+		out.property("SEQUENCE", strconv.Itoa(e.Sequence))
+	}
+	for _, attachment := range e.Attachments {
+		out.property("ATTACH", attachment)
+	}
+
+	if e.RecurrenceRule != "" {
+		out.property("RRULE", e.RecurrenceRule)
+	}
+	if len(e.RecurrenceDates) != 0 {
+		parameters, value := dateTimes(e.RecurrenceDates, e.TimeZone, e.AllDay)
+		out.property("RDATE"+parameters, value)
+	}
+	if len(e.ExceptionDates) != 0 {
+		parameters, value := dateTimes(e.ExceptionDates, e.TimeZone, e.AllDay)
+		out.property("EXDATE"+parameters, value)
+	}
+
+	if !e.Created.IsZero() {
+		out.property("CREATED", e.Created.UTC().Format(utcDateTimeFormat))
+	}
+	if !e.LastModified.IsZero() {
+		out.property("LAST-MODIFIED", e.LastModified.UTC().Format(utcDateTimeFormat))
+	}
+	out.property("END", "VEVENT")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,383 @@
+package ical
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"site/recurrence"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a VEVENT read from a calendar. Err tells why it could not be read,
+// Event then carries what could be, like the UID and the summary.
+type Entry struct {
+	Event Event
+	Err   error
+}
+
+// ReadEvents parses an iCalendar stream and reads its VEVENTs.
+func ReadEvents(r io.Reader) ([]Entry, error) {
+	root, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	zones := newZones(root)
+	entries := []Entry{}
+	for _, component := range root.Children("VEVENT") {
+		event, err := readEvent(component, zones)
+		entries = append(entries, Entry{Event: event, Err: err})
+	}
+	return entries, nil
+}
+
+func readEvent(c *Component, zones *zones) (Event, error) {
+	event := Event{}
+	if p, ok := c.Get("UID"); ok {
+		event.UID = p.Text()
+	}
+	if p, ok := c.Get("SUMMARY"); ok {
+		event.Summary = p.Text()
+	}
+	if p, ok := c.Get("DESCRIPTION"); ok {
+		event.Description = p.Text()
+	}
+	if p, ok := c.Get("LOCATION"); ok {
+		event.Location = p.Text()
+	}
+	if p, ok := c.Get("STATUS"); ok {
+		event.Status = strings.ToUpper(p.Value)
+	}
+	if p, ok := c.Get("SEQUENCE"); ok {
+		event.Sequence, _ = strconv.Atoi(p.Value)
+	}
+	if p, ok := c.Get("RRULE"); ok {
+		event.RecurrenceRule = p.Value
+	}
+
+	if p, ok := c.Get("GEO"); ok {
+		parts := strings.Split(p.Value, ";")
+		if len(parts) == 2 {
+			latitude, err1 := strconv.ParseFloat(parts[0], 64)
+			longitude, err2 := strconv.ParseFloat(parts[1], 64)
+			if err1 == nil && err2 == nil {
+				event.Latitude, event.Longitude = &latitude, &longitude
+			}
+		}
+	}
+
+	for _, p := range c.All("ATTACH") {
+		if strings.EqualFold(p.Parameters["VALUE"], "BINARY") || p.Parameters["ENCODING"] != "" {
+			continue
+		}
+		event.Attachments = append(event.Attachments, p.Value)
+	}
+
+	for name, target := range map[string]*time.Time{"CREATED": &event.Created, "LAST-MODIFIED": &event.LastModified, "DTSTAMP": &event.Stamp} {
+		if p, ok := c.Get(name); ok {
+			if t, _, _, err := zones.parse(p.Value, p.Parameters); err == nil {
+				*target = t
+			}
+		}
+	}
+
+	if event.UID == "" {
+		return event, errors.New("the event has no UID")
+	}
+
+	start, ok := c.Get("DTSTART")
+	if !ok {
+		return event, errors.New("the event has no DTSTART")
+	}
+	var err error
+	event.Start, event.AllDay, event.TimeZone, err = zones.parse(start.Value, start.Parameters)
+	if err != nil {
+		return event, fmt.Errorf("DTSTART: %w", err)
+	}
+
+	if p, ok := c.Get("DTEND"); ok {
+		end, _, _, err := zones.parse(p.Value, p.Parameters)
+		if err != nil {
+			return event, fmt.Errorf("DTEND: %w", err)
+		}
+		event.End = &end
+	} else if p, ok := c.Get("DURATION"); ok {
+		days, duration, err := parseDuration(p.Value)
+		if err != nil {
+			return event, fmt.Errorf("DURATION: %w", err)
+		}
+		end := event.Start.AddDate(0, 0, days).Add(duration)
+		event.End = &end
+	}
+
+	if p, ok := c.Get("RECURRENCE-ID"); ok {
+		recurrenceID, _, _, err := zones.parse(p.Value, p.Parameters)
+		if err != nil {
+			return event, fmt.Errorf("RECURRENCE-ID: %w", err)
+		}
+		event.RecurrenceID = &recurrenceID
+	}
+
+	for name, target := range map[string]*[]time.Time{"RDATE": &event.RecurrenceDates, "EXDATE": &event.ExceptionDates} {
+		for _, p := range c.All(name) {
+			for _, value := range strings.Split(p.Value, ",") {
+				// a PERIOD starts with the date-time of the occurrence
+				value = strings.SplitN(value, "/", 2)[0]
+				parameters := p.Parameters
+				if strings.EqualFold(parameters["VALUE"], "PERIOD") {
+					parameters = map[string]string{"TZID": p.Parameters["TZID"]}
+				}
+
+				t, _, _, err := zones.parse(value, parameters)
+				if err != nil {
+					return event, fmt.Errorf("%s: %w", name, err)
+				}
+				*target = append(*target, t)
+			}
+		}
+	}
+
+	return event, nil
+}
+
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseDuration reads a DURATION like "P1DT2H30M". Days and weeks are nominal,
+// so they are returned apart from the exact time.
+func parseDuration(value string) (int, time.Duration, error) {
+	match := durationPattern.FindStringSubmatch(strings.ToUpper(value))
+	if match == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	number := func(i int) int {
+		n, _ := strconv.Atoi(match[i])
+		return n
+	}
+	days := number(2)*7 + number(3)
+	duration := time.Duration(number(4))*time.Hour + time.Duration(number(5))*time.Minute + time.Duration(number(6))*time.Second
+	if match[1] == "-" {
+		days, duration = -days, -duration
+	}
+	return days, duration, nil
+}
+
+// windowsZones maps the zone names of Outlook and Exchange to IANA names.
+var windowsZones = map[string]string{
+	"UTC":                            "UTC",
+	"GMT Standard Time":              "Europe/London",
+	"W. Europe Standard Time":        "Europe/Berlin",
+	"Romance Standard Time":          "Europe/Paris",
+	"Central Europe Standard Time":   "Europe/Budapest",
+	"Central European Standard Time": "Europe/Warsaw",
+	"FLE Standard Time":              "Europe/Kiev",
+	"GTB Standard Time":              "Europe/Bucharest",
+	"Russian Standard Time":          "Europe/Moscow",
+	"Eastern Standard Time":          "America/New_York",
+	"Central Standard Time":          "America/Chicago",
+	"Mountain Standard Time":         "America/Denver",
+	"Pacific Standard Time":          "America/Los_Angeles",
+	"Alaskan Standard Time":          "America/Anchorage",
+	"Hawaiian Standard Time":         "Pacific/Honolulu",
+	"E. South America Standard Time": "America/Sao_Paulo",
+	"India Standard Time":            "Asia/Kolkata",
+	"China Standard Time":            "Asia/Shanghai",
+	"Tokyo Standard Time":            "Asia/Tokyo",
+	"Singapore Standard Time":        "Asia/Singapore",
+	"AUS Eastern Standard Time":      "Australia/Sydney",
+}
+
+// ianaZone finds the IANA zone a TZID stands for. Besides IANA names it knows
+// Windows names and prefixed names like "/mozilla.org/20050126_1/Europe/Berlin".
+func ianaZone(tzid string) (string, *time.Location) {
+	candidates := []string{tzid}
+	if name, ok := windowsZones[tzid]; ok {
+		candidates = append(candidates, name)
+	}
+	parts := strings.Split(strings.Trim(tzid, "/"), "/")
+	for n := 3; n >= 1; n-- {
+		if len(parts) > n {
+			candidates = append(candidates, strings.Join(parts[len(parts)-n:], "/"))
+		}
+	}
+
+	for _, name := range candidates {
+		if name == "" || name == "Local" {
+			continue
+		}
+		if location, err := time.LoadLocation(name); err == nil {
+			return name, location
+		}
+	}
+	return "", nil
+}
+
+// zones resolves the times of a calendar. Zones that are not known by name
+// are computed from their VTIMEZONE, times then keep no zone.
+type zones struct {
+	defined  map[string]*definedZone
+	floating *time.Location
+	// floatingName is the zone of floating times, from X-WR-TIMEZONE.
+	floatingName string
+}
+
+func newZones(root *Component) *zones {
+	z := &zones{defined: map[string]*definedZone{}, floating: time.UTC}
+
+	if p, ok := root.Get("X-WR-TIMEZONE"); ok {
+		if name, location := ianaZone(p.Text()); location != nil {
+			z.floating, z.floatingName = location, name
+		}
+	}
+
+	for _, c := range root.Children("VTIMEZONE") {
+		if p, ok := c.Get("TZID"); ok {
+			if zone := newDefinedZone(c); zone != nil {
+				z.defined[p.Value] = zone
+			}
+		}
+	}
+	return z
+}
+
+// parse reads a DATE or DATE-TIME value and tells whether it was a date and
+// which IANA zone it was in.
+func (z *zones) parse(value string, parameters map[string]string) (time.Time, bool, string, error) {
+	if strings.EqualFold(parameters["VALUE"], "DATE") || len(value) == len(dateFormat) {
+		t, err := time.Parse(dateFormat, value)
+		return t, true, "", err
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(utcDateTimeFormat, value)
+		return t, false, "", err
+	}
+
+	tzid, ok := parameters["TZID"]
+	if !ok {
+		t, err := time.ParseInLocation(localDateTimeFormat, value, z.floating)
+		return t, false, z.floatingName, err
+	}
+
+	if name, location := ianaZone(tzid); location != nil {
+		t, err := time.ParseInLocation(localDateTimeFormat, value, location)
+		if name == "UTC" {
+			name = ""
+		}
+		return t, false, name, err
+	}
+
+	zone, ok := z.defined[tzid]
+	if !ok {
+		return time.Time{}, false, "", fmt.Errorf("unknown time zone %q", tzid)
+	}
+	local, err := time.Parse(localDateTimeFormat, value)
+	if err != nil {
+		return local, false, "", err
+	}
+	return zone.instant(local), false, "", nil
+}
+
+// maxOnsets bounds the onsets of an observance that are looked at.
+const maxOnsets = 10000
+
+// definedZone is a time zone described by its VTIMEZONE.
+type definedZone struct {
+	observances []zoneObservance
+}
+
+// zoneObservance is a STANDARD or DAYLIGHT component. Its onsets are local
+// times held as UTC.
+type zoneObservance struct {
+	onsets   recurrence.Set
+	from, to int
+}
+
+func newDefinedZone(c *Component) *definedZone {
+	zone := &definedZone{}
+	for _, o := range c.Components {
+		if o.Name != "STANDARD" && o.Name != "DAYLIGHT" {
+			continue
+		}
+
+		start, ok1 := o.Get("DTSTART")
+		from, ok2 := o.Get("TZOFFSETFROM")
+		to, ok3 := o.Get("TZOFFSETTO")
+		if !ok1 || !ok2 || !ok3 {
+			continue
+		}
+
+		observance := zoneObservance{}
+		var err error
+		if observance.onsets.Start, err = time.Parse(localDateTimeFormat, start.Value); err != nil {
+			continue
+		}
+		if observance.from, err = parseOffset(from.Value); err != nil {
+			continue
+		}
+		if observance.to, err = parseOffset(to.Value); err != nil {
+			continue
+		}
+		if p, ok := o.Get("RRULE"); ok {
+			if rule, err := recurrence.ParseRule(p.Value); err == nil {
+				observance.onsets.Rule = &rule
+			}
+		}
+		for _, p := range o.All("RDATE") {
+			for _, value := range strings.Split(p.Value, ",") {
+				if t, err := time.Parse(localDateTimeFormat, value); err == nil {
+					observance.onsets.RDates = append(observance.onsets.RDates, t)
+				}
+			}
+		}
+		zone.observances = append(zone.observances, observance)
+	}
+
+	if len(zone.observances) == 0 {
+		return nil
+	}
+	return zone
+}
+
+// instant converts a local time to the instant it stands for, using the
+// observance with the latest onset before it.
+func (z *definedZone) instant(local time.Time) time.Time {
+	offset := z.observances[0].from
+	var latest time.Time
+	for _, o := range z.observances {
+		onsets := o.onsets.Between(o.onsets.Start, local.Add(time.Nanosecond), maxOnsets)
+		if len(onsets) == 0 {
+			continue
+		}
+		if onset := onsets[len(onsets)-1]; latest.IsZero() || onset.After(latest) {
+			latest, offset = onset, o.to
+		}
+	}
+	return local.Add(-time.Duration(offset) * time.Second).UTC()
+}
+
+// parseOffset reads a UTC offset like "+0100" or "-093000" as seconds.
+func parseOffset(value string) (int, error) {
+	if len(value) != 5 && len(value) != 7 || (value[0] != '+' && value[0] != '-') {
+		return 0, fmt.Errorf("invalid offset %q", value)
+	}
+
+	seconds := 0
+	for i, unit := range []int{3600, 60, 1} {
+		if 1+2*i >= len(value) {
+			break
+		}
+		n, err := strconv.Atoi(value[1+2*i : 3+2*i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid offset %q", value)
+		}
+		seconds += n * unit
+	}
+	if value[0] == '-' {
+		seconds = -seconds
+	}
+	return seconds, nil
+}
@@ -0,0 +1,205 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ErrInvalidCalendar = errors.New("invalid iCalendar object")
+
+// maxNesting limits how deep components may be nested.
+const maxNesting = 8
+
+// Component is a parsed BEGIN/END block, e.g. a VEVENT.
+type Component struct {
+	Name       string
+	Properties []Property
+	Components []*Component
+}
+
+// Property is a content line. Parameter names are upper case, values are
+// kept as they are written, TEXT values still escaped.
+type Property struct {
+	Name       string
+	Parameters map[string]string
+	Value      string
+}
+
+// Get returns the first property with the name.
+func (c *Component) Get(name string) (Property, bool) {
+	for _, p := range c.Properties {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Property{}, false
+}
+
+// All returns every property with the name.
+func (c *Component) All(name string) []Property {
+	properties := []Property{}
+	for _, p := range c.Properties {
+		if p.Name == name {
+			properties = append(properties, p)
+		}
+	}
+	return properties
+}
+
+// Children returns the nested components with the name.
+func (c *Component) Children(name string) []*Component {
+	children := []*Component{}
+	for _, child := range c.Components {
+		if child.Name == name {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// Text returns the unescaped value of a TEXT property.
+func (p Property) Text() string {
+	var text strings.Builder
+	escaped := false
+	for _, r := range p.Value {
+		if !escaped {
+			if r == '\\' {
+				escaped = true
+			} else {
+				text.WriteRune(r)
+			}
+			continue
+		}
+
+		escaped = false
+		if r == 'n' || r == 'N' {
+			text.WriteRune('\n')
+		} else {
+			text.WriteRune(r)
+		}
+	}
+	return text.String()
+}
+
+// Parse reads an iCalendar stream and returns its VCALENDAR.
+func Parse(r io.Reader) (*Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var root *Component
+	stack := []*Component{}
+	for number, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		property, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %s", ErrInvalidCalendar, number+1, err)
+		}
+
+		switch property.Name {
+		case "BEGIN":
+			if root != nil && len(stack) == 0 {
+				return nil, fmt.Errorf("%w: content after END:VCALENDAR", ErrInvalidCalendar)
+			}
+			if len(stack) == maxNesting {
+				return nil, fmt.Errorf("%w: components are nested too deep", ErrInvalidCalendar)
+			}
+
+			component := &Component{Name: strings.ToUpper(property.Value)}
+			if len(stack) == 0 {
+				if component.Name != "VCALENDAR" {
+					return nil, fmt.Errorf("%w: %s outside of VCALENDAR", ErrInvalidCalendar, component.Name)
+				}
+				root = component
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, component)
+			}
+			stack = append(stack, component)
+
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(property.Value) {
+				return nil, fmt.Errorf("%w: unexpected END:%s", ErrInvalidCalendar, property.Value)
+			}
+			stack = stack[:len(stack)-1]
+
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("%w: %s outside of VCALENDAR", ErrInvalidCalendar, property.Name)
+			}
+			current := stack[len(stack)-1]
+			current.Properties = append(current.Properties, property)
+		}
+	}
+
+	if root == nil || len(stack) != 0 {
+		return nil, fmt.Errorf("%w: the VCALENDAR is incomplete", ErrInvalidCalendar)
+	}
+	return root, nil
+}
+
+// unfold joins folded lines. Lines may end with CRLF or a bare LF.
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	lines := []string{}
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) != 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// parseLine splits a content line into its name, parameters and value.
+// Parameter values may be quoted to contain ":", ";" and ",".
+func parseLine(line string) (Property, error) {
+	property := Property{Parameters: map[string]string{}}
+
+	quoted := false
+	start := 0
+	name := ""
+	parameters := []string{}
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == ';' || r == ':':
+			if name == "" {
+				name = line[start:i]
+			} else {
+				parameters = append(parameters, line[start:i])
+			}
+			start = i + 1
+			if r == ':' {
+				property.Value = line[i+1:]
+				property.Name = strings.ToUpper(name)
+				if property.Name == "" {
+					return property, errors.New("the property has no name")
+				}
+
+				for _, parameter := range parameters {
+					pair := strings.SplitN(parameter, "=", 2)
+					if len(pair) != 2 {
+						return property, fmt.Errorf("invalid parameter %q", parameter)
+					}
+					property.Parameters[strings.ToUpper(pair[0])] = strings.Trim(pair[1], `"`)
+				}
+				return property, nil
+			}
+		}
+	}
+	return property, errors.New("the line has no value")
+}
@@ -0,0 +1,160 @@
+package ical
+
+import (
+	"fmt"
+	"time"
+)
+
+// transition is a change of the UTC offset of a zone.
+type transition struct {
+	at       time.Time
+	from, to int
+	name     string
+}
+
+// daylight reports whether the change starts daylight saving time. Go does
+// not tell, an offset moving forward is taken as the start.
+func (t transition) daylight() bool {
+	return t.to > t.from
+}
+
+// local is the wall clock time the change happens at, in the offset before it.
+func (t transition) local() time.Time {
+	return t.at.Add(time.Duration(t.from) * time.Second).UTC()
+}
+
+// rule describes the day of the change as the nth weekday of its month,
+// counting from the end in the last week of the month.
+func (t transition) rule() string {
+	local := t.local()
+	last := time.Date(local.Year(), local.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+
+	n := (local.Day()-1)/7 + 1
+	if local.Day()+7 > last {
+		n = -1
+	}
+	return fmt.Sprintf("BYMONTH=%d;BYDAY=%d%s", local.Month(), n, weekdayNames[local.Weekday()])
+}
+
+var weekdayNames = map[time.Weekday]string{
+	time.Sunday:    "SU",
+	time.Monday:    "MO",
+	time.Tuesday:   "TU",
+	time.Wednesday: "WE",
+	time.Thursday:  "TH",
+	time.Friday:    "FR",
+	time.Saturday:  "SA",
+}
+
+// transitions finds the offset changes of the location between from and to.
+func transitions(location *time.Location, from time.Time, to time.Time) []transition {
+	found := []transition{}
+	_, previous := from.In(location).Zone()
+
+	for day := from; day.Before(to); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		name, offset := next.In(location).Zone()
+		if offset == previous {
+			continue
+		}
+
+		// the change is in this day, narrow it down to the second
+		low, high := day, next
+		for high.Sub(low) > time.Second {
+			middle := low.Add(high.Sub(low) / 2)
+			if _, o := middle.In(location).Zone(); o == previous {
+				low = middle
+			} else {
+				high = middle
+			}
+		}
+
+		found = append(found, transition{at: high, from: previous, to: offset, name: name})
+		previous = offset
+	}
+	return found
+}
+
+// observance is a STANDARD or DAYLIGHT component. Transitions following the
+// same rule in consecutive years share one observance with an RRULE.
+type observance struct {
+	transitions []transition
+	open        bool
+}
+
+func (o observance) matches(t transition) bool {
+	last := o.transitions[len(o.transitions)-1]
+	return last.daylight() == t.daylight() &&
+		last.from == t.from && last.to == t.to && last.name == t.name &&
+		last.rule() == t.rule() &&
+		last.local().Format("150405") == t.local().Format("150405") &&
+		last.local().Year()+1 == t.local().Year()
+}
+
+// writeTimeZone writes the VTIMEZONE of a zone covering the years from and
+// to. Rules still in use in the last year are left open, so they cover the
+// years after it as well.
+func writeTimeZone(out *writer, name string, from int, to int) {
+	zone := location(name)
+	start := time.Date(from, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(to+1, time.January, 1, 0, 0, 0, 0, time.UTC)
+	changes := transitions(zone, start, end)
+
+	out.property("BEGIN", "VTIMEZONE")
+	out.text("TZID", name)
+
+	// the offset in effect before the first change
+	initialName, initialOffset := start.In(zone).Zone()
+	initial := "STANDARD"
+	if len(changes) != 0 && !changes[0].daylight() {
+		initial = "DAYLIGHT"
+	}
+	out.property("BEGIN", initial)
+	out.property("DTSTART", start.Format(localDateTimeFormat))
+	out.property("TZOFFSETFROM", offset(initialOffset))
+	out.property("TZOFFSETTO", offset(initialOffset))
+	out.text("TZNAME", initialName)
+	out.property("END", initial)
+
+	observances := []*observance{}
+	current := map[bool]*observance{}
+	for _, change := range changes {
+		if o, ok := current[change.daylight()]; ok && o.matches(change) {
+			o.transitions = append(o.transitions, change)
+			continue
+		}
+		o := &observance{transitions: []transition{change}}
+		observances = append(observances, o)
+		current[change.daylight()] = o
+	}
+	for _, o := range current {
+		last := o.transitions[len(o.transitions)-1]
+		o.open = len(o.transitions) > 1 && last.local().Year() == to
+	}
+
+	for _, o := range observances {
+		first := o.transitions[0]
+		last := o.transitions[len(o.transitions)-1]
+
+		kind := "STANDARD"
+		if first.daylight() {
+			kind = "DAYLIGHT"
+		}
+
+		out.property("BEGIN", kind)
+		out.property("DTSTART", first.local().Format(localDateTimeFormat))
+		out.property("TZOFFSETFROM", offset(first.from))
+		out.property("TZOFFSETTO", offset(first.to))
+		if len(o.transitions) > 1 {
+			rule := "FREQ=YEARLY;" + first.rule()
+			if !o.open {
+				rule += ";UNTIL=" + last.at.UTC().Format(utcDateTimeFormat)
+			}
+			out.property("RRULE", rule)
+		}
+		out.text("TZNAME", first.name)
+		out.property("END", kind)
+	}
+
+	out.property("END", "VTIMEZONE")
+}
@@ -0,0 +1,124 @@
+package ical
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	dateFormat          = "20060102"
+	localDateTimeFormat = "20060102T150405"
+	utcDateTimeFormat   = "20060102T150405Z"
+	// maxLineLength is the length in octets a content line is folded at.
+	maxLineLength = 75
+)
+
+// writer writes content lines, keeping the first error.
+type writer struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+// property writes a content line folded at 75 octets. The name may carry
+// parameters, e.g. "DTSTART;TZID=Europe/Berlin".
+func (w *writer) property(name string, value string) {
+	if w.err != nil {
+		return
+	}
+
+	line := name + ":" + value
+	var folded strings.Builder
+	length := 0
+	for len(line) > 0 {
+		r, size := utf8.DecodeRuneInString(line)
+		if r == utf8.RuneError && size <= 1 {
+			r, size = utf8.RuneError, 1
+		}
+		if length+size > maxLineLength {
+			folded.WriteString("\r\n ")
+			length = 1
+		}
+		folded.WriteString(line[:size])
+		length += size
+		line = line[size:]
+	}
+	folded.WriteString("\r\n")
+
+	n, err := io.WriteString(w.w, folded.String())
+	w.n += int64(n)
+	w.err = err
+}
+
+// text writes a TEXT property, empty values are left out.
+func (w *writer) text(name string, value string) {
+	if value != "" {
+		w.property(name, escape(value))
+	}
+}
+
+var textEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`;`, `\;`,
+	`,`, `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+	"\r", `\n`,
+)
+
+// escape escapes a TEXT value.
+func escape(value string) string {
+	return textEscaper.Replace(value)
+}
+
+// dateTime formats t as DATE, as local DATE-TIME with a TZID, or in UTC. It
+// returns the parameters to add to the property name and the value.
+func dateTime(t time.Time, timeZone string, allDay bool) (string, string) {
+	location := location(timeZone)
+
+	if allDay {
+		return ";VALUE=DATE", t.In(location).Format(dateFormat)
+	}
+	if location == time.UTC {
+		return "", t.UTC().Format(utcDateTimeFormat)
+	}
+	return ";TZID=" + timeZone, t.In(location).Format(localDateTimeFormat)
+}
+
+// dateTimes formats a list the way dateTime formats a single value.
+func dateTimes(times []time.Time, timeZone string, allDay bool) (string, string) {
+	parameters, values := "", make([]string, len(times))
+	for i, t := range times {
+		parameters, values[i] = dateTime(t, timeZone, allDay)
+	}
+	return parameters, strings.Join(values, ",")
+}
+
+// location loads an IANA time zone. Times without a usable zone are written
+// in UTC.
+func location(timeZone string) *time.Location {
+	if timeZone == "" || timeZone == "UTC" || timeZone == "Local" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// offset formats a UTC offset in seconds as "+0100" or "-0930".
+func offset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign, seconds = "-", -seconds
+	}
+	formatted := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		formatted += fmt.Sprintf("%02d", seconds%60)
+	}
+	return formatted
+}
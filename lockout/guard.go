@@ -0,0 +1,207 @@
+package lockout
+
+import (
+	"log"
+	"site/audit"
+	"site/database/models"
+	"strings"
+	"time"
+)
+
+// Policy describes how failed logins of one key are throttled. Every failure
+// doubles the time the next attempt has to wait, starting at BaseDelay and
+// capped at MaxDelay. After MaxFailures the key is locked for Lockout.
+// Failures older than Lockout are forgotten.
+type Policy struct {
+	MaxFailures int
+	Lockout     time.Duration
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var (
+	DefaultAccountPolicy = Policy{MaxFailures: 5, Lockout: 15 * time.Minute, BaseDelay: time.Second, MaxDelay: time.Minute}
+	DefaultIPPolicy      = Policy{MaxFailures: 50, Lockout: 15 * time.Minute, BaseDelay: 0, MaxDelay: 0}
+)
+
+// LoginGuard decides whether a login attempt may be evaluated at all.
+type LoginGuard interface {
+	Attempt(email string, ip string) (time.Duration, error)
+	Success(email string, ip string) error
+}
+
+func NewGuard(store Store, logger audit.Logger) *Guard {
+	return &Guard{
+		Account: DefaultAccountPolicy,
+		IP:      DefaultIPPolicy,
+		store:   store,
+		logger:  logger,
+		now:     time.Now,
+	}
+}
+
+// Guard tracks failed logins per account and per client IP.
+type Guard struct {
+	Account Policy
+	IP      Policy
+	store   Store
+	logger  audit.Logger
+	now     func() time.Time
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Attempt counts an attempt for the account and the IP before it is
+// evaluated and returns how long the client has to wait. Zero means the
+// attempt may proceed, it counts as failed until Success takes it back. As
+// the count is taken first, parallel guesses can not get past the limit.
+func (g *Guard) Attempt(email string, ip string) (time.Duration, error) {
+	wait, err := g.attempt(accountKey(email), g.Account, audit.ActionAccountLocked, ip)
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+
+	wait, err = g.attempt(ipKey(ip), g.IP, audit.ActionIPLocked, ip)
+	if err != nil || wait > 0 {
+		// the attempt is not evaluated, so it does not count for the account
+		if err := g.forgive(accountKey(email)); err != nil {
+			log.Printf("Failed to take back a login attempt %s \n", err)
+		}
+		return wait, err
+	}
+
+	return 0, nil
+}
+
+// Success forgets the failures of the account. The IP counter only takes the
+// attempt back, so an attacker can not reset it by logging into an account of
+// their own.
+func (g *Guard) Success(email string, ip string) error {
+	if err := g.store.Reset(accountKey(email)); err != nil {
+		return err
+	}
+	return g.forgive(ipKey(ip))
+}
+
+// Unlock lifts the lockout of an account.
+func (g *Guard) Unlock(email string, actor *models.User) error {
+	if err := g.store.Reset(accountKey(email)); err != nil {
+		return err
+	}
+
+	entry := models.AuditLog{Action: audit.ActionAccountUnlocked, Subject: accountKey(email)}
+	if actor != nil {
+		entry.ActorID = &actor.ID
+	}
+	return g.logger.Record(entry)
+}
+
+// Locked reports whether the account is locked right now. An account that
+// used up its attempts is locked by the next one.
+func (g *Guard) Locked(email string) (bool, error) {
+	attempts, err := g.store.Get(accountKey(email))
+	if err != nil {
+		return false, err
+	}
+
+	now := g.now()
+	attempts = g.Account.current(attempts, now)
+	return now.Before(attempts.LockedUntil) || g.Account.exhausted(attempts), nil
+}
+
+// attempt decides on an attempt from the counted attempts of the key and
+// counts it in the same atomic update. The lockout is audited once, by the
+// attempt that started it.
+func (g *Guard) attempt(key string, p Policy, action string, ip string) (time.Duration, error) {
+	var wait time.Duration
+	locked := false
+	_, err := g.store.Update(key, func(attempts Attempts) Attempts {
+		now := g.now()
+		attempts = p.current(attempts, now)
+		wait, locked = 0, false
+
+		if now.Before(attempts.LockedUntil) {
+			wait = attempts.LockedUntil.Sub(now)
+			return attempts
+		}
+
+		if p.exhausted(attempts) {
+			attempts.LockedUntil = now.Add(p.Lockout)
+			wait, locked = p.Lockout, true
+			return attempts
+		}
+
+		if attempts.Failures > 0 {
+			wait = attempts.LastFailure.Add(p.delay(attempts.Failures)).Sub(now)
+			if wait > 0 {
+				return attempts
+			}
+			wait = 0
+		}
+
+		attempts.Failures++
+		attempts.LastFailure = now
+		return attempts
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if locked {
+		g.record(action, key, ip)
+	}
+	return wait, nil
+}
+
+// forgive takes back an attempt that did not fail.
+func (g *Guard) forgive(key string) error {
+	_, err := g.store.Update(key, func(attempts Attempts) Attempts {
+		if attempts.Failures > 0 {
+			attempts.Failures--
+		}
+		return attempts
+	})
+	return err
+}
+
+func (g *Guard) record(action string, subject string, ip string) {
+	if err := g.logger.Record(models.AuditLog{Action: action, Subject: subject, IP: ip}); err != nil {
+		log.Printf("Failed to write the audit log %s \n", err)
+	}
+}
+
+// delay is the backoff after the given number of failures.
+func (p Policy) delay(failures int) time.Duration {
+	if p.BaseDelay == 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return delay
+}
+
+// current drops expired lockouts and failures from the attempts.
+func (p Policy) current(attempts Attempts, now time.Time) Attempts {
+	lockExpired := !attempts.LockedUntil.IsZero() && !now.Before(attempts.LockedUntil)
+	if lockExpired || now.Sub(attempts.LastFailure) > p.Lockout {
+		return Attempts{}
+	}
+	return attempts
+}
+
+// exhausted reports whether the attempts used up every allowed failure.
+func (p Policy) exhausted(attempts Attempts) bool {
+	return p.MaxFailures > 0 && attempts.Failures >= p.MaxFailures
+}
@@ -0,0 +1,119 @@
+package lockout
+
+import (
+	"site/database/models"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Attempts are the failed logins counted for one key.
+type Attempts struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// Store keeps the attempts of every key. Update changes the attempts of a key
+// atomically, so concurrent logins never overwrite each other's counts.
+type Store interface {
+	Get(key string) (Attempts, error)
+	Update(key string, change func(Attempts) Attempts) (Attempts, error)
+	Reset(key string) error
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: map[string]Attempts{}}
+}
+
+// MemoryStore keeps the counters of a single process.
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+}
+
+func (s *MemoryStore) Get(key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts[key], nil
+}
+
+func (s *MemoryStore) Update(key string, change func(Attempts) Attempts) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts := change(s.attempts[key])
+	s.attempts[key] = attempts
+	return attempts, nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+func NewGormStore(connection *gorm.DB) *GormStore {
+	return &GormStore{connection: connection}
+}
+
+// GormStore shares the counters between every instance of the application.
+type GormStore struct {
+	connection *gorm.DB
+}
+
+func (s *GormStore) Get(key string) (Attempts, error) {
+	attempt := models.LoginAttempt{}
+	result := s.connection.Where("subject = ?", key).Limit(1).Find(&attempt)
+	if result.Error != nil {
+		return Attempts{}, result.Error
+	}
+
+	return Attempts{
+		Failures:    attempt.Failures,
+		LastFailure: attempt.LastFailure,
+		LockedUntil: attempt.LockedUntil,
+	}, nil
+}
+
+// Update locks the row of the key with SELECT ... FOR UPDATE until the
+// changed attempts are written. The row is created first, so there is always
+// a row to lock.
+func (s *GormStore) Update(key string, change func(Attempts) Attempts) (Attempts, error) {
+	var attempts Attempts
+	err := s.connection.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "subject"}},
+			DoNothing: true,
+		}).Create(&models.LoginAttempt{Subject: key})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		attempt := models.LoginAttempt{}
+		result = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("subject = ?", key).First(&attempt)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		attempts = change(Attempts{
+			Failures:    attempt.Failures,
+			LastFailure: attempt.LastFailure,
+			LockedUntil: attempt.LockedUntil,
+		})
+
+		return tx.Model(&attempt).Updates(map[string]interface{}{
+			"failures":     attempts.Failures,
+			"last_failure": attempts.LastFailure,
+			"locked_until": attempts.LockedUntil,
+		}).Error
+	})
+
+	return attempts, err
+}
+
+func (s *GormStore) Reset(key string) error {
+	return s.connection.Unscoped().Where("subject = ?", key).Delete(&models.LoginAttempt{}).Error
+}
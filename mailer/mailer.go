@@ -0,0 +1,103 @@
+package mailer
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(m Message) error
+}
+
+func NewSMTPMailer(host string, port string, username string, password string, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPMailer{
+		addr: host + ":" + port,
+		auth: auth,
+		from: from,
+	}
+}
+
+// NewSMTPMailerFromEnv configures the mailer through SMTP_HOST, SMTP_PORT,
+// SMTP_USERNAME, SMTP_PASSWORD and MAIL_FROM.
+func NewSMTPMailerFromEnv() *SMTPMailer {
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	return NewSMTPMailer(
+		os.Getenv("SMTP_HOST"),
+		port,
+		os.Getenv("SMTP_USERNAME"),
+		os.Getenv("SMTP_PASSWORD"),
+		os.Getenv("MAIL_FROM"),
+	)
+}
+
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func (m *SMTPMailer) Send(message Message) error {
+	if strings.ContainsAny(message.To+message.Subject, "\r\n") {
+		return fmt.Errorf("invalid header value")
+	}
+
+	body := "From: " + m.from + "\r\n" +
+		"To: " + message.To + "\r\n" +
+		"Subject: " + message.Subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		message.Body
+
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{message.To}, []byte(body))
+}
+
+func NewInMemoryMailer() *InMemoryMailer {
+	return &InMemoryMailer{}
+}
+
+// InMemoryMailer keeps every message instead of delivering it. It is meant
+// for tests.
+type InMemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *InMemoryMailer) Send(message Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// Sent returns the messages sent to the address.
+func (m *InMemoryMailer) Sent(to string) []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sent := []Message{}
+	for _, message := range m.messages {
+		if message.To == to {
+			sent = append(sent, message)
+		}
+	}
+	return sent
+}
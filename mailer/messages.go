@@ -0,0 +1,59 @@
+package mailer
+
+import (
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// link builds an absolute link to the application, which is reachable at
+// APP_URL.
+func link(path string, token string) string {
+	return os.Getenv("APP_URL") + path + "?token=" + url.QueryEscape(token)
+}
+
+func VerificationMessage(to string, token string) Message {
+	return Message{
+		To:      to,
+		Subject: "Verify your email address",
+		Body: "Please confirm your email address by opening the link below.\r\n\r\n" +
+			link("/verify-email", token) + "\r\n\r\n" +
+			"If you did not create an account, you can ignore this message.\r\n",
+	}
+}
+
+func PasswordResetMessage(to string, token string) Message {
+	return Message{
+		To:      to,
+		Subject: "Reset your password",
+		Body: "Somebody asked to reset the password of your account. Open the link below to choose a new one.\r\n\r\n" +
+			link("/password/reset", token) + "\r\n\r\n" +
+			"The link expires in one hour. If you did not ask for it, you can ignore this message.\r\n",
+	}
+}
+
+// WaitlistPromotionMessage tells an attendee on the waitlist that a seat freed
+// up. The name of the event is left out of the subject since it is not a safe
+// header value.
+func WaitlistPromotionMessage(to string, eventName string, eventID uint) Message {
+	return Message{
+		To:      to,
+		Subject: "You got a seat",
+		Body: "A seat freed up and you are no longer on the waitlist of \"" + eventName + "\". You are going now.\r\n\r\n" +
+			os.Getenv("APP_URL") + "/event/" + strconv.FormatUint(uint64(eventID), 10) + "\r\n\r\n" +
+			"If you can not make it, please change your answer so somebody else gets the seat.\r\n",
+	}
+}
+
+// InvitationMessage invites somebody to an event. The links work without an
+// account, the same token accepts and declines.
+func InvitationMessage(to string, eventName string, token string) Message {
+	return Message{
+		To:      to,
+		Subject: "You are invited to an event",
+		Body: "You are invited to \"" + eventName + "\". Let the organizer know whether you are coming.\r\n\r\n" +
+			"Accept: " + link("/invitations/accept", token) + "\r\n" +
+			"Decline: " + link("/invitations/decline", token) + "\r\n\r\n" +
+			"The links expire in 30 days. Accepted invitations are added to your account once you register and verify this address.\r\n",
+	}
+}
@@ -0,0 +1,30 @@
+package media
+
+import (
+	"log"
+	"site/database/models"
+	"site/uploader"
+
+	"gorm.io/gorm"
+)
+
+// RemoveFiles removes the files of media records that have been deleted.
+// Files another record still points at, deleted or not, are kept, uploads
+// made before they had names of their own share one file. A file that can
+// not be removed is only logged.
+func RemoveFiles(db *gorm.DB, files uploader.Uploader, media []models.Media) {
+	for _, m := range media {
+		var references int64
+		if err := db.Unscoped().Model(&models.Media{}).Where("path = ?", m.Path).Count(&references).Error; err != nil {
+			log.Printf("Failed to check the media file %s %s \n", m.Path, err)
+			continue
+		}
+		if references != 0 {
+			continue
+		}
+
+		if err := files.Remove(m.Path); err != nil {
+			log.Printf("Failed to remove the media file %s %s \n", m.Path, err)
+		}
+	}
+}
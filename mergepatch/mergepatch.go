@@ -0,0 +1,45 @@
+package mergepatch
+
+import "encoding/json"
+
+// ContentType is the media type of JSON Merge Patch documents.
+const ContentType = "application/merge-patch+json"
+
+// Apply applies a JSON Merge Patch as described by RFC 7386 to a JSON
+// document. Members of the patch replace the members of the document, objects
+// are merged recursively and null removes a member.
+func Apply(document []byte, patch []byte) ([]byte, error) {
+	var target interface{}
+	if err := json.Unmarshal(document, &target); err != nil {
+		return nil, err
+	}
+
+	var changes interface{}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(merge(target, changes))
+}
+
+func merge(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = merge(targetObject[name], value)
+	}
+
+	return targetObject
+}
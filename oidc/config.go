@@ -0,0 +1,60 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Providers holds the configured providers by name.
+type Providers map[string]*Provider
+
+func NewProviders(providers ...*Provider) Providers {
+	registry := Providers{}
+	for _, provider := range providers {
+		registry[provider.Name()] = provider
+	}
+	return registry
+}
+
+// Get returns the provider with the given name.
+func (p Providers) Get(name string) (*Provider, bool) {
+	provider, ok := p[name]
+	return provider, ok
+}
+
+// ProvidersFromEnv reads the provider registrations from the JSON file
+// OIDC_PROVIDERS_FILE points to. Without the variable no provider is
+// configured.
+func ProvidersFromEnv() (Providers, error) {
+	path := os.Getenv("OIDC_PROVIDERS_FILE")
+	if path == "" {
+		return Providers{}, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := []ProviderConfig{}
+	if err := json.Unmarshal(content, &configs); err != nil {
+		return nil, err
+	}
+
+	providers := Providers{}
+	for _, config := range configs {
+		if config.Name == "" || config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+			return nil, fmt.Errorf("provider %q needs a name, issuer, client_id and redirect_url", config.Name)
+		}
+
+		if _, ok := providers[config.Name]; ok {
+			return nil, fmt.Errorf("duplicate provider %q", config.Name)
+		}
+
+		providers[config.Name] = NewProvider(config, nil)
+	}
+
+	return providers, nil
+}
@@ -0,0 +1,128 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// prefixLength is the number of hash characters a range is looked up by.
+const prefixLength = 5
+
+// BreachedList tells whether a password is known from a breach.
+type BreachedList interface {
+	Contains(password string) (bool, error)
+}
+
+// LoadBreachedList opens a list of SHA-1 password hashes in the k-anonymity
+// range format. A directory is expected to hold one file per five character
+// hash prefix, named after the prefix with an optional ".txt" extension, with
+// lines of the remaining 35 characters followed by ":<count>". A single file
+// holds lines of complete hashes, optionally followed by ":<count>", and is
+// loaded into memory.
+func LoadBreachedList(path string) (BreachedList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &RangeDirectory{path: path}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := MemoryList{}
+	err = scanHashes(file, func(hash string) error {
+		if len(hash) != sha1.Size*2 {
+			return fmt.Errorf("%s contains the invalid hash %q", path, hash)
+		}
+		list[hash] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// MemoryList is a set of upper case hex encoded SHA-1 hashes.
+type MemoryList map[string]struct{}
+
+func (l MemoryList) Contains(password string) (bool, error) {
+	_, ok := l[hashPassword(password)]
+	return ok, nil
+}
+
+// RangeDirectory looks up the range file of a hash prefix on every check, so
+// only a small part of the list is read at a time.
+type RangeDirectory struct {
+	path string
+}
+
+func (d *RangeDirectory) Contains(password string) (bool, error) {
+	hash := hashPassword(password)
+	prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+
+	file, err := os.Open(filepath.Join(d.path, prefix))
+	if os.IsNotExist(err) {
+		file, err = os.Open(filepath.Join(d.path, prefix+".txt"))
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	found := false
+	err = scanHashes(file, func(candidate string) error {
+		if candidate == suffix {
+			found = true
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+
+	return found, nil
+}
+
+func hashPassword(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// scanHashes calls fn with the upper cased hash of every non empty line,
+// dropping the count.
+func scanHashes(r io.Reader, fn func(hash string) error) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if colon := strings.IndexByte(line, ':'); colon >= 0 {
+			line = line[:colon]
+		}
+
+		if err := fn(strings.ToUpper(line)); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,193 @@
+package passwords
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Violations are reported as validation tags, like the struct validation does.
+const (
+	ViolationMinLength = "min"
+	ViolationMaxLength = "max"
+	ViolationUpper     = "uppercase"
+	ViolationLower     = "lowercase"
+	ViolationDigit     = "digit"
+	ViolationSymbol    = "symbol"
+	ViolationEmail     = "email"
+	ViolationBreached  = "breached"
+)
+
+// bcryptMaxBytes is the length after which bcrypt ignores the input.
+const bcryptMaxBytes = 72
+
+// Policy describes the passwords accepted for accounts.
+type Policy struct {
+	MinLength     int
+	MaxBytes      int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	DisallowEmail bool
+}
+
+var DefaultPolicy = Policy{MinLength: 8, MaxBytes: bcryptMaxBytes, DisallowEmail: true}
+
+// Validator checks a new password of the account with the given email.
+type Validator interface {
+	Validate(password string, email string) ([]string, error)
+}
+
+func NewChecker(policy Policy, breached BreachedList) *Checker {
+	return &Checker{policy: policy, breached: breached}
+}
+
+// Checker enforces a policy and, when a list is configured, rejects
+// passwords known from breaches.
+type Checker struct {
+	policy   Policy
+	breached BreachedList
+}
+
+// Validate returns the violated rules. Lengths are counted in characters,
+// the maximum in bytes since that is what bcrypt limits.
+func (c *Checker) Validate(password string, email string) ([]string, error) {
+	violations := c.policy.violations(password, email)
+
+	if c.breached != nil {
+		breached, err := c.breached.Contains(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, ViolationBreached)
+		}
+	}
+
+	return violations, nil
+}
+
+func (p Policy) violations(password string, email string) []string {
+	violations := []string{}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, ViolationMinLength)
+	}
+
+	maxBytes := p.MaxBytes
+	if maxBytes == 0 || maxBytes > bcryptMaxBytes {
+		maxBytes = bcryptMaxBytes
+	}
+	if len(password) > maxBytes {
+		violations = append(violations, ViolationMaxLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	if p.RequireUpper && !upper {
+		violations = append(violations, ViolationUpper)
+	}
+	if p.RequireLower && !lower {
+		violations = append(violations, ViolationLower)
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, ViolationDigit)
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, ViolationSymbol)
+	}
+
+	if p.DisallowEmail && containsEmail(password, email) {
+		violations = append(violations, ViolationEmail)
+	}
+
+	return violations
+}
+
+// containsEmail catches the address as well as its local part.
+func containsEmail(password string, email string) bool {
+	password = strings.ToLower(password)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return false
+	}
+
+	if strings.Contains(password, email) {
+		return true
+	}
+
+	local := email
+	if at := strings.Index(email, "@"); at >= 0 {
+		local = email[:at]
+	}
+	return len(local) >= 4 && strings.Contains(password, local)
+}
+
+// PolicyFromEnv adjusts the default policy with PASSWORD_MIN_LENGTH and
+// PASSWORD_REQUIRE, a comma separated list of the character classes
+// "uppercase", "lowercase", "digit" and "symbol".
+func PolicyFromEnv() (Policy, error) {
+	policy := DefaultPolicy
+
+	if value := os.Getenv("PASSWORD_MIN_LENGTH"); value != "" {
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 1 {
+			return policy, errors.New("PASSWORD_MIN_LENGTH has to be a positive number")
+		}
+		policy.MinLength = length
+	}
+
+	if value := os.Getenv("PASSWORD_REQUIRE"); value != "" {
+		for _, class := range strings.Split(value, ",") {
+			switch strings.TrimSpace(class) {
+			case ViolationUpper:
+				policy.RequireUpper = true
+			case ViolationLower:
+				policy.RequireLower = true
+			case ViolationDigit:
+				policy.RequireDigit = true
+			case ViolationSymbol:
+				policy.RequireSymbol = true
+			default:
+				return policy, errors.New("PASSWORD_REQUIRE contains the unknown class " + class)
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+// CheckerFromEnv builds the checker from PolicyFromEnv and the breached
+// password list PASSWORD_BREACHED_LIST points to, if any.
+func CheckerFromEnv() (*Checker, error) {
+	policy, err := PolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	var breached BreachedList
+	if path := os.Getenv("PASSWORD_BREACHED_LIST"); path != "" {
+		breached, err = LoadBreachedList(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewChecker(policy, breached), nil
+}
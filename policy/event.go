@@ -0,0 +1,35 @@
+package policy
+
+import (
+	"site/database/models"
+	"site/http/middlewares"
+)
+
+// CanManageEvent reports whether the principal may change the event. Owners
+// manage their own events, admins manage every event, unless they use an API
+// key without the scope to do so.
+func CanManageEvent(p *middlewares.Principal, e *models.Event) bool {
+	return e.UserID == p.User.ID || PrincipalCan(p, ManageAnyEvent)
+}
+
+// CanViewEvent reports whether the principal may see the event while it is a
+// draft or not public. The role is the one of the user as a collaborator of
+// the event, empty for everybody else. Collaborators see it whatever their
+// role.
+func CanViewEvent(p *middlewares.Principal, e *models.Event, role string) bool {
+	return role != "" || CanManageEvent(p, e)
+}
+
+// CanEditEvent reports whether the principal may change the details of the
+// event. Removing it and sharing it is left to those who manage it.
+func CanEditEvent(p *middlewares.Principal, e *models.Event, role string) bool {
+	return role == models.CollaboratorEditor || CanManageEvent(p, e)
+}
+
+// CanUploadMedia reports whether the principal may attach media to the event.
+func CanUploadMedia(p *middlewares.Principal, e *models.Event, role string) bool {
+	if !PrincipalCan(p, UploadMedia) {
+		return false
+	}
+	return role == models.CollaboratorEditor || role == models.CollaboratorMediaUploader || CanManageEvent(p, e)
+}
@@ -0,0 +1,12 @@
+package policy
+
+import (
+	"site/database/models"
+	"site/http/middlewares"
+)
+
+// CanManageMedia reports whether the principal may change or remove media
+// attached to the event.
+func CanManageMedia(p *middlewares.Principal, m *models.Media, e *models.Event) bool {
+	return m.EventId == e.ID && CanManageEvent(p, e)
+}
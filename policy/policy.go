@@ -0,0 +1,91 @@
+package policy
+
+import (
+	"net/http"
+	"site/database/models"
+	"site/http/middlewares"
+	"site/http/responses"
+	"site/security"
+)
+
+type Permission string
+
+const (
+	ReadEvents     Permission = "events:read"
+	CreateEvents   Permission = "events:create"
+	ManageAnyEvent Permission = "events:manage-any"
+	UploadMedia    Permission = "media:upload"
+	ManageUsers    Permission = "users:manage"
+)
+
+// AllPermissions lists every known permission.
+var AllPermissions = []Permission{ReadEvents, CreateEvents, ManageAnyEvent, UploadMedia, ManageUsers}
+
+var rolePermissions = map[string][]Permission{
+	models.RoleAdmin:     {ReadEvents, CreateEvents, ManageAnyEvent, UploadMedia, ManageUsers},
+	models.RoleOrganizer: {ReadEvents, CreateEvents, UploadMedia},
+	models.RoleViewer:    {ReadEvents},
+}
+
+// Role returns the role of the user. Accounts created before roles existed
+// are organizers.
+func Role(u *models.User) string {
+	if u.Role == "" {
+		return models.RoleOrganizer
+	}
+	return u.Role
+}
+
+// Permissions returns every permission granted to the user through its role.
+func Permissions(u *models.User) []Permission {
+	return rolePermissions[Role(u)]
+}
+
+func Can(u *models.User, p Permission) bool {
+	for _, granted := range Permissions(u) {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// PrincipalCan reports whether the request identity holds the permission. API
+// keys are limited to the scopes they have been created with.
+func PrincipalCan(principal *middlewares.Principal, p Permission) bool {
+	if !Can(&principal.User, p) {
+		return false
+	}
+
+	if principal.APIKey == nil {
+		return true
+	}
+
+	for _, scope := range security.APIKeyScopes(principal.APIKey) {
+		if Permission(scope) == p {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission only lets authenticated users holding the permission
+// through. It has to be composed after middlewares.AuthMiddleware.
+func RequirePermission(p Permission) middlewares.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			principal, ok := middlewares.PrincipalFromContext(r.Context())
+			if !ok {
+				responses.NewJsonResponse(rw, http.StatusUnauthorized, nil)
+				return
+			}
+
+			if !PrincipalCan(principal, p) {
+				responses.NewJsonResponse(rw, http.StatusForbidden, nil)
+				return
+			}
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package recurrence
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DateTimeFormat is the UTC form of an iCalendar DATE-TIME.
+const DateTimeFormat = "20060102T150405Z"
+
+// FormatDates joins the times as a comma separated list of UTC date-times,
+// the value of an RDATE or EXDATE.
+func FormatDates(dates []time.Time) string {
+	items := make([]string, len(dates))
+	for i, date := range dates {
+		items[i] = date.UTC().Format(DateTimeFormat)
+	}
+	return strings.Join(items, ",")
+}
+
+// ParseDates reads a list written by FormatDates.
+func ParseDates(value string) ([]time.Time, error) {
+	dates := []time.Time{}
+	if value == "" {
+		return dates, nil
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		date, err := time.Parse(DateTimeFormat, item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q", item)
+		}
+		dates = append(dates, date)
+	}
+	return dates, nil
+}
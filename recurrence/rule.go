@@ -0,0 +1,323 @@
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the FREQ of a rule.
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// MaxCount limits COUNT, so a rule can not describe an unbounded amount of
+// work for a single request.
+const MaxCount = 5000
+
+var ErrInvalidRule = errors.New("invalid recurrence rule")
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+var weekdayNames = map[time.Weekday]string{
+	time.Sunday:    "SU",
+	time.Monday:    "MO",
+	time.Tuesday:   "TU",
+	time.Wednesday: "WE",
+	time.Thursday:  "TH",
+	time.Friday:    "FR",
+	time.Saturday:  "SA",
+}
+
+// WeekdayNum is an entry of BYDAY. N picks the nth weekday of the month or the
+// year, counting from the end when negative, and every weekday when zero.
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+func (w WeekdayNum) String() string {
+	if w.N == 0 {
+		return weekdayNames[w.Day]
+	}
+	return strconv.Itoa(w.N) + weekdayNames[w.Day]
+}
+
+// Rule is an RFC 5545 RRULE. The BYHOUR, BYMINUTE, BYSECOND, BYYEARDAY and
+// BYWEEKNO parts are not supported, occurrences always start at the time of
+// day of the first one.
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	Until      *time.Time
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []int
+	BySetPos   []int
+	WeekStart  time.Weekday
+
+	// untilFloating is set when UNTIL is a local time or a date, which is
+	// meant in the time zone of the event rather than in UTC.
+	untilFloating bool
+	// untilDate is set when UNTIL is a date, so the whole day is included.
+	untilDate bool
+}
+
+// ParseRule parses the value of an RRULE, e.g. "FREQ=WEEKLY;BYDAY=TU,TH".
+func ParseRule(value string) (Rule, error) {
+	rule := Rule{Interval: 1, WeekStart: time.Monday}
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+	if value == "" {
+		return rule, ErrInvalidRule
+	}
+
+	seen := map[string]bool{}
+	for _, part := range strings.Split(value, ";") {
+		pair := strings.SplitN(part, "=", 2)
+		if len(pair) != 2 || pair[1] == "" {
+			return rule, fmt.Errorf("%w: %q", ErrInvalidRule, part)
+		}
+
+		name, v := strings.ToUpper(pair[0]), strings.ToUpper(pair[1])
+		if seen[name] {
+			return rule, fmt.Errorf("%w: %s is repeated", ErrInvalidRule, name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			rule.Freq = Frequency(v)
+			switch rule.Freq {
+			case Daily, Weekly, Monthly, Yearly:
+			default:
+				err = fmt.Errorf("%w: unsupported frequency %s", ErrInvalidRule, v)
+			}
+		case "INTERVAL":
+			rule.Interval, err = parseNumber(v, 1, 1000)
+		case "COUNT":
+			rule.Count, err = parseNumber(v, 1, MaxCount)
+		case "UNTIL":
+			err = rule.parseUntil(v)
+		case "BYDAY":
+			rule.ByDay, err = parseWeekdays(v)
+		case "BYMONTHDAY":
+			rule.ByMonthDay, err = parseNumbers(v, 31)
+		case "BYMONTH":
+			rule.ByMonth, err = parseNumbers(v, 12)
+			for _, month := range rule.ByMonth {
+				if month < 0 {
+					err = fmt.Errorf("%w: BYMONTH=%s", ErrInvalidRule, v)
+				}
+			}
+		case "BYSETPOS":
+			rule.BySetPos, err = parseNumbers(v, 366)
+		case "WKST":
+			day, ok := weekdays[v]
+			if !ok {
+				err = fmt.Errorf("%w: WKST=%s", ErrInvalidRule, v)
+			}
+			rule.WeekStart = day
+		default:
+			err = fmt.Errorf("%w: %s is not supported", ErrInvalidRule, name)
+		}
+		if err != nil {
+			return rule, err
+		}
+	}
+
+	if rule.Freq == "" {
+		return rule, fmt.Errorf("%w: FREQ is missing", ErrInvalidRule)
+	}
+	if rule.Count != 0 && rule.Until != nil {
+		return rule, fmt.Errorf("%w: COUNT and UNTIL can not be combined", ErrInvalidRule)
+	}
+	if len(rule.BySetPos) != 0 && len(rule.ByDay)+len(rule.ByMonthDay)+len(rule.ByMonth) == 0 {
+		return rule, fmt.Errorf("%w: BYSETPOS needs another BY part", ErrInvalidRule)
+	}
+
+	for _, day := range rule.ByDay {
+		if day.N != 0 && rule.Freq != Monthly && rule.Freq != Yearly {
+			return rule, fmt.Errorf("%w: numbered BYDAY needs FREQ=MONTHLY or FREQ=YEARLY", ErrInvalidRule)
+		}
+		if day.N != 0 && rule.Freq == Monthly && (day.N < -5 || day.N > 5) {
+			return rule, fmt.Errorf("%w: BYDAY=%s", ErrInvalidRule, day)
+		}
+	}
+	if rule.Freq == Weekly && len(rule.ByMonthDay) != 0 {
+		return rule, fmt.Errorf("%w: BYMONTHDAY can not be used with FREQ=WEEKLY", ErrInvalidRule)
+	}
+
+	return rule, nil
+}
+
+func (r *Rule) parseUntil(value string) error {
+	layouts := []struct {
+		layout   string
+		floating bool
+		date     bool
+	}{
+		{"20060102T150405Z", false, false},
+		{"20060102T150405", true, false},
+		{"20060102", true, true},
+	}
+
+	for _, l := range layouts {
+		if until, err := time.Parse(l.layout, value); err == nil {
+			r.Until = &until
+			r.untilFloating = l.floating
+			r.untilDate = l.date
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: UNTIL=%s", ErrInvalidRule, value)
+}
+
+// until is the last moment an occurrence may start at, a floating UNTIL is
+// read in the given location.
+func (r Rule) until(location *time.Location) (time.Time, bool) {
+	if r.Until == nil {
+		return time.Time{}, false
+	}
+	if !r.untilFloating {
+		return *r.Until, true
+	}
+
+	u := *r.Until
+	if r.untilDate {
+		return time.Date(u.Year(), u.Month(), u.Day()+1, 0, 0, 0, 0, location).Add(-time.Nanosecond), true
+	}
+	return time.Date(u.Year(), u.Month(), u.Day(), u.Hour(), u.Minute(), u.Second(), 0, location), true
+}
+
+// Resolve reads a floating UNTIL in the location. iCalendar wants UNTIL in
+// UTC for events in a time zone and as a date for all day events.
+func (r Rule) Resolve(location *time.Location, allDay bool) Rule {
+	until, ok := r.until(location)
+	if !ok {
+		return r
+	}
+
+	if allDay {
+		local := until.In(location)
+		date := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+		r.Until, r.untilFloating, r.untilDate = &date, true, true
+		return r
+	}
+
+	until = until.UTC()
+	r.Until, r.untilFloating, r.untilDate = &until, false, false
+	return r
+}
+
+// String formats the rule as the value of an RRULE.
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count != 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		switch {
+		case r.untilDate:
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+		case r.untilFloating:
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102T150405"))
+		default:
+			parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+		}
+	}
+	if len(r.ByDay) != 0 {
+		days := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			days[i] = day.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) != 0 {
+		parts = append(parts, "BYMONTHDAY="+joinNumbers(r.ByMonthDay))
+	}
+	if len(r.ByMonth) != 0 {
+		parts = append(parts, "BYMONTH="+joinNumbers(r.ByMonth))
+	}
+	if len(r.BySetPos) != 0 {
+		parts = append(parts, "BYSETPOS="+joinNumbers(r.BySetPos))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+weekdayNames[r.WeekStart])
+	}
+	return strings.Join(parts, ";")
+}
+
+func parseNumber(value string, min int, max int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%w: %s is out of range", ErrInvalidRule, value)
+	}
+	return n, nil
+}
+
+// parseNumbers parses a list of numbers between -max and max, zero excluded.
+func parseNumbers(value string, max int) ([]int, error) {
+	numbers := []int{}
+	for _, item := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(item)
+		if err != nil || n == 0 || n < -max || n > max {
+			return nil, fmt.Errorf("%w: %s is out of range", ErrInvalidRule, item)
+		}
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+func parseWeekdays(value string) ([]WeekdayNum, error) {
+	days := []WeekdayNum{}
+	for _, item := range strings.Split(value, ",") {
+		if len(item) < 2 {
+			return nil, fmt.Errorf("%w: BYDAY=%s", ErrInvalidRule, value)
+		}
+
+		day, ok := weekdays[item[len(item)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("%w: BYDAY=%s", ErrInvalidRule, value)
+		}
+
+		n := 0
+		if prefix := item[:len(item)-2]; prefix != "" {
+			var err error
+			if n, err = strconv.Atoi(prefix); err != nil || n == 0 || n < -53 || n > 53 {
+				return nil, fmt.Errorf("%w: BYDAY=%s", ErrInvalidRule, value)
+			}
+		}
+		days = append(days, WeekdayNum{N: n, Day: day})
+	}
+	return days, nil
+}
+
+func joinNumbers(numbers []int) string {
+	items := make([]string, len(numbers))
+	for i, n := range numbers {
+		items[i] = strconv.Itoa(n)
+	}
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,311 @@
+package recurrence
+
+import (
+	"sort"
+	"time"
+)
+
+// maxPeriods bounds the days, weeks, months or years a rule is expanded over,
+// so a rule that never matches does not loop forever.
+const maxPeriods = 100000
+
+// Set is a recurring series: its first occurrence, the rule repeating it and
+// the single dates added to (RDATE) and removed from (EXDATE) it. Start is in
+// the location of the series, occurrences keep its time of day across DST
+// changes.
+type Set struct {
+	Start   time.Time
+	Rule    *Rule
+	RDates  []time.Time
+	ExDates []time.Time
+}
+
+// Between returns up to limit occurrences starting at or after from and before
+// to, in order.
+func (s Set) Between(from time.Time, to time.Time, limit int) []time.Time {
+	occurrences := []time.Time{}
+	add := func(t time.Time) {
+		if t.Before(from) || !t.Before(to) || s.excluded(t) {
+			return
+		}
+		for _, o := range occurrences {
+			if o.Equal(t) {
+				return
+			}
+		}
+		occurrences = append(occurrences, t.In(s.Start.Location()))
+	}
+
+	if s.Rule == nil {
+		add(s.Start)
+	} else {
+		s.Rule.each(s.Start, to, func(t time.Time) bool {
+			if !t.Before(to) {
+				return false
+			}
+			add(t)
+			return len(occurrences) < limit
+		})
+	}
+
+	for _, rdate := range s.RDates {
+		add(rdate)
+	}
+
+	sort.Slice(occurrences, func(i, j int) bool {
+		return occurrences[i].Before(occurrences[j])
+	})
+	if len(occurrences) > limit {
+		occurrences = occurrences[:limit]
+	}
+	return occurrences
+}
+
+// Contains reports whether an occurrence of the set starts at t.
+func (s Set) Contains(t time.Time) bool {
+	return len(s.Between(t, t.Add(time.Nanosecond), 1)) == 1
+}
+
+func (s Set) excluded(t time.Time) bool {
+	for _, exdate := range s.ExDates {
+		if exdate.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// each calls fn with the occurrences of the rule in order, starting with start
+// itself, until fn returns false, the rule ends or its periods begin after
+// before.
+func (r Rule) each(start time.Time, before time.Time, fn func(time.Time) bool) {
+	location := start.Location()
+	hour, minute, second := start.Clock()
+	until, bounded := r.until(location)
+
+	count := 0
+	emit := func(t time.Time) bool {
+		if bounded && t.After(until) {
+			return false
+		}
+		count++
+		if !fn(t) {
+			return false
+		}
+		return r.Count == 0 || count < r.Count
+	}
+
+	if !emit(start) {
+		return
+	}
+
+	first := civil(start)
+	for period := 0; period < maxPeriods; period++ {
+		periodStart, dates := r.period(first, start, period)
+		if time.Date(periodStart.Year(), periodStart.Month(), periodStart.Day(), 0, 0, 0, 0, location).After(before) {
+			return
+		}
+
+		for _, date := range r.setPositions(dates) {
+			t := wallClock(date, hour, minute, second, start.Nanosecond(), location)
+			if !t.After(start) {
+				continue
+			}
+			if !emit(t) {
+				return
+			}
+		}
+	}
+}
+
+// wallClock is the time of day on the date in the location. A time skipped
+// by a DST change is read with the offset before the change, so 02:30 on the
+// day clocks jump from 02:00 to 03:00 becomes 03:30 as RFC 5545 requires.
+func wallClock(date time.Time, hour int, minute int, second int, nanosecond int, location *time.Location) time.Time {
+	t := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, second, nanosecond, location)
+	wanted := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, second, nanosecond, time.UTC)
+	got := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return t.Add(wanted.Sub(got))
+}
+
+// civil is the date of t as midnight UTC, which days can be added to without
+// running into DST changes.
+func civil(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// period returns the first day of the nth period of the rule and the dates in
+// it matching the BY parts.
+func (r Rule) period(first time.Time, start time.Time, n int) (time.Time, []time.Time) {
+	step := n * r.Interval
+
+	switch r.Freq {
+	case Daily:
+		date := first.AddDate(0, 0, step)
+		if r.matchesMonth(date) && r.matchesMonthDay(date) && r.matchesWeekday(date) {
+			return date, []time.Time{date}
+		}
+		return date, nil
+
+	case Weekly:
+		offset := (int(first.Weekday()) - int(r.WeekStart) + 7) % 7
+		weekStart := first.AddDate(0, 0, 7*step-offset)
+		dates := []time.Time{}
+		for i := 0; i < 7; i++ {
+			date := weekStart.AddDate(0, 0, i)
+			if !r.matchesMonth(date) {
+				continue
+			}
+			if len(r.ByDay) == 0 && date.Weekday() != first.Weekday() {
+				continue
+			}
+			if r.matchesWeekday(date) {
+				dates = append(dates, date)
+			}
+		}
+		return weekStart, dates
+
+	case Monthly:
+		month := time.Date(first.Year(), first.Month()+time.Month(step), 1, 0, 0, 0, 0, time.UTC)
+		if !r.matchesMonth(month) {
+			return month, nil
+		}
+		return month, r.monthDays(month, start)
+
+	default:
+		year := time.Date(first.Year()+step, time.January, 1, 0, 0, 0, 0, time.UTC)
+		dates := []time.Time{}
+
+		switch {
+		case len(r.ByMonth) != 0:
+			for _, m := range r.ByMonth {
+				dates = append(dates, r.monthDays(time.Date(year.Year(), time.Month(m), 1, 0, 0, 0, 0, time.UTC), start)...)
+			}
+		case len(r.ByDay) != 0 && len(r.ByMonthDay) == 0:
+			days := year.AddDate(1, 0, 0).Sub(year).Hours() / 24
+			for date := year; date.Year() == year.Year(); date = date.AddDate(0, 0, 1) {
+				if r.matchesNumberedWeekday(date, date.YearDay(), int(days)) {
+					dates = append(dates, date)
+				}
+			}
+		case len(r.ByMonthDay) != 0:
+			for m := time.January; m <= time.December; m++ {
+				dates = append(dates, r.monthDays(time.Date(year.Year(), m, 1, 0, 0, 0, 0, time.UTC), start)...)
+			}
+		default:
+			date := time.Date(year.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+			if date.Month() == start.Month() {
+				dates = append(dates, date)
+			}
+		}
+		return year, dates
+	}
+}
+
+// monthDays returns the days of the month matching BYMONTHDAY and BYDAY, or
+// the day of the month of start when neither is given. Months without that
+// day are skipped.
+func (r Rule) monthDays(month time.Time, start time.Time) []time.Time {
+	last := month.AddDate(0, 1, -1).Day()
+	dates := []time.Time{}
+
+	for day := 1; day <= last; day++ {
+		date := month.AddDate(0, 0, day-1)
+
+		if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+			if day == start.Day() {
+				dates = append(dates, date)
+			}
+			continue
+		}
+
+		if len(r.ByMonthDay) != 0 && !r.matchesMonthDay(date) {
+			continue
+		}
+		if len(r.ByDay) != 0 && !r.matchesNumberedWeekday(date, day, last) {
+			continue
+		}
+		dates = append(dates, date)
+	}
+	return dates
+}
+
+func (r Rule) matchesMonth(date time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, m := range r.ByMonth {
+		if time.Month(m) == date.Month() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchesMonthDay(date time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	last := time.Date(date.Year(), date.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, d := range r.ByMonthDay {
+		if d == date.Day() || (d < 0 && last+1+d == date.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchesWeekday(date time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, day := range r.ByDay {
+		if day.Day == date.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesNumberedWeekday checks BYDAY for the index-th of days days of a month
+// or a year, so "2TU" matches the second and "-1FR" the last of them.
+func (r Rule) matchesNumberedWeekday(date time.Time, index int, days int) bool {
+	forward := (index-1)/7 + 1
+	backward := -((days-index)/7 + 1)
+	for _, day := range r.ByDay {
+		if day.Day != date.Weekday() {
+			continue
+		}
+		if day.N == 0 || day.N == forward || day.N == backward {
+			return true
+		}
+	}
+	return false
+}
+
+// setPositions keeps the BYSETPOS entries of the dates of a period.
+func (r Rule) setPositions(dates []time.Time) []time.Time {
+	if len(r.BySetPos) == 0 || len(dates) == 0 {
+		return dates
+	}
+
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	picked := map[int]bool{}
+	for _, pos := range r.BySetPos {
+		index := pos - 1
+		if pos < 0 {
+			index = len(dates) + pos
+		}
+		if index >= 0 && index < len(dates) {
+			picked[index] = true
+		}
+	}
+
+	kept := []time.Time{}
+	for i, date := range dates {
+		if picked[i] {
+			kept = append(kept, date)
+		}
+	}
+	return kept
+}
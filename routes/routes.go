@@ -1,10 +1,23 @@
 package routes
 
 import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"site/audit"
 	"site/database"
+	"site/database/models"
+	"site/export"
 	"site/http/handlers"
+	"site/http/handlers/admin"
 	"site/http/handlers/auth"
 	"site/http/middlewares"
+	"site/lockout"
+	"site/mailer"
+	"site/oidc"
+	"site/passwords"
+	"site/policy"
 	"site/security"
 	"site/uploader"
 
@@ -13,17 +26,113 @@ import (
 
 func NewRouteRegister(server *mux.Router) {
 	connection, _ := database.NewDatabaseConnection()
-	tokenService := security.NewTokenService()
+	tokenConfig, err := security.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid token configuration %s \n", err)
+	}
+
+	tokenService, err := security.NewTokenService(tokenConfig)
+	if err != nil {
+		log.Fatalf("Invalid token configuration %s \n", err)
+	}
+	tokenService.SetRevocationStore(security.NewGormRevocationStore(connection))
+	refreshTokenService := security.NewRefreshTokenService(connection)
+	apiKeyService := security.NewAPIKeyService(connection)
 	uploadService := uploader.NewLocalUploader()
+	mailService := mailer.NewSMTPMailerFromEnv()
+
+	exportDir := os.Getenv("EXPORT_DIR")
+	if exportDir == "" {
+		dir, err := os.Getwd()
+		if err != nil {
+			log.Fatalf("Can not determine the export directory %s \n", err)
+		}
+		exportDir = dir + "/exports/"
+	}
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if mediaDir == "" {
+		dir, err := os.Getwd()
+		if err != nil {
+			log.Fatalf("Can not determine the media directory %s \n", err)
+		}
+		mediaDir = dir + "/files/"
+	}
+	mediaRetention, err := handlers.MediaRetentionFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid media retention %s \n", err)
+	}
+
+	exportWorker := export.NewWorker(connection, uploadService, exportDir)
+	go exportWorker.Start(context.Background())
+	providers, err := oidc.ProvidersFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid OpenID Connect configuration %s \n", err)
+	}
+	passwordChecker, err := passwords.CheckerFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid password policy %s \n", err)
+	}
+	loginGuard := lockout.NewGuard(lockout.NewGormStore(connection), audit.NewGormLogger(connection))
+
+	authMiddleware := middlewares.AuthMiddleware(tokenService, apiKeyService, connection, middlewares.TokenExtractorsFromEnv()...)
+	downloadMiddleware := middlewares.AuthMiddleware(tokenService, apiKeyService, connection, middlewares.DownloadTokenExtractorsFromEnv()...)
+
+	server.Handle("/.well-known/jwks.json", auth.JWKS(tokenService))
+	server.Handle("/register", auth.Register(connection, tokenService, mailService, passwordChecker))
+	server.Handle("/verify-email", auth.VerifyEmail(connection, tokenService, mailService))
+	server.Handle("/verify-email/resend", auth.ResendVerification(connection, tokenService, mailService))
+	server.Handle("/password/forgot", auth.ForgotPassword(connection, mailService))
+	server.Handle("/password/reset", auth.ResetPassword(connection, tokenService, refreshTokenService, passwordChecker))
+	server.Handle("/login", auth.Login(connection, tokenService, refreshTokenService, loginGuard))
+	server.Handle("/login/mfa", auth.LoginMFA(connection, tokenService, refreshTokenService, loginGuard))
+	server.Handle("/oauth/{provider}/login", auth.OIDCLogin(connection, providers))
+	server.Handle("/oauth/{provider}/callback", auth.OIDCCallback(connection, providers, tokenService, refreshTokenService))
+	server.Handle("/oauth/{provider}/link", authMiddleware(auth.OIDCLink(connection, providers)))
+	server.Handle("/me", authMiddleware(auth.Me(connection, tokenService, refreshTokenService, mailService, uploadService, passwordChecker)))
+	server.Handle("/me/export", authMiddleware(handlers.RequestExport(connection, exportWorker)))
+	server.Handle("/me/export/{export}", authMiddleware(handlers.GetExport(connection, tokenService)))
+	server.Handle("/me/export/{export}/download", handlers.DownloadExport(connection, tokenService))
+	server.Handle("/me/calendar-feed", authMiddleware(handlers.ManageCalendarFeed(connection)))
+	server.Handle("/calendar/{token}.ics", handlers.CalendarFeed(connection))
+	server.Handle("/public/events/{slug}", handlers.PublicEvent(connection))
+	server.Handle("/public/events/{slug}/media/{media:[0-9]+}", handlers.PublicMedia(connection, uploadService))
+	server.Handle("/invitations/accept", handlers.AnswerInvitation(connection, tokenService, mailService, models.InvitationAccepted))
+	server.Handle("/invitations/decline", handlers.AnswerInvitation(connection, tokenService, mailService, models.InvitationDeclined))
+	server.Handle("/mfa/totp/enroll", authMiddleware(auth.EnrollTOTP(connection)))
+	server.Handle("/mfa/totp/confirm", authMiddleware(auth.ConfirmTOTP(connection)))
+	server.Handle("/api-keys", authMiddleware(auth.APIKeys(connection, apiKeyService)))
+	server.Handle("/api-keys/{key}", authMiddleware(auth.APIKey(connection)))
+	server.Handle("/token/refresh", auth.Refresh(connection, tokenService, refreshTokenService))
+	server.Handle("/logout", authMiddleware(auth.Logout(tokenService, refreshTokenService)))
+	server.Handle("/logout/all", authMiddleware(auth.LogoutAll(tokenService, refreshTokenService)))
 
-	authMiddleware := middlewares.AuthMiddleware(tokenService)
+	authorized := func(p policy.Permission) middlewares.Middleware {
+		return middlewares.Chain(authMiddleware, policy.RequirePermission(p))
+	}
+	authorizedDownload := func(p policy.Permission) middlewares.Middleware {
+		return middlewares.Chain(downloadMiddleware, policy.RequirePermission(p))
+	}
 
-	server.Handle("/register", auth.Register(connection))
-	server.Handle("/login", auth.Login(connection, tokenService))
+	server.Handle("/admin/users/{user}/unlock", authorized(policy.ManageUsers)(admin.UnlockUser(connection, loginGuard)))
 
-	server.Handle("/event", authMiddleware(handlers.EventCreate(connection, tokenService)))
-	server.Handle("/event/{event}", authMiddleware(handlers.GetEvent(connection)))
-	server.Handle("/events", authMiddleware(handlers.GetEvents(connection, tokenService)))
+	server.Handle("/event", authorized(policy.CreateEvents)(handlers.EventCreate(connection)))
+	server.Handle("/event/{event:[0-9]+}.ics", authorizedDownload(policy.ReadEvents)(handlers.EventCalendar(connection)))
+	server.Handle("/event/{event}", authorized(policy.ReadEvents)(handlers.GetEvent(connection))).Methods(http.MethodGet)
+	server.Handle("/event/{event}", authorized(policy.CreateEvents)(handlers.UpdateEvent(connection, mailService))).Methods(http.MethodPut, http.MethodPatch)
+	server.Handle("/event/{event}", authorized(policy.CreateEvents)(handlers.DeleteEvent(connection, uploadService, mediaRetention))).Methods(http.MethodDelete)
+	server.Handle("/event/{event}/restore", authorized(policy.CreateEvents)(handlers.RestoreEvent(connection)))
+	server.Handle("/event/{event}/occurrences", authorized(policy.ReadEvents)(handlers.GetOccurrences(connection)))
+	server.Handle("/event/{event}/occurrences/{occurrence}", authorized(policy.CreateEvents)(handlers.Occurrence(connection)))
+	server.Handle("/event/{event}/rsvp", authorized(policy.ReadEvents)(handlers.RSVP(connection, mailService)))
+	server.Handle("/event/{event}/collaborators", authorized(policy.ReadEvents)(handlers.Collaborators(connection))).Methods(http.MethodGet)
+	server.Handle("/event/{event}/collaborators", authorized(policy.CreateEvents)(handlers.Collaborators(connection))).Methods(http.MethodPost)
+	server.Handle("/event/{event}/collaborators/{user}", authorized(policy.CreateEvents)(handlers.Collaborator(connection))).Methods(http.MethodPut)
+	server.Handle("/event/{event}/collaborators/{user}", authorized(policy.ReadEvents)(handlers.Collaborator(connection))).Methods(http.MethodDelete)
+	server.Handle("/event/{event}/invitations", authorized(policy.CreateEvents)(handlers.Invitations(connection, tokenService, mailService)))
+	server.Handle("/event/{event}/attendees", authorized(policy.CreateEvents)(handlers.GetAttendees(connection)))
+	server.Handle("/event/{event}/attendees.csv", authorizedDownload(policy.CreateEvents)(handlers.ExportAttendees(connection)))
+	server.Handle("/events/import", authorized(policy.CreateEvents)(handlers.ImportEvents(connection)))
+	server.Handle("/events", authorized(policy.ReadEvents)(handlers.GetEvents(connection)))
 
-	server.Handle("/event/{event}/upload", authMiddleware(handlers.CreateMedia(connection, tokenService, uploadService)))
+	server.Handle("/event/{event}/upload", authorized(policy.UploadMedia)(handlers.CreateMedia(connection, uploadService, mediaDir)))
 }
@@ -0,0 +1,58 @@
+package security
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Config configures the TokenService.
+type Config struct {
+	SigningKeyID   string        `json:"signing_key"`
+	Keys           []KeyConfig   `json:"keys"`
+	AccessTokenTTL time.Duration `json:"-"`
+}
+
+// ConfigFromEnv builds the token configuration from the environment.
+//
+// JWT_KEYS_FILE points to a JSON document with a "signing_key" id and a list
+// of "keys". Rotating keys is done by adding a new key, pointing
+// "signing_key" to it and removing the old key once the tokens it signed have
+// expired. When no file is given, JWT_SECRET configures a single HS256 key.
+// JWT_ACCESS_TOKEN_TTL optionally overrides the access token lifetime, e.g.
+// "10m".
+func ConfigFromEnv() (Config, error) {
+	config := Config{}
+
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return config, err
+		}
+
+		if err := json.Unmarshal(content, &config); err != nil {
+			return config, err
+		}
+	} else if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		config.SigningKeyID = "default"
+		config.Keys = []KeyConfig{{
+			ID:        "default",
+			Algorithm: AlgorithmHS256,
+			Secret:    secret,
+		}}
+	} else {
+		return config, errors.New("either JWT_KEYS_FILE or JWT_SECRET has to be set")
+	}
+
+	if ttl := os.Getenv("JWT_ACCESS_TOKEN_TTL"); ttl != "" {
+		duration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return config, err
+		}
+		config.AccessTokenTTL = duration
+	}
+
+	return config, nil
+}
@@ -0,0 +1,253 @@
+package security
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// minimumSecretLength is the shortest HMAC secret accepted, matching the
+// output size of SHA-256.
+const minimumSecretLength = 32
+
+// KeyConfig describes one signing key. HMAC keys are configured through
+// Secret, asymmetric keys through PEM encoded private keys. A key that only has
+// a public key can verify tokens but never sign them, which is how a retired
+// key is kept around until the tokens it signed have expired.
+type KeyConfig struct {
+	ID             string `json:"id"`
+	Algorithm      string `json:"algorithm"`
+	Secret         string `json:"secret"`
+	PrivateKeyFile string `json:"private_key_file"`
+	PrivateKeyPEM  string `json:"private_key"`
+	PublicKeyFile  string `json:"public_key_file"`
+	PublicKeyPEM   string `json:"public_key"`
+}
+
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// KeySet holds every key tokens may be verified with and the single key new
+// tokens are signed with. Keys are selected by the "kid" token header.
+type KeySet struct {
+	signing *signingKey
+	keys    map[string]*signingKey
+	order   []string
+}
+
+func NewKeySet(signingKeyID string, configs []KeyConfig) (*KeySet, error) {
+	set := &KeySet{keys: map[string]*signingKey{}}
+
+	for _, config := range configs {
+		if config.ID == "" {
+			return nil, errors.New("every signing key needs an id")
+		}
+
+		if _, ok := set.keys[config.ID]; ok {
+			return nil, fmt.Errorf("duplicate signing key id %q", config.ID)
+		}
+
+		key, err := loadKey(config)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", config.ID, err)
+		}
+
+		set.keys[config.ID] = key
+		set.order = append(set.order, config.ID)
+	}
+
+	signing, ok := set.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", signingKeyID)
+	}
+
+	if signing.signKey == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", signingKeyID)
+	}
+
+	set.signing = signing
+
+	return set, nil
+}
+
+func (k *KeySet) sign(claims jwt.MapClaims) (string, error) {
+	if k == nil || k.signing == nil {
+		return "", errors.New("no signing key configured")
+	}
+
+	token := jwt.NewWithClaims(k.signing.method, claims)
+	token.Header["kid"] = k.signing.id
+
+	return token.SignedString(k.signing.signKey)
+}
+
+// keyFunc resolves the verification key of a token. The algorithm of the token
+// must match the algorithm the key was configured with, otherwise a public key
+// could be abused as an HMAC secret.
+func (k *KeySet) keyFunc(t *jwt.Token) (interface{}, error) {
+	if k == nil {
+		return nil, errors.New("no signing key configured")
+	}
+
+	kid, _ := t.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	if t.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method")
+	}
+
+	return key.verifyKey, nil
+}
+
+// JSONWebKey is the public part of a signing key as described by RFC 7517.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys of the set. HMAC keys are secrets and are never
+// published.
+func (k *KeySet) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	if k == nil {
+		return set
+	}
+
+	for _, id := range k.order {
+		key := k.keys[id]
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JSONWebKey{
+				KeyType:   "RSA",
+				KeyID:     id,
+				Use:       "sig",
+				Algorithm: AlgorithmRS256,
+				N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JSONWebKey{
+				KeyType:   "OKP",
+				KeyID:     id,
+				Use:       "sig",
+				Algorithm: AlgorithmEdDSA,
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	return set
+}
+
+func loadKey(config KeyConfig) (*signingKey, error) {
+	switch config.Algorithm {
+	case AlgorithmHS256:
+		if len(config.Secret) < minimumSecretLength {
+			return nil, fmt.Errorf("HMAC secrets must be at least %d bytes long", minimumSecretLength)
+		}
+		secret := []byte(config.Secret)
+		return &signingKey{id: config.ID, method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}, nil
+
+	case AlgorithmRS256:
+		key := &signingKey{id: config.ID, method: jwt.SigningMethodRS256}
+		private, err := readPEM(config.PrivateKeyPEM, config.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if private != nil {
+			privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(private)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = privateKey
+			key.verifyKey = &privateKey.PublicKey
+			return key, nil
+		}
+
+		public, err := readPEM(config.PublicKeyPEM, config.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if public == nil {
+			return nil, errors.New("either a private or a public key is required")
+		}
+		key.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(public)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+
+	case AlgorithmEdDSA:
+		key := &signingKey{id: config.ID, method: jwt.SigningMethodEdDSA}
+		private, err := readPEM(config.PrivateKeyPEM, config.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if private != nil {
+			privateKey, err := jwt.ParseEdPrivateKeyFromPEM(private)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = privateKey
+			key.verifyKey = privateKey.(ed25519.PrivateKey).Public()
+			return key, nil
+		}
+
+		public, err := readPEM(config.PublicKeyPEM, config.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if public == nil {
+			return nil, errors.New("either a private or a public key is required")
+		}
+		key.verifyKey, err = jwt.ParseEdPublicKeyFromPEM(public)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unsupported algorithm %q", config.Algorithm)
+}
+
+func readPEM(inline string, file string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+
+	if file == "" {
+		return nil, nil
+	}
+
+	return ioutil.ReadFile(file)
+}
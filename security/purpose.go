@@ -0,0 +1,65 @@
+package security
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Purposes of the tokens signed by CreatePurposeToken.
+const (
+	PurposeEmailVerification = "email-verification"
+	PurposeMFA               = "mfa-pending"
+	PurposeExportDownload    = "export-download"
+	PurposeInvitation        = "event-invitation"
+)
+
+// PurposeTokens signs short lived tokens that are only good for one purpose,
+// e.g. a link in an email. They are never accepted as access tokens.
+type PurposeTokens interface {
+	CreatePurposeToken(purpose string, subject string, ttl time.Duration) (PurposeClaims, string, error)
+	ParsePurposeToken(token string, purpose string) (PurposeClaims, error)
+}
+
+type PurposeClaims struct {
+	ID        string
+	Subject   string
+	ExpiresAt time.Time
+}
+
+func (t TokenService) CreatePurposeToken(purpose string, subject string, ttl time.Duration) (PurposeClaims, string, error) {
+	jti, err := NewRandomToken(16)
+	if err != nil {
+		return PurposeClaims{}, "", err
+	}
+
+	now := time.Now()
+	claims := PurposeClaims{ID: jti, Subject: subject, ExpiresAt: now.Add(ttl)}
+	token, err := t.keys.sign(jwt.MapClaims{
+		"sub": subject,
+		"typ": purpose,
+		"jti": jti,
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": claims.ExpiresAt.Unix(),
+	})
+
+	return claims, token, err
+}
+
+func (t TokenService) ParsePurposeToken(token string, purpose string) (PurposeClaims, error) {
+	claims, err := t.verify(token, purpose)
+	if err != nil {
+		return PurposeClaims{}, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+
+	return PurposeClaims{
+		ID:        jti,
+		Subject:   subject,
+		ExpiresAt: time.Unix(int64(exp), 0),
+	}, nil
+}
@@ -0,0 +1,161 @@
+package security
+
+import (
+	"errors"
+	"site/database/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefreshTokenTTL is the lifetime of a refresh token. Every rotation issues a
+// new token with a fresh lifetime.
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+)
+
+type RefreshTokens interface {
+	Issue(u *models.User) (string, error)
+	Rotate(token string) (string, uint, error)
+	Revoke(token string) error
+	RevokeUser(userID uint) error
+}
+
+func NewRefreshTokenService(connection *gorm.DB) *RefreshTokenService {
+	return &RefreshTokenService{
+		connection: connection,
+		ttl:        RefreshTokenTTL,
+	}
+}
+
+// RefreshTokenService persists refresh tokens as SHA-256 digests. Tokens
+// issued by rotating each other share a family, so presenting an already
+// rotated token revokes the whole chain.
+type RefreshTokenService struct {
+	connection *gorm.DB
+	ttl        time.Duration
+}
+
+func (s *RefreshTokenService) Issue(u *models.User) (string, error) {
+	family, err := NewRandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	token, _, err := s.create(s.connection, u.ID, family)
+	return token, err
+}
+
+// Rotate exchanges a refresh token for a new one. It returns the new token and
+// the identifier of the user it belongs to.
+func (s *RefreshTokenService) Rotate(token string) (string, uint, error) {
+	stored := models.RefreshToken{}
+	result := s.connection.Where("token_hash = ?", HashToken(token)).Limit(1).Find(&stored)
+	if result.Error != nil {
+		return "", 0, result.Error
+	}
+
+	if stored.ID == 0 {
+		return "", 0, ErrInvalidRefreshToken
+	}
+
+	if stored.RevokedAt != nil {
+		if err := s.revokeFamily(stored.FamilyID); err != nil {
+			return "", 0, err
+		}
+		return "", 0, ErrRefreshTokenReused
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return "", 0, ErrInvalidRefreshToken
+	}
+
+	var newToken string
+	err := s.connection.Transaction(func(tx *gorm.DB) error {
+		// the revoked_at condition makes sure only one of two concurrent
+		// rotations of the same token wins
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", stored.ID).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrRefreshTokenReused
+		}
+
+		var replacement *models.RefreshToken
+		var err error
+		newToken, replacement, err = s.create(tx, stored.UserID, stored.FamilyID)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&models.RefreshToken{}).
+			Where("id = ?", stored.ID).
+			Update("replaced_by_id", replacement.ID).Error
+	})
+
+	if errors.Is(err, ErrRefreshTokenReused) {
+		if err := s.revokeFamily(stored.FamilyID); err != nil {
+			return "", 0, err
+		}
+		return "", 0, ErrRefreshTokenReused
+	}
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	return newToken, stored.UserID, nil
+}
+
+// Revoke invalidates the refresh token and every token rotated from it.
+func (s *RefreshTokenService) Revoke(token string) error {
+	stored := models.RefreshToken{}
+	result := s.connection.Where("token_hash = ?", HashToken(token)).Limit(1).Find(&stored)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if stored.ID == 0 {
+		return ErrInvalidRefreshToken
+	}
+
+	return s.revokeFamily(stored.FamilyID)
+}
+
+func (s *RefreshTokenService) RevokeUser(userID uint) error {
+	return s.connection.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (s *RefreshTokenService) create(connection *gorm.DB, userID uint, family string) (string, *models.RefreshToken, error) {
+	token, err := NewRandomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	stored := models.RefreshToken{
+		UserID:    userID,
+		TokenHash: HashToken(token),
+		FamilyID:  family,
+		ExpiresAt: time.Now().Add(s.ttl),
+	}
+
+	if result := connection.Create(&stored); result.Error != nil {
+		return "", nil, result.Error
+	}
+
+	return token, &stored, nil
+}
+
+func (s *RefreshTokenService) revokeFamily(family string) error {
+	return s.connection.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", family).
+		Update("revoked_at", time.Now()).Error
+}
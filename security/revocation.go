@@ -0,0 +1,131 @@
+package security
+
+import (
+	"site/database/models"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// generationCacheTTL bounds how long a token generation read from the
+// database is trusted, so "log out everywhere" issued through another
+// instance of the application takes effect quickly.
+const generationCacheTTL = 30 * time.Second
+
+// RevocationStore keeps track of access tokens that must be rejected before
+// they expire.
+type RevocationStore interface {
+	Revoke(jti string, userID uint, expiresAt time.Time) error
+	IsRevoked(jti string) (bool, error)
+	Generation(userID uint) (uint, error)
+	BumpGeneration(userID uint) error
+}
+
+func NewGormRevocationStore(connection *gorm.DB) *GormRevocationStore {
+	return &GormRevocationStore{
+		connection:  connection,
+		revoked:     map[string]time.Time{},
+		generations: map[uint]cachedGeneration{},
+	}
+}
+
+// GormRevocationStore persists revoked token identifiers through gorm and
+// keeps the revocations it has seen in memory.
+type GormRevocationStore struct {
+	connection  *gorm.DB
+	mu          sync.RWMutex
+	revoked     map[string]time.Time
+	generations map[uint]cachedGeneration
+}
+
+type cachedGeneration struct {
+	value    uint
+	loadedAt time.Time
+}
+
+func (s *GormRevocationStore) Revoke(jti string, userID uint, expiresAt time.Time) error {
+	result := s.connection.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RevokedToken{
+		JTI:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	s.revoked[jti] = expiresAt
+	for cachedJti, cachedExpiresAt := range s.revoked {
+		if cachedExpiresAt.Before(now) {
+			delete(s.revoked, cachedJti)
+		}
+	}
+	s.mu.Unlock()
+
+	// expired tokens are rejected by their exp claim already
+	return s.connection.Where("expires_at < ?", now).Delete(&models.RevokedToken{}).Error
+}
+
+func (s *GormRevocationStore) IsRevoked(jti string) (bool, error) {
+	s.mu.RLock()
+	_, ok := s.revoked[jti]
+	s.mu.RUnlock()
+	if ok {
+		return true, nil
+	}
+
+	revoked := models.RevokedToken{}
+	result := s.connection.Where("jti = ?", jti).Limit(1).Find(&revoked)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	if revoked.ID == 0 {
+		return false, nil
+	}
+
+	s.mu.Lock()
+	s.revoked[jti] = revoked.ExpiresAt
+	s.mu.Unlock()
+
+	return true, nil
+}
+
+func (s *GormRevocationStore) Generation(userID uint) (uint, error) {
+	s.mu.RLock()
+	cached, ok := s.generations[userID]
+	s.mu.RUnlock()
+	if ok && time.Since(cached.loadedAt) < generationCacheTTL {
+		return cached.value, nil
+	}
+
+	user := models.User{}
+	result := s.connection.Unscoped().Select("id", "token_generation").Find(&user, userID)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	s.mu.Lock()
+	s.generations[userID] = cachedGeneration{value: user.TokenGeneration, loadedAt: time.Now()}
+	s.mu.Unlock()
+
+	return user.TokenGeneration, nil
+}
+
+func (s *GormRevocationStore) BumpGeneration(userID uint) error {
+	result := s.connection.Model(&models.User{}).
+		Where("id = ?", userID).
+		Update("token_generation", gorm.Expr("token_generation + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+
+	s.mu.Lock()
+	delete(s.generations, userID)
+	s.mu.Unlock()
+
+	return nil
+}
@@ -2,82 +2,212 @@ package security
 
 import (
 	"errors"
-	"fmt"
 	"site/database/models"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
-var hmacSampleSecret []byte
+// AccessTokenTTL is the lifetime of the tokens issued by CreateToken.
+const AccessTokenTTL = 15 * time.Minute
+
+const accessTokenType = "access"
+
+var ErrInvalidToken = errors.New("invalid Token")
+
+// KeyPublisher exposes the public keys tokens are verified with.
+type KeyPublisher interface {
+	JWKS() JSONWebKeySet
+}
 
 type TokenSecurity interface {
+	PurposeTokens
 	CreateToken(u *models.User) (string, error)
 	IsValid(token string) bool
 	GetIdentifier(token string) (uint, error)
+	TTL() time.Duration
+	Revoke(token string) error
+	RevokeAll(userID uint) error
 }
 
-func NewTokenService() *TokenService {
-	return &TokenService{}
+func NewTokenService(config Config) (*TokenService, error) {
+	keys, err := NewKeySet(config.SigningKeyID, config.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := config.AccessTokenTTL
+	if ttl == 0 {
+		ttl = AccessTokenTTL
+	}
+
+	return &TokenService{
+		ttl:  ttl,
+		keys: keys,
+	}, nil
 }
 
 type TokenService struct {
+	ttl         time.Duration
+	keys        *KeySet
+	revocations RevocationStore
+}
+
+// SetRevocationStore makes the service reject revoked tokens. Without a store
+// every correctly signed, unexpired token is accepted.
+func (t *TokenService) SetRevocationStore(store RevocationStore) {
+	t.revocations = store
+}
+
+// TTL returns how long an access token stays valid after it is issued.
+func (t TokenService) TTL() time.Duration {
+	if t.ttl == 0 {
+		return AccessTokenTTL
+	}
+	return t.ttl
 }
 
 func (t TokenService) CreateToken(u *models.User) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id": u.ID,
+	jti, err := NewRandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return t.keys.sign(jwt.MapClaims{
+		"id":  u.ID,
+		"gen": u.TokenGeneration,
+		"typ": accessTokenType,
+		"jti": jti,
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(t.TTL()).Unix(),
 	})
+}
 
-	return token.SignedString(hmacSampleSecret)
+// JWKS returns the public keys other services can verify our tokens with.
+func (t TokenService) JWKS() JSONWebKeySet {
+	return t.keys.JWKS()
 }
 
 func (t TokenService) IsValid(token string) bool {
-	jwtT, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		// Don't forget to validate the alg is what you expect:
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method")
-		}
+	_, err := t.parse(token)
+	return err == nil
+}
 
-		// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-		return hmacSampleSecret, nil
-	})
+func (t TokenService) GetIdentifier(token string) (uint, error) {
+	claims, err := t.parse(token)
+	if err != nil {
+		return 0, err
+	}
 
+	return identifier(claims)
+}
+
+// Revoke rejects the given token from now on until it expires.
+func (t TokenService) Revoke(token string) error {
+	if t.revocations == nil {
+		return errors.New("token revocation is not configured")
+	}
+
+	claims, err := t.parse(token)
 	if err != nil {
-		return false
+		return err
 	}
 
-	// ok && jwtT.Valid
-	_, ok := jwtT.Claims.(jwt.MapClaims)
-	if !ok || !jwtT.Valid {
-		return false
+	id, err := identifier(claims)
+	if err != nil {
+		return err
 	}
 
-	return true
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
 
+	return t.revocations.Revoke(jti, id, time.Unix(int64(exp), 0))
 }
 
-func (t TokenService) GetIdentifier(token string) (uint, error) {
-	jwtT, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		// Don't forget to validate the alg is what you expect:
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method")
+// RevokeAll rejects every token issued to the user so far.
+func (t TokenService) RevokeAll(userID uint) error {
+	if t.revocations == nil {
+		return errors.New("token revocation is not configured")
+	}
+
+	return t.revocations.BumpGeneration(userID)
+}
+
+func identifier(claims jwt.MapClaims) (uint, error) {
+	id, ok := claims["id"].(float64)
+	if !ok {
+		return 0, ErrInvalidToken
+	}
+
+	return uint(id), nil
+}
+
+// parse verifies an access token and returns its claims.
+func (t TokenService) parse(token string) (jwt.MapClaims, error) {
+	claims, err := t.verify(token, accessTokenType)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.revocations != nil {
+		if err := t.checkRevocation(claims); err != nil {
+			return nil, err
 		}
+	}
 
-		// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-		return hmacSampleSecret, nil
-	})
+	return claims, nil
+}
+
+// verify checks the signature and the registered time claims of a token and
+// makes sure it has been issued for the expected type.
+func (t TokenService) verify(token string, tokenType string) (jwt.MapClaims, error) {
+	jwtT, err := jwt.Parse(token, t.keys.keyFunc)
 
 	if err != nil {
-		return 0, errors.New("invalid Token")
+		return nil, ErrInvalidToken
 	}
 
-	// ok && jwtT.Valid
 	claims, ok := jwtT.Claims.(jwt.MapClaims)
 	if !ok || !jwtT.Valid {
-		return 0, errors.New("invalid Token")
+		return nil, ErrInvalidToken
 	}
 
-	id := uint(claims["id"].(float64))
+	// tokens without an expiration were issued before tokens expired and
+	// must not be accepted anymore
+	if _, ok := claims["exp"]; !ok || claims["typ"] != tokenType {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+func (t TokenService) checkRevocation(claims jwt.MapClaims) error {
+	jti, ok := claims["jti"].(string)
+	if !ok {
+		return ErrInvalidToken
+	}
+
+	revoked, err := t.revocations.IsRevoked(jti)
+	if err != nil || revoked {
+		return ErrInvalidToken
+	}
+
+	id, err := identifier(claims)
+	if err != nil {
+		return err
+	}
+
+	generation, err := t.revocations.Generation(id)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	tokenGeneration, _ := claims["gen"].(float64)
+	if uint(tokenGeneration) != generation {
+		return ErrInvalidToken
+	}
 
-	return id, nil
+	return nil
 }
@@ -0,0 +1,141 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers"
+	"site/http/handlers/auth"
+	"site/http/middlewares"
+	"site/policy"
+	"site/security"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAPIKeys(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	keys := security.NewAPIKeyService(connection)
+
+	user := models.User{Email: "api-keys@example.com", Password: "123456789", Role: models.RoleOrganizer}
+	connection.Save(&user)
+
+	create := func(request auth.APIKeyRequest) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		auth.APIKeys(connection, keys).ServeHTTP(rw, authenticate(jsonRequest(t, http.MethodPost, "/api-keys", request), user, ""))
+		return rw
+	}
+
+	rw := create(auth.APIKeyRequest{Label: "CI", Scopes: []string{string(policy.ReadEvents)}})
+	if rw.Code != http.StatusCreated {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusCreated)
+	}
+	created := auth.CreatedAPIKeyResponse{}
+	json.NewDecoder(rw.Body).Decode(&created)
+
+	stored := models.APIKey{}
+	connection.First(&stored, created.ID)
+	if stored.KeyHash == created.Key || created.Key == "" {
+		t.Error("The key is stored in plain text")
+	}
+
+	ok := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		current, _ := middlewares.CurrentUser(r)
+		if current.ID != user.ID {
+			t.Errorf("Unexpected principal %d", current.ID)
+		}
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	call := func(permission policy.Permission, key string) int {
+		r, _ := http.NewRequest(http.MethodGet, "/events", nil)
+		r.Header.Set(middlewares.APIKeyHeader, key)
+		rw := httptest.NewRecorder()
+		middlewares.Chain(
+			middlewares.AuthMiddleware(newTokenService(t), keys, connection),
+			policy.RequirePermission(permission),
+		)(ok).ServeHTTP(rw, r)
+		return rw.Code
+	}
+
+	t.Run("keys_resolve_to_their_user_within_their_scopes", func(t *testing.T) {
+		if code := call(policy.ReadEvents, created.Key); code != http.StatusOK {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", code, http.StatusOK)
+		}
+
+		if code := call(policy.CreateEvents, created.Key); code != http.StatusForbidden {
+			t.Errorf("A key can be used outside its scopes. Received %d", code)
+		}
+
+		connection.First(&stored, created.ID)
+		if stored.LastUsedAt == nil {
+			t.Error("The last use of the key has not been recorded")
+		}
+	})
+
+	t.Run("keys_can_not_exceed_the_permissions_of_the_user", func(t *testing.T) {
+		if rw := create(auth.APIKeyRequest{Label: "Admin", Scopes: []string{string(policy.ManageUsers)}}); rw.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("keys_can_not_manage_keys", func(t *testing.T) {
+		r := jsonRequest(t, http.MethodPost, "/api-keys", auth.APIKeyRequest{Label: "Nested", Scopes: []string{string(policy.ReadEvents)}})
+		r = r.WithContext(middlewares.WithPrincipal(r.Context(), &middlewares.Principal{User: user, APIKey: &stored}))
+		rw := httptest.NewRecorder()
+		auth.APIKeys(connection, keys).ServeHTTP(rw, r)
+
+		if rw.Code != http.StatusForbidden {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("keys_can_not_manage_the_account", func(t *testing.T) {
+		tokenService := newTokenService(t)
+		refreshTokens := security.NewRefreshTokenService(connection)
+
+		for _, c := range []struct {
+			name    string
+			method  string
+			target  string
+			handler http.Handler
+		}{
+			{"enroll_totp", http.MethodPost, "/mfa/totp/enroll", auth.EnrollTOTP(connection)},
+			{"confirm_totp", http.MethodPost, "/mfa/totp/confirm", auth.ConfirmTOTP(connection)},
+			{"logout_all", http.MethodPost, "/logout/all", auth.LogoutAll(tokenService, refreshTokens)},
+			{"request_export", http.MethodPost, "/me/export", handlers.RequestExport(connection, &recordingQueue{})},
+			{"get_export", http.MethodGet, "/me/export/1", handlers.GetExport(connection, tokenService)},
+			{"calendar_feed", http.MethodPost, "/me/calendar-feed", handlers.ManageCalendarFeed(connection)},
+		} {
+			r := jsonRequest(t, c.method, c.target, map[string]string{"code": "123456"})
+			r = r.WithContext(middlewares.WithPrincipal(r.Context(), &middlewares.Principal{User: user, APIKey: &stored}))
+			rw := httptest.NewRecorder()
+			c.handler.ServeHTTP(rw, r)
+
+			if rw.Code != http.StatusForbidden {
+				t.Errorf("Unexpected status code for %s. Received %d; Expected %d", c.name, rw.Code, http.StatusForbidden)
+			}
+		}
+	})
+
+	t.Run("revoked_keys_are_rejected", func(t *testing.T) {
+		id := strconv.Itoa(int(created.ID))
+		r, _ := http.NewRequest(http.MethodDelete, "/api-keys/"+id, nil)
+		r = mux.SetURLVars(authenticate(r, user, ""), map[string]string{"key": id})
+		rw := httptest.NewRecorder()
+		auth.APIKey(connection).ServeHTTP(rw, r)
+
+		if rw.Code != http.StatusOK {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+
+		if code := call(policy.ReadEvents, created.Key); code != http.StatusUnauthorized {
+			t.Errorf("A revoked key is accepted. Received %d", code)
+		}
+	})
+}
@@ -0,0 +1,221 @@
+package test
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers"
+	"site/http/pagination"
+	"site/mailer"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRSVP(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	mails := mailer.NewInMemoryMailer()
+
+	organizer := models.User{Email: "rsvp-organizer@example.com", Password: "123456789"}
+	connection.Save(&organizer)
+	users := make([]models.User, 4)
+	for i := range users {
+		users[i] = models.User{Email: "rsvp-" + strconv.Itoa(i) + "@example.com", Password: "123456789"}
+		connection.Save(&users[i])
+	}
+
+	event := models.Event{Name: "Small workshop", UserID: organizer.ID, Capacity: 3, Status: models.EventPublished, Visibility: models.EventPublic}
+	connection.Save(&event)
+	target := "/event/" + strconv.Itoa(int(event.ID))
+
+	rsvp := func(method string, user models.User, body interface{}) (*httptest.ResponseRecorder, handlers.AttendeeResponse) {
+		rw := httptest.NewRecorder()
+		handlers.RSVP(connection, mails).ServeHTTP(rw, authenticate(jsonRequest(t, method, target+"/rsvp", body), user, ""))
+
+		response := handlers.AttendeeResponse{}
+		json.NewDecoder(rw.Body).Decode(&response)
+		return rw, response
+	}
+
+	rw, response := rsvp(http.MethodPut, users[0], map[string]interface{}{
+		"status":  "going",
+		"guests":  1,
+		"answers": map[string]string{"Diet": "=vegan"},
+	})
+	if rw.Code != http.StatusOK || response.Status != models.AttendeeGoing || response.Guests != 1 {
+		t.Fatalf("Unexpected answer %d %+v", rw.Code, response)
+	}
+
+	if rw, _ := rsvp(http.MethodPut, users[0], map[string]interface{}{"status": "waitlisted"}); rw.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Unexpected status code for an invalid status. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+	}
+
+	// two seats are taken, a party of two does not fit anymore
+	_, response = rsvp(http.MethodPut, users[1], map[string]interface{}{"status": "going", "guests": 1})
+	if response.Status != models.AttendeeWaitlisted || response.WaitlistPosition != 1 {
+		t.Errorf("Unexpected answer %+v", response)
+	}
+
+	// the waitlist is served first even though a single seat is free
+	_, response = rsvp(http.MethodPut, users[2], map[string]interface{}{"status": "going"})
+	if response.Status != models.AttendeeWaitlisted || response.WaitlistPosition != 2 {
+		t.Errorf("Unexpected answer %+v", response)
+	}
+
+	_, response = rsvp(http.MethodPut, users[3], map[string]interface{}{"status": "maybe"})
+	if response.Status != models.AttendeeMaybe {
+		t.Errorf("Unexpected answer %+v", response)
+	}
+
+	// a going attendee keeps the seats when more do not fit
+	if rw, _ := rsvp(http.MethodPut, users[0], map[string]interface{}{"status": "going", "guests": 3}); rw.Code != http.StatusConflict {
+		t.Errorf("Unexpected status code for too many guests. Received %d; Expected %d", rw.Code, http.StatusConflict)
+	}
+
+	// declining frees both seats for the first party on the waitlist
+	if _, response = rsvp(http.MethodPut, users[0], map[string]interface{}{"status": "declined"}); response.Status != models.AttendeeDeclined {
+		t.Errorf("Unexpected answer %+v", response)
+	}
+	if _, response = rsvp(http.MethodGet, users[1], nil); response.Status != models.AttendeeGoing || response.WaitlistedAt != nil {
+		t.Errorf("The first attendee on the waitlist has not been promoted %+v", response)
+	}
+	if _, response = rsvp(http.MethodGet, users[2], nil); response.Status != models.AttendeeGoing {
+		t.Errorf("The second attendee on the waitlist has not been promoted %+v", response)
+	}
+	if len(mails.Sent(users[1].Email)) != 1 || len(mails.Sent(users[2].Email)) != 1 {
+		t.Errorf("The promoted attendees have not been notified")
+	}
+
+	if _, response = rsvp(http.MethodPut, users[0], map[string]interface{}{"status": "going"}); response.Status != models.AttendeeWaitlisted {
+		t.Errorf("Unexpected answer %+v", response)
+	}
+	if rw, _ := rsvp(http.MethodDelete, users[2], nil); rw.Code != http.StatusNoContent {
+		t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusNoContent)
+	}
+	if rw, _ := rsvp(http.MethodGet, users[2], nil); rw.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status code for a withdrawn answer. Received %d; Expected %d", rw.Code, http.StatusNotFound)
+	}
+	if _, response = rsvp(http.MethodGet, users[0], nil); response.Status != models.AttendeeGoing {
+		t.Errorf("The freed seat has not been given to the waitlist %+v", response)
+	}
+
+	// events the user may not see are not found, whether they take answers or not
+	for _, c := range []struct {
+		event  models.Event
+		status int
+	}{
+		{models.Event{Name: "Unpublished workshop", UserID: organizer.ID, Visibility: models.EventPublic}, http.StatusNotFound},
+		{models.Event{Name: "Private workshop", UserID: organizer.ID, Status: models.EventPublished}, http.StatusNotFound},
+		{models.Event{Name: "Unlisted workshop", UserID: organizer.ID, Status: models.EventPublished, Visibility: models.EventUnlisted}, http.StatusNotFound},
+		{models.Event{Name: "Cancelled workshop", UserID: organizer.ID, Status: models.EventCancelled, Visibility: models.EventPublic}, http.StatusConflict},
+	} {
+		connection.Save(&c.event)
+		rw = httptest.NewRecorder()
+		r := jsonRequest(t, http.MethodPut, "/event/"+strconv.Itoa(int(c.event.ID))+"/rsvp", map[string]string{"status": "going"})
+		handlers.RSVP(connection, mails).ServeHTTP(rw, authenticate(r, users[0], ""))
+		if rw.Code != c.status {
+			t.Errorf("Unexpected status code for %s. Received %d; Expected %d", c.event.Name, rw.Code, c.status)
+		}
+	}
+}
+
+func TestEventCapacityPromotesWaitlist(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	mails := mailer.NewInMemoryMailer()
+
+	organizer := models.User{Email: "capacity-organizer@example.com", Password: "123456789"}
+	connection.Save(&organizer)
+	attendee := models.User{Email: "capacity-attendee@example.com", Password: "123456789"}
+	connection.Save(&attendee)
+
+	event := models.Event{Name: "Sold out concert", UserID: organizer.ID, Capacity: 1, Status: models.EventPublished, Visibility: models.EventPublic}
+	connection.Save(&event)
+	connection.Create(&models.Attendee{EventID: event.ID, UserID: organizer.ID, Status: models.AttendeeGoing})
+	target := "/event/" + strconv.Itoa(int(event.ID))
+
+	rw := httptest.NewRecorder()
+	handlers.RSVP(connection, mails).ServeHTTP(rw, authenticate(jsonRequest(t, http.MethodPut, target+"/rsvp", map[string]string{"status": "going"}), attendee, ""))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+
+	rw = httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodPatch, target, strings.NewReader(`{"capacity": 2}`))
+	handlers.UpdateEvent(connection, mails).ServeHTTP(rw, authenticate(r, organizer, ""))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+
+	stored := models.Attendee{}
+	connection.Where("event_id = ? AND user_id = ?", event.ID, attendee.ID).First(&stored)
+	if stored.Status != models.AttendeeGoing || len(mails.Sent(attendee.Email)) != 1 {
+		t.Errorf("The waitlist has not been promoted %+v", stored)
+	}
+}
+
+func TestGetAttendees(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+
+	organizer := models.User{Email: "attendees-organizer@example.com", Password: "123456789"}
+	connection.Save(&organizer)
+	stranger := models.User{Email: "attendees-stranger@example.com", Password: "123456789"}
+	connection.Save(&stranger)
+	guest := models.User{Email: "attendees-guest@example.com", Password: "123456789"}
+	connection.Save(&guest)
+
+	event := models.Event{Name: "Annual meetup", UserID: organizer.ID, Status: models.EventPublished, Visibility: models.EventPublic}
+	connection.Save(&event)
+	connection.Create(&models.Attendee{EventID: event.ID, UserID: guest.ID, Status: models.AttendeeGoing, Guests: 2, Answers: `{"Diet":"=vegan","T-shirt":"L"}`})
+	connection.Create(&models.Attendee{EventID: event.ID, UserID: stranger.ID, Status: models.AttendeeMaybe})
+	target := "/event/" + strconv.Itoa(int(event.ID))
+
+	list := func(user models.User, query string) (*httptest.ResponseRecorder, []handlers.AttendeeResponse) {
+		r, _ := http.NewRequest(http.MethodGet, target+"/attendees"+query, nil)
+		rw := httptest.NewRecorder()
+		handlers.GetAttendees(connection).ServeHTTP(rw, authenticate(r, user, ""))
+
+		attendees := []handlers.AttendeeResponse{}
+		json.NewDecoder(rw.Body).Decode(&pagination.Page{Data: &attendees})
+		return rw, attendees
+	}
+
+	if rw, _ := list(stranger, ""); rw.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code for another user. Received %d; Expected %d", rw.Code, http.StatusForbidden)
+	}
+
+	rw, attendees := list(organizer, "?status=going")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+	if len(attendees) != 1 || attendees[0].Email != guest.Email || attendees[0].Guests != 2 || attendees[0].Answers["T-shirt"] != "L" {
+		t.Errorf("Unexpected attendees %+v", attendees)
+	}
+
+	if rw, _ := list(organizer, "?status=unknown"); rw.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status code for an invalid status. Received %d; Expected %d", rw.Code, http.StatusBadRequest)
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, target+"/attendees.csv", nil)
+	rw = httptest.NewRecorder()
+	handlers.ExportAttendees(connection).ServeHTTP(rw, authenticate(r, organizer, ""))
+	if rw.Code != http.StatusOK || !strings.HasPrefix(rw.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("Unexpected response %d %s", rw.Code, rw.Header().Get("Content-Type"))
+	}
+
+	records, err := csv.NewReader(rw.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Can not read the export %s", err)
+	}
+	if len(records) != 3 || strings.Join(records[0], ",") != "id,user_id,email,status,guests,waitlisted_at,created_at,Diet,T-shirt" {
+		t.Fatalf("Unexpected export %v", records)
+	}
+	if records[1][2] != guest.Email || records[1][7] != "'=vegan" || records[1][8] != "L" || records[2][3] != models.AttendeeMaybe {
+		t.Errorf("Unexpected export %v", records)
+	}
+}
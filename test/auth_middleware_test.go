@@ -6,12 +6,15 @@ import (
 	"site/database"
 	"site/database/models"
 	"site/http/middlewares"
-	"site/security"
 	"testing"
+
+	"gorm.io/gorm"
 )
 
 func TestAuthMiddleware(t *testing.T) {
-	middleware := middlewares.AuthMiddleware(security.NewTokenService())
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	middleware := middlewares.AuthMiddleware(newTokenService(t), nil, connection)
 
 	t.Run("it_returns_unauthorized_status_code_if_authorization_header_is_missing", func(t *testing.T) {
 		r, err := http.NewRequest(http.MethodGet, "/test", nil)
@@ -48,11 +51,29 @@ func TestAuthMiddleware(t *testing.T) {
 		}
 	})
 
-	t.Run("it_calls_the_next_middleware", func(t *testing.T) {
-		connection, _ := database.NewTestDatabaseConnection()
-		s := security.NewTokenService()
+	t.Run("it_returns_unauthorized_status_code_if_user_does_not_exist", func(t *testing.T) {
+		s := newTokenService(t)
+		token, err := s.CreateToken(&models.User{Model: gorm.Model{ID: 123456}})
+		if err != nil {
+			t.Errorf("Can not generate a token %s", err)
+		}
 
-		database.RunMigrations(connection)
+		r, err := http.NewRequest(http.MethodGet, "/test", nil)
+		if err != nil {
+			t.Errorf("Can not create a request %s", err)
+		}
+		r.Header.Set(middlewares.AuthorizationHeader, "Bearer "+token)
+		rw := httptest.NewRecorder()
+
+		middlewares.AuthMiddleware(s, nil, connection)(http.NotFoundHandler()).ServeHTTP(rw, r)
+
+		if rw.Code != http.StatusUnauthorized {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("it_calls_the_next_middleware", func(t *testing.T) {
+		s := newTokenService(t)
 
 		user := models.User{
 			Email:    "example@example.com",
@@ -73,15 +94,19 @@ func TestAuthMiddleware(t *testing.T) {
 			t.Errorf("Can not create a request %s", err)
 		}
 
-		r.Header.Set(middlewares.AuthorizationHeader, token)
+		r.Header.Set(middlewares.AuthorizationHeader, "Bearer "+token)
 		rw := httptest.NewRecorder()
 
 		// dummy handler
 		d := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			current, ok := middlewares.CurrentUser(r)
+			if !ok || current.ID != user.ID {
+				t.Error("The authenticated user is not present in the request context")
+			}
 			rw.WriteHeader(http.StatusOK)
 		})
 
-		middleware(d).ServeHTTP(rw, r)
+		middlewares.AuthMiddleware(s, nil, connection)(d).ServeHTTP(rw, r)
 
 		if rw.Code != http.StatusOK {
 			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
@@ -7,9 +7,11 @@ import (
 	"site/database"
 	"site/database/models"
 	"site/http/handlers/auth"
+	"site/mailer"
 	"site/security"
 	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -22,7 +24,7 @@ func TestRegisterHandler(t *testing.T) {
 		rw := httptest.NewRecorder()
 
 		connection, _ := database.NewTestDatabaseConnection()
-		auth.Register(connection).ServeHTTP(rw, req)
+		auth.Register(connection, newTokenService(t), mailer.NewInMemoryMailer(), newPasswordChecker()).ServeHTTP(rw, req)
 
 		if rw.Code != http.StatusUnprocessableEntity {
 			t.Errorf("Validation rules are skipped. Receive %d", rw.Code)
@@ -46,14 +48,14 @@ func TestRegisterHandler(t *testing.T) {
 
 		body, _ := json.Marshal(auth.PossibleUser{
 			Name:                 "example@example.com",
-			Password:             "test123",
-			PasswordConfirmation: "test123",
+			Password:             "test1234",
+			PasswordConfirmation: "test1234",
 		})
 
 		req, _ := http.NewRequest("POST", "/register", strings.NewReader(string(body)))
 		rw := httptest.NewRecorder()
 
-		auth.Register(connection).ServeHTTP(rw, req)
+		auth.Register(connection, newTokenService(t), mailer.NewInMemoryMailer(), newPasswordChecker()).ServeHTTP(rw, req)
 
 		if rw.Code != http.StatusUnprocessableEntity {
 			t.Errorf("Invalid response code: %d, expected %d", rw.Code, http.StatusUnprocessableEntity)
@@ -73,8 +75,8 @@ func TestRegisterHandler(t *testing.T) {
 
 		body, _ := json.Marshal(auth.PossibleUser{
 			Name:                 email,
-			Password:             "test123",
-			PasswordConfirmation: "test123",
+			Password:             "test1234",
+			PasswordConfirmation: "test1234",
 		})
 
 		req, _ := http.NewRequest("POST", "/register", strings.NewReader(string(body)))
@@ -85,7 +87,7 @@ func TestRegisterHandler(t *testing.T) {
 		if result.RowsAffected != 0 {
 			t.Errorf("There is already an user with email %s", email)
 		}
-		auth.Register(connection).ServeHTTP(rw, req)
+		auth.Register(connection, newTokenService(t), mailer.NewInMemoryMailer(), newPasswordChecker()).ServeHTTP(rw, req)
 
 		if rw.Code != http.StatusOK {
 			t.Errorf("Invalid response code: %d, expected %d", rw.Code, http.StatusOK)
@@ -116,7 +118,7 @@ func TestLoginHandler(t *testing.T) {
 
 		rw := httptest.NewRecorder()
 
-		auth.Login(connection, security.TokenService{}).ServeHTTP(rw, r)
+		auth.Login(connection, security.TokenService{}, security.NewRefreshTokenService(connection), newLoginGuard()).ServeHTTP(rw, r)
 
 		if rw.Code != http.StatusUnprocessableEntity {
 			t.Errorf("Unexpected response status code. Expected %d, received: %d", http.StatusUnprocessableEntity, rw.Code)
@@ -150,7 +152,7 @@ func TestLoginHandler(t *testing.T) {
 
 		rw := httptest.NewRecorder()
 
-		auth.Login(connection, security.NewTokenService()).ServeHTTP(rw, r)
+		auth.Login(connection, newTokenService(t), security.NewRefreshTokenService(connection), newLoginGuard()).ServeHTTP(rw, r)
 
 		if rw.Code != http.StatusUnprocessableEntity {
 			t.Errorf("Unexpected response status code. Expected %d, received: %d", http.StatusUnprocessableEntity, rw.Code)
@@ -176,9 +178,11 @@ func TestLoginHandler(t *testing.T) {
 		if err != nil {
 			t.Errorf("Can not hash the password string")
 		}
+		verifiedAt := time.Now()
 		connection.Save(&models.User{
-			Email:    user.Name,
-			Password: string(hashedPassword),
+			Email:           user.Name,
+			Password:        string(hashedPassword),
+			EmailVerifiedAt: &verifiedAt,
 		})
 
 		requestPayload, err := json.Marshal(user)
@@ -193,7 +197,7 @@ func TestLoginHandler(t *testing.T) {
 
 		rw := httptest.NewRecorder()
 
-		auth.Login(connection, security.NewTokenService()).ServeHTTP(rw, r)
+		auth.Login(connection, newTokenService(t), security.NewRefreshTokenService(connection), newLoginGuard()).ServeHTTP(rw, r)
 
 		if rw.Code != http.StatusOK {
 			t.Errorf("Unexpected response status code. Expected %d, received: %d", http.StatusOK, rw.Code)
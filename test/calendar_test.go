@@ -0,0 +1,157 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers"
+	"site/ical"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCalendarEncoding(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+	start := time.Date(2031, 3, 4, 18, 0, 0, 0, newYork)
+	end := start.Add(2 * time.Hour)
+
+	calendar := ical.Calendar{ProdID: "-//site//events//EN", Events: []ical.Event{{
+		UID:            "event-1@example.com",
+		Stamp:          time.Now(),
+		Start:          start,
+		End:            &end,
+		TimeZone:       "America/New_York",
+		Summary:        "Meetup; talks, drinks \\ more\nSecond line",
+		Description:    strings.Repeat("Grüße aus Köln ", 20),
+		RecurrenceRule: "FREQ=WEEKLY;BYDAY=TU",
+		ExceptionDates: []time.Time{start.AddDate(0, 0, 14)},
+	}}}
+
+	buffer := bytes.Buffer{}
+	if _, err := calendar.WriteTo(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	output := buffer.String()
+
+	if !strings.HasSuffix(output, "END:VCALENDAR\r\n") || strings.Contains(strings.ReplaceAll(output, "\r\n", ""), "\n") {
+		t.Errorf("The lines do not end with CRLF")
+	}
+
+	for _, line := range strings.Split(output, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("The line is longer than 75 octets %q", line)
+		}
+	}
+
+	unfolded := strings.ReplaceAll(output, "\r\n ", "")
+	expected := []string{
+		`SUMMARY:Meetup\; talks\, drinks \\ more\nSecond line`,
+		"DESCRIPTION:" + strings.Repeat("Grüße aus Köln ", 20),
+		"DTSTART;TZID=America/New_York:20310304T180000",
+		"EXDATE;TZID=America/New_York:20310318T180000",
+		"BEGIN:VTIMEZONE\r\nTZID:America/New_York",
+		"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU",
+		"RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU",
+	}
+	for _, e := range expected {
+		if !strings.Contains(unfolded, e) {
+			t.Errorf("The calendar does not contain %q\n%s", e, output)
+		}
+	}
+}
+
+func TestEventCalendar(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+
+	owner := models.User{Email: "calendar@example.com", Password: "123456789"}
+	connection.Save(&owner)
+
+	starts := time.Date(2031, 3, 4, 23, 0, 0, 0, time.UTC)
+	event := models.Event{
+		Name:           "Weekly meetup",
+		UserID:         owner.ID,
+		StartsAt:       &starts,
+		TimeZone:       "America/New_York",
+		Status:         models.EventPublished,
+		RecurrenceRule: "FREQ=WEEKLY;BYDAY=TU;UNTIL=20310401",
+	}
+	connection.Save(&event)
+	connection.Save(&models.EventException{EventID: event.ID, OccurrenceAt: time.Date(2031, 3, 11, 22, 0, 0, 0, time.UTC), Cancelled: true})
+	connection.Save(&models.EventException{EventID: event.ID, OccurrenceAt: time.Date(2031, 3, 18, 22, 0, 0, 0, time.UTC), Name: "Special meetup"})
+	connection.Save(&models.Event{Name: "Unscheduled event", UserID: owner.ID})
+
+	router := mux.NewRouter()
+	router.Handle("/event/{event:[0-9]+}.ics", handlers.EventCalendar(connection))
+	router.Handle("/me/calendar-feed", handlers.ManageCalendarFeed(connection))
+	router.Handle("/calendar/{token}.ics", handlers.CalendarFeed(connection))
+
+	call := func(method string, target string, as *models.User) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, target, nil)
+		if as != nil {
+			r = authenticate(r, *as, "")
+		}
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, r)
+		return rw
+	}
+
+	rw := call(http.MethodGet, "/event/"+strconv.Itoa(int(event.ID))+".ics", &owner)
+	if rw.Code != http.StatusOK || rw.Header().Get("Content-Type") != ical.ContentType {
+		t.Fatalf("Unexpected response %d %s", rw.Code, rw.Header().Get("Content-Type"))
+	}
+
+	output := rw.Body.String()
+	expected := []string{
+		"RRULE:FREQ=WEEKLY;UNTIL=20310402T035959Z;BYDAY=TU",
+		"EXDATE;TZID=America/New_York:20310311T180000",
+		"RECURRENCE-ID;TZID=America/New_York:20310318T180000",
+		"SUMMARY:Special meetup",
+		"STATUS:CONFIRMED",
+	}
+	for _, e := range expected {
+		if !strings.Contains(output, e) {
+			t.Errorf("The calendar does not contain %q\n%s", e, output)
+		}
+	}
+
+	if rw := call(http.MethodGet, "/calendar/unknown.ics", nil); rw.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status code for an unknown feed. Received %d; Expected %d", rw.Code, http.StatusNotFound)
+	}
+
+	feed := handlers.CalendarFeedResponse{}
+	rw = call(http.MethodPost, "/me/calendar-feed", &owner)
+	json.NewDecoder(rw.Body).Decode(&feed)
+	if rw.Code != http.StatusCreated || !strings.HasSuffix(feed.URL, ".ics") {
+		t.Fatalf("Unexpected feed %d %+v", rw.Code, feed)
+	}
+	path := feed.URL[strings.Index(feed.URL, "/calendar/"):]
+
+	rw = call(http.MethodGet, path, nil)
+	if rw.Code != http.StatusOK || strings.Count(rw.Body.String(), "BEGIN:VEVENT") != 2 {
+		t.Errorf("Unexpected feed %d %s", rw.Code, rw.Body.String())
+	}
+
+	// a new link replaces the old one
+	call(http.MethodPost, "/me/calendar-feed", &owner)
+	if rw := call(http.MethodGet, path, nil); rw.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status code for a replaced feed. Received %d; Expected %d", rw.Code, http.StatusNotFound)
+	}
+
+	if rw := call(http.MethodDelete, "/me/calendar-feed", &owner); rw.Code != http.StatusNoContent {
+		t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusNoContent)
+	}
+
+	status := handlers.CalendarFeedResponse{}
+	json.NewDecoder(call(http.MethodGet, "/me/calendar-feed", &owner).Body).Decode(&status)
+	if status.Active {
+		t.Errorf("The feed is still active after revoking it")
+	}
+}
@@ -0,0 +1,166 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers"
+	"site/mailer"
+	"site/uploader"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestEventCollaborators(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+
+	newUser := func(name string) models.User {
+		user := models.User{Email: "collaborators-" + name + "@example.com", Password: "123456789"}
+		connection.Save(&user)
+		return user
+	}
+	owner := newUser("owner")
+	editor := newUser("editor")
+	uploaderUser := newUser("uploader")
+	viewer := newUser("viewer")
+	stranger := newUser("stranger")
+
+	event := models.Event{Name: "Shared draft", UserID: owner.ID}
+	connection.Save(&event)
+	target := "/event/" + strconv.Itoa(int(event.ID))
+
+	router := mux.NewRouter()
+	router.Handle("/event/{event}", handlers.GetEvent(connection)).Methods(http.MethodGet)
+	router.Handle("/event/{event}", handlers.UpdateEvent(connection, mailer.NewInMemoryMailer())).Methods(http.MethodPut, http.MethodPatch)
+	router.Handle("/event/{event}", handlers.DeleteEvent(connection, uploader.NewLocalUploader(), handlers.RetainMedia)).Methods(http.MethodDelete)
+	router.Handle("/event/{event}/upload", handlers.CreateMedia(connection, uploader.NewLocalUploader(), t.TempDir()+"/"))
+	router.Handle("/event/{event}/collaborators", handlers.Collaborators(connection))
+	router.Handle("/event/{event}/collaborators/{user}", handlers.Collaborator(connection))
+
+	call := func(method string, path string, user models.User, body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, target+path, strings.NewReader(body))
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, authenticate(r, user, ""))
+		return rw
+	}
+	share := func(user models.User, email string, role string) *httptest.ResponseRecorder {
+		return call(http.MethodPost, "/collaborators", user, `{"email": "`+email+`", "role": "`+role+`"}`)
+	}
+
+	if rw := share(stranger, editor.Email, models.CollaboratorEditor); rw.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code for another user. Received %d; Expected %d", rw.Code, http.StatusForbidden)
+	}
+	if rw := share(owner, strings.ToUpper(editor.Email), models.CollaboratorEditor); rw.Code != http.StatusCreated {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusCreated)
+	}
+	share(owner, uploaderUser.Email, models.CollaboratorMediaUploader)
+	share(owner, viewer.Email, models.CollaboratorViewer)
+
+	for _, c := range []struct {
+		email  string
+		role   string
+		status int
+	}{
+		{editor.Email, models.CollaboratorViewer, http.StatusConflict},
+		{owner.Email, models.CollaboratorViewer, http.StatusUnprocessableEntity},
+		{"collaborators-nobody@example.com", models.CollaboratorViewer, http.StatusUnprocessableEntity},
+		{stranger.Email, "owner", http.StatusUnprocessableEntity},
+	} {
+		if rw := share(owner, c.email, c.role); rw.Code != c.status {
+			t.Errorf("Unexpected status code for %s as %s. Received %d; Expected %d", c.email, c.role, rw.Code, c.status)
+		}
+	}
+
+	// the draft is only shown to the people working on it
+	if rw := call(http.MethodGet, "", stranger, ""); rw.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status code for a stranger. Received %d; Expected %d", rw.Code, http.StatusNotFound)
+	}
+	if rw := call(http.MethodGet, "", viewer, ""); rw.Code != http.StatusOK {
+		t.Errorf("Unexpected status code for a viewer. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+
+	for _, c := range []struct {
+		user         models.User
+		updateStatus int
+		uploadStatus int
+	}{
+		// allowed uploads get past the policy and fail on the missing file
+		{editor, http.StatusOK, http.StatusUnprocessableEntity},
+		{uploaderUser, http.StatusForbidden, http.StatusUnprocessableEntity},
+		{viewer, http.StatusForbidden, http.StatusForbidden},
+		{stranger, http.StatusForbidden, http.StatusForbidden},
+	} {
+		if rw := call(http.MethodPatch, "", c.user, `{"description": "Changed by `+c.user.Email+`"}`); rw.Code != c.updateStatus {
+			t.Errorf("Unexpected update status code for %s. Received %d; Expected %d", c.user.Email, rw.Code, c.updateStatus)
+		}
+		if rw := call(http.MethodPost, "/upload", c.user, ""); rw.Code != c.uploadStatus {
+			t.Errorf("Unexpected upload status code for %s. Received %d; Expected %d", c.user.Email, rw.Code, c.uploadStatus)
+		}
+	}
+
+	if rw := call(http.MethodPatch, "", editor, `{"visibility": "public"}`); rw.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code for an editor sharing the event. Received %d; Expected %d", rw.Code, http.StatusForbidden)
+	}
+	if rw := call(http.MethodPatch, "", owner, `{"visibility": "unlisted"}`); rw.Code != http.StatusOK {
+		t.Errorf("Unexpected status code for the owner sharing the event. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+	if rw := call(http.MethodPatch, "", editor, `{"description": "Changed while shared"}`); rw.Code != http.StatusOK {
+		t.Errorf("Unexpected status code for an editor of a shared event. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+
+	// replacing the event without a visibility keeps it shared
+	shared := models.Event{}
+	connection.First(&shared, event.ID)
+	for _, user := range []models.User{editor, owner} {
+		if rw := call(http.MethodPut, "", user, `{"name": "Shared draft", "description": "Replaced by `+user.Email+`"}`); rw.Code != http.StatusOK {
+			t.Errorf("Unexpected status code for %s replacing a shared event. Received %d; Expected %d", user.Email, rw.Code, http.StatusOK)
+		}
+	}
+	replaced := models.Event{}
+	connection.First(&replaced, event.ID)
+	if replaced.Visibility != models.EventUnlisted || replaced.ShareSlug == nil || *replaced.ShareSlug != *shared.ShareSlug {
+		t.Errorf("Replacing the event has changed how it is shared %+v", replaced)
+	}
+
+	if rw := call(http.MethodDelete, "", editor, ""); rw.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code for an editor deleting the event. Received %d; Expected %d", rw.Code, http.StatusForbidden)
+	}
+
+	rw := call(http.MethodGet, "/collaborators", viewer, "")
+	collaborators := []handlers.CollaboratorResponse{}
+	json.NewDecoder(rw.Body).Decode(&collaborators)
+	if rw.Code != http.StatusOK || len(collaborators) != 3 || collaborators[0].Email != editor.Email || collaborators[0].Role != models.CollaboratorEditor {
+		t.Errorf("Unexpected collaborators %d %+v", rw.Code, collaborators)
+	}
+	if rw := call(http.MethodGet, "/collaborators", stranger, ""); rw.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code for a stranger. Received %d; Expected %d", rw.Code, http.StatusForbidden)
+	}
+
+	viewerPath := "/collaborators/" + strconv.Itoa(int(viewer.ID))
+	if rw := call(http.MethodPut, viewerPath, editor, `{"role": "editor"}`); rw.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code for an editor changing roles. Received %d; Expected %d", rw.Code, http.StatusForbidden)
+	}
+	if rw := call(http.MethodPut, viewerPath, owner, `{"role": "editor"}`); rw.Code != http.StatusOK {
+		t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+	if rw := call(http.MethodPatch, "", viewer, `{"description": "Changed as an editor"}`); rw.Code != http.StatusOK {
+		t.Errorf("The new role has not been honored. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+
+	// collaborators may leave, but not remove others
+	if rw := call(http.MethodDelete, viewerPath, uploaderUser, ""); rw.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code for removing another collaborator. Received %d; Expected %d", rw.Code, http.StatusForbidden)
+	}
+	if rw := call(http.MethodDelete, viewerPath, viewer, ""); rw.Code != http.StatusNoContent {
+		t.Errorf("Unexpected status code for leaving. Received %d; Expected %d", rw.Code, http.StatusNoContent)
+	}
+	if rw := call(http.MethodGet, "", viewer, ""); rw.Code != http.StatusNotFound {
+		t.Errorf("A former collaborator still sees the draft. Received %d; Expected %d", rw.Code, http.StatusNotFound)
+	}
+}
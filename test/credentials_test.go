@@ -0,0 +1,172 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"site/database"
+	"site/database/models"
+	"site/http/middlewares"
+	"strings"
+	"testing"
+)
+
+func TestAuthMiddlewareCredentials(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	s := newTokenService(t)
+
+	user := models.User{Email: "credentials@example.com", Password: "123456789"}
+	connection.Save(&user)
+	token, err := s.CreateToken(&user)
+	if err != nil {
+		t.Fatalf("Can not generate a token %s", err)
+	}
+
+	ok := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	middleware := middlewares.AuthMiddleware(s, nil, connection,
+		middlewares.BearerToken,
+		middlewares.CookieToken("access_token"),
+		middlewares.QueryToken("access_token"),
+	)
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		middleware(ok).ServeHTTP(rw, r)
+		return rw
+	}
+
+	t.Run("it_challenges_requests_without_credentials", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodGet, "/test", nil)
+		r.Header.Set(middlewares.AuthorizationHeader, token)
+		rw := serve(r)
+
+		if rw.Code != http.StatusUnauthorized {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusUnauthorized)
+		}
+
+		if rw.Header().Get("WWW-Authenticate") != `Bearer realm="site"` {
+			t.Errorf("Unexpected challenge %s", rw.Header().Get("WWW-Authenticate"))
+		}
+	})
+
+	t.Run("it_reports_invalid_tokens", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodGet, "/test", nil)
+		r.Header.Set(middlewares.AuthorizationHeader, "Bearer invalid")
+		rw := serve(r)
+
+		if rw.Code != http.StatusUnauthorized {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusUnauthorized)
+		}
+
+		if !strings.Contains(rw.Header().Get("WWW-Authenticate"), `error="invalid_token"`) {
+			t.Errorf("Unexpected challenge %s", rw.Header().Get("WWW-Authenticate"))
+		}
+	})
+
+	t.Run("it_rejects_malformed_bearer_credentials", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodGet, "/test", nil)
+		r.Header.Set(middlewares.AuthorizationHeader, "Bearer")
+		rw := serve(r)
+
+		if rw.Code != http.StatusBadRequest {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("it_accepts_bearer_tokens", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodGet, "/test", nil)
+		r.Header.Set(middlewares.AuthorizationHeader, "bearer "+token)
+
+		if rw := serve(r); rw.Code != http.StatusOK {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("it_accepts_cookie_tokens", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodGet, "/test", nil)
+		r.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+
+		if rw := serve(r); rw.Code != http.StatusOK {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("it_requires_a_csrf_token_for_unsafe_cookie_requests", func(t *testing.T) {
+		for _, c := range []struct {
+			cookie string
+			header string
+			status int
+		}{
+			{"", "", http.StatusForbidden},
+			{"", "csrf-value", http.StatusForbidden},
+			{"csrf-value", "", http.StatusForbidden},
+			{"csrf-value", "another-value", http.StatusForbidden},
+			{"csrf-value", "csrf-value", http.StatusOK},
+		} {
+			r, _ := http.NewRequest(http.MethodPost, "/test", nil)
+			r.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+			if c.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: middlewares.CSRFCookie, Value: c.cookie})
+			}
+			if c.header != "" {
+				r.Header.Set(middlewares.CSRFHeader, c.header)
+			}
+
+			if rw := serve(r); rw.Code != c.status {
+				t.Errorf("Unexpected status code for cookie %q and header %q. Received %d; Expected %d", c.cookie, c.header, rw.Code, c.status)
+			}
+		}
+
+		// bearer tokens are never sent by the browser on its own
+		r, _ := http.NewRequest(http.MethodPost, "/test", nil)
+		r.Header.Set(middlewares.AuthorizationHeader, "Bearer "+token)
+		if rw := serve(r); rw.Code != http.StatusOK {
+			t.Errorf("Unexpected status code for a bearer token. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("it_accepts_query_tokens_on_get_requests_only", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodGet, "/test?access_token="+token, nil)
+		if rw := serve(r); rw.Code != http.StatusOK {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+
+		r, _ = http.NewRequest(http.MethodPost, "/test?access_token="+token, nil)
+		if rw := serve(r); rw.Code != http.StatusUnauthorized {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("it_rejects_more_than_one_credential_carrier", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodGet, "/test?access_token="+token, nil)
+		r.Header.Set(middlewares.AuthorizationHeader, "Bearer "+token)
+
+		if rw := serve(r); rw.Code != http.StatusBadRequest {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("it_accepts_query_tokens_on_download_routes_only", func(t *testing.T) {
+		os.Setenv("AUTH_QUERY_PARAMETER", "access_token")
+		defer os.Unsetenv("AUTH_QUERY_PARAMETER")
+
+		for _, c := range []struct {
+			extractors []middlewares.TokenExtractor
+			status     int
+		}{
+			{middlewares.TokenExtractorsFromEnv(), http.StatusUnauthorized},
+			{middlewares.DownloadTokenExtractorsFromEnv(), http.StatusOK},
+		} {
+			r, _ := http.NewRequest(http.MethodGet, "/test?access_token="+token, nil)
+			rw := httptest.NewRecorder()
+			middlewares.AuthMiddleware(s, nil, connection, c.extractors...)(ok).ServeHTTP(rw, r)
+			if rw.Code != c.status {
+				t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, c.status)
+			}
+		}
+	})
+}
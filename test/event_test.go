@@ -7,15 +7,13 @@ import (
 	"site/database"
 	"site/database/models"
 	"site/http/handlers"
-	"site/http/middlewares"
-	"site/security"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestCreateEvent(t *testing.T) {
-	tokenService := security.NewTokenService()
 	t.Run("it_allows_only_post_method", func(t *testing.T) {
 		connection, err := database.NewTestDatabaseConnection()
 		if err != nil {
@@ -29,7 +27,7 @@ func TestCreateEvent(t *testing.T) {
 		}
 		rw := httptest.NewRecorder()
 
-		handlers.EventCreate(connection, tokenService).ServeHTTP(rw, r)
+		handlers.EventCreate(connection).ServeHTTP(rw, r)
 
 		if rw.Code != http.StatusMethodNotAllowed {
 			t.Error("Incorrect method")
@@ -55,7 +53,7 @@ func TestCreateEvent(t *testing.T) {
 		}
 		rw := httptest.NewRecorder()
 
-		handlers.EventCreate(connection, tokenService).ServeHTTP(rw, r)
+		handlers.EventCreate(connection).ServeHTTP(rw, r)
 
 		if rw.Code != http.StatusUnprocessableEntity {
 			t.Errorf("Unexpected status code. Expected: %d, Received %d", http.StatusUnprocessableEntity, rw.Code)
@@ -79,10 +77,6 @@ func TestCreateEvent(t *testing.T) {
 			t.Errorf("Failing to create a user: %s", err)
 		}
 
-		token, err := tokenService.CreateToken(&user)
-		if err != nil {
-			t.Errorf("Can not create a token: %s", err)
-		}
 		event := models.Event{
 			Name: "TestEvent123456",
 		}
@@ -95,7 +89,7 @@ func TestCreateEvent(t *testing.T) {
 		if err != nil {
 			t.Error("Can not create a request")
 		}
-		r.Header.Set(middlewares.AuthorizationHeader, token)
+		r = authenticate(r, user, "")
 		rw := httptest.NewRecorder()
 
 		storedEvent := models.Event{}
@@ -105,7 +99,7 @@ func TestCreateEvent(t *testing.T) {
 			t.Errorf("There is events with that name %s", event.Name)
 		}
 
-		handlers.EventCreate(connection, tokenService).ServeHTTP(rw, r)
+		handlers.EventCreate(connection).ServeHTTP(rw, r)
 
 		storedEvent = models.Event{}
 		result = connection.Find(&storedEvent, "Name=?", event.Name)
@@ -170,7 +164,10 @@ func TestGetEvent(t *testing.T) {
 		}
 		database.RunMigrations(connection)
 
-		event := models.Event{Name: "TestEvent"}
+		owner := models.User{Email: "get-event-owner@example.com", Password: "123456789"}
+		connection.Save(&owner)
+
+		event := models.Event{Name: "TestEvent", UserID: owner.ID}
 		result := connection.Save(&event)
 		if result.Error != nil {
 			t.Errorf("Can not create an event %s", result.Error)
@@ -183,7 +180,7 @@ func TestGetEvent(t *testing.T) {
 		}
 		rw := httptest.NewRecorder()
 
-		handlers.GetEvent(connection).ServeHTTP(rw, r)
+		handlers.GetEvent(connection).ServeHTTP(rw, authenticate(r, owner, ""))
 
 		if rw.Code != http.StatusOK {
 			t.Errorf("Unexpected status code. Received: %d, Expected: %d", rw.Code, http.StatusOK)
@@ -197,3 +194,73 @@ func TestGetEvent(t *testing.T) {
 		}
 	})
 }
+
+func TestEventDetails(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+
+	user := models.User{Email: "event-details@example.com", Password: "123456789"}
+	connection.Save(&user)
+
+	create := func(body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(http.MethodPost, "/event", strings.NewReader(body))
+		rw := httptest.NewRecorder()
+		handlers.EventCreate(connection).ServeHTTP(rw, authenticate(r, user, ""))
+		return rw
+	}
+
+	invalid := map[string]struct {
+		body  string
+		field string
+		tag   string
+	}{
+		"end before start":  {`{"name": "Conference", "starts_at": "2030-05-01T10:00:00+02:00", "ends_at": "2030-05-01T09:00:00+02:00"}`, "EndsAt", "gtfield"},
+		"end without start": {`{"name": "Conference", "ends_at": "2030-05-01T09:00:00Z"}`, "StartsAt", "required_with"},
+		"unknown zone":      {`{"name": "Conference", "starts_at": "2030-05-01T10:00:00Z", "time_zone": "Mars/Olympus"}`, "TimeZone", "timezone"},
+		"latitude only":     {`{"name": "Conference", "latitude": 52.52}`, "Latitude", "paired"},
+		"bad latitude":      {`{"name": "Conference", "latitude": 152.52, "longitude": 13.4}`, "Latitude", "latitude"},
+		"negative capacity": {`{"name": "Conference", "capacity": -1}`, "Capacity", "min"},
+		"unknown status":    {`{"name": "Conference", "status": "archived"}`, "Status", "oneof"},
+	}
+
+	for name, c := range invalid {
+		t.Run(name, func(t *testing.T) {
+			rw := create(c.body)
+			if rw.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+			}
+
+			errors := map[string]string{}
+			json.NewDecoder(rw.Body).Decode(&errors)
+			if errors[c.field] != c.tag {
+				t.Errorf("Unexpected validation errors %v; Expected %s: %s", errors, c.field, c.tag)
+			}
+		})
+	}
+
+	rw := create(`{
+		"name": "Go meetup Berlin",
+		"description": "Talks and pizza",
+		"starts_at": "2030-05-01T19:00:00+02:00",
+		"ends_at": "2030-05-01T22:00:00+02:00",
+		"time_zone": "Europe/Berlin",
+		"venue": "Community hall",
+		"address": "Alexanderplatz 1, Berlin",
+		"latitude": 52.5219,
+		"longitude": 13.4132,
+		"capacity": 80
+	}`)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d: %s", rw.Code, http.StatusOK, rw.Body.String())
+	}
+
+	stored := models.Event{}
+	connection.Where("name = ?", "Go meetup Berlin").First(&stored)
+	if stored.StartsAt == nil || !stored.StartsAt.Equal(time.Date(2030, 5, 1, 17, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected start %v", stored.StartsAt)
+	}
+
+	if stored.TimeZone != "Europe/Berlin" || stored.Capacity != 80 || stored.Latitude == nil || stored.Status != models.EventDraft {
+		t.Errorf("Unexpected event %+v", stored)
+	}
+}
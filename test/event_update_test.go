@@ -0,0 +1,173 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers"
+	"site/mailer"
+	"site/mergepatch"
+	"site/uploader"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUpdateEvent(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+
+	owner := models.User{Email: "event-update@example.com", Password: "123456789"}
+	connection.Save(&owner)
+	stranger := models.User{Email: "event-update-stranger@example.com", Password: "123456789"}
+	connection.Save(&stranger)
+
+	event := models.Event{Name: "Original name", UserID: owner.ID}
+	connection.Save(&event)
+	target := "/event/" + strconv.Itoa(int(event.ID))
+
+	update := func(method string, user models.User, contentType string, body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		rw := httptest.NewRecorder()
+		handlers.UpdateEvent(connection, mailer.NewInMemoryMailer()).ServeHTTP(rw, authenticate(r, user, ""))
+		return rw
+	}
+
+	if rw := update(http.MethodPut, stranger, "", `{"name": "Stolen event"}`); rw.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code for another user. Received %d; Expected %d", rw.Code, http.StatusForbidden)
+	}
+
+	if rw := update(http.MethodPut, owner, "", `{"name": "short"}`); rw.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Unexpected status code for an invalid name. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+	}
+
+	rw := update(http.MethodPut, owner, "", `{"name": "Replaced name"}`)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+
+	rw = update(http.MethodPatch, owner, mergepatch.ContentType, `{"name": "Patched name"}`)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+
+	stored := models.Event{}
+	connection.First(&stored, event.ID)
+	if stored.Name != "Patched name" || stored.UserID != owner.ID {
+		t.Errorf("Unexpected event %+v", stored)
+	}
+
+	// an empty patch changes nothing, null removes the required name
+	if rw := update(http.MethodPatch, owner, mergepatch.ContentType, `{}`); rw.Code != http.StatusOK {
+		t.Errorf("Unexpected status code for an empty patch. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+
+	if rw := update(http.MethodPatch, owner, mergepatch.ContentType, `{"name": null}`); rw.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Unexpected status code for removing the name. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+	}
+
+	if rw := update(http.MethodPatch, owner, "text/plain", `name`); rw.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Unexpected status code for a text patch. Received %d; Expected %d", rw.Code, http.StatusUnsupportedMediaType)
+	}
+}
+
+func TestDeleteAndRestoreEvent(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	files := uploader.NewLocalUploader()
+
+	owner := models.User{Email: "event-delete@example.com", Password: "123456789"}
+	connection.Save(&owner)
+
+	call := func(handler http.Handler, method string, event models.Event, suffix string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, "/event/"+strconv.Itoa(int(event.ID))+suffix, nil)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, authenticate(r, owner, ""))
+		return rw
+	}
+
+	newEvent := func() (models.Event, models.Media) {
+		event := models.Event{Name: "Event to delete", UserID: owner.ID}
+		connection.Save(&event)
+		path, _ := files.Upload("photo.jpg", t.TempDir()+"/", strings.NewReader("image"))
+		media := models.Media{Name: "photo.jpg", Path: path, EventId: event.ID}
+		connection.Save(&media)
+		return event, media
+	}
+
+	t.Run("it_retains_media_and_restores", func(t *testing.T) {
+		event, media := newEvent()
+
+		rw := call(handlers.DeleteEvent(connection, files, handlers.RetainMedia), http.MethodDelete, event, "")
+		if rw.Code != http.StatusNoContent {
+			t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusNoContent)
+		}
+
+		if rw := call(handlers.GetEvent(connection), http.MethodGet, event, ""); rw.Code != http.StatusNotFound {
+			t.Errorf("Unexpected status code for a deleted event. Received %d; Expected %d", rw.Code, http.StatusNotFound)
+		}
+
+		rw = call(handlers.RestoreEvent(connection), http.MethodPost, event, "/restore")
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+
+		restored := models.Event{}
+		json.NewDecoder(rw.Body).Decode(&restored)
+		if restored.ID != event.ID || restored.DeletedAt.Valid {
+			t.Errorf("Unexpected restored event %+v", restored)
+		}
+
+		var count int64
+		connection.Model(&models.Media{}).Where("id = ?", media.ID).Count(&count)
+		if count != 1 {
+			t.Error("The media has not been retained")
+		}
+
+		if rw := call(handlers.RestoreEvent(connection), http.MethodPost, event, "/restore"); rw.Code != http.StatusNotFound {
+			t.Errorf("Unexpected status code for an event that is not deleted. Received %d; Expected %d", rw.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("it_removes_media", func(t *testing.T) {
+		event, media := newEvent()
+
+		rw := call(handlers.DeleteEvent(connection, files, handlers.RemoveMedia), http.MethodDelete, event, "")
+		if rw.Code != http.StatusNoContent {
+			t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusNoContent)
+		}
+
+		var count int64
+		connection.Unscoped().Model(&models.Media{}).Where("id = ?", media.ID).Count(&count)
+		if count != 0 {
+			t.Error("The media record has not been removed")
+		}
+
+		if file, err := files.Open(media.Path); err == nil {
+			file.Close()
+			t.Error("The media file has not been removed")
+		}
+	})
+
+	t.Run("it_keeps_files_other_media_points_at", func(t *testing.T) {
+		event, media := newEvent()
+		other, _ := newEvent()
+		connection.Save(&models.Media{Name: "photo.jpg", Path: media.Path, EventId: other.ID})
+
+		rw := call(handlers.DeleteEvent(connection, files, handlers.RemoveMedia), http.MethodDelete, event, "")
+		if rw.Code != http.StatusNoContent {
+			t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusNoContent)
+		}
+
+		file, err := files.Open(media.Path)
+		if err != nil {
+			t.Fatal("The media file of another event has been removed")
+		}
+		file.Close()
+	})
+}
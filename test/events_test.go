@@ -2,20 +2,20 @@ package test
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"site/database"
 	"site/database/models"
 	"site/http/handlers"
-	"site/http/middlewares"
-	"site/security"
+	"site/http/pagination"
+	"strings"
 	"testing"
-
-	"gorm.io/gorm"
+	"time"
 )
 
 func TestGetEvents(t *testing.T) {
-	sec := security.NewTokenService()
 	t.Run("it_returns_methon_not_allowed", func(t *testing.T) {
 		connection, err := database.NewTestDatabaseConnection()
 		if err != nil {
@@ -29,7 +29,7 @@ func TestGetEvents(t *testing.T) {
 		}
 		rw := httptest.NewRecorder()
 
-		handlers.GetEvents(connection, sec).ServeHTTP(rw, r)
+		handlers.GetEvents(connection).ServeHTTP(rw, r)
 		if rw.Code != http.StatusMethodNotAllowed {
 			t.Errorf("Unexpected status code. Received: %d, Expected: %d", rw.Code, http.StatusMethodNotAllowed)
 		}
@@ -48,45 +48,12 @@ func TestGetEvents(t *testing.T) {
 		}
 		rw := httptest.NewRecorder()
 
-		handlers.GetEvents(connection, sec).ServeHTTP(rw, r)
+		handlers.GetEvents(connection).ServeHTTP(rw, r)
 		if rw.Code != http.StatusUnauthorized {
 			t.Errorf("Unexpected status code. Received: %d, Expected: %d", rw.Code, http.StatusUnauthorized)
 		}
 	})
 
-	t.Run("it_returns_not_found_if_user_is_not_found", func(t *testing.T) {
-		connection, err := database.NewTestDatabaseConnection()
-		if err != nil {
-			t.Error("Can not get db connection")
-		}
-		database.RunMigrations(connection)
-
-		user := models.User{
-			Email:    "example@example.com",
-			Password: "123456789",
-			Model: gorm.Model{
-				ID: 123456,
-			},
-		}
-
-		token, err := sec.CreateToken(&user)
-		if err != nil {
-			t.Errorf("Can not create a user token %s", err)
-		}
-
-		r, err := http.NewRequest(http.MethodGet, "/event", nil)
-		if err != nil {
-			t.Errorf("Can not create a request: %s", err)
-		}
-		r.Header.Add(middlewares.AuthorizationHeader, token)
-		rw := httptest.NewRecorder()
-
-		handlers.GetEvents(connection, sec).ServeHTTP(rw, r)
-		if rw.Code != http.StatusNotFound {
-			t.Errorf("Unexpected status code. Received: %d, Expected: %d", rw.Code, http.StatusNotFound)
-		}
-	})
-
 	t.Run("it_returns_user_events", func(t *testing.T) {
 		connection, err := database.NewTestDatabaseConnection()
 		if err != nil {
@@ -114,27 +81,23 @@ func TestGetEvents(t *testing.T) {
 			t.Errorf("Can not store user events: %s", result.Error)
 		}
 
-		token, err := sec.CreateToken(&user)
-		if err != nil {
-			t.Errorf("Can not create a user token %s", err)
-		}
-
 		r, err := http.NewRequest(http.MethodGet, "/event", nil)
 		if err != nil {
 			t.Errorf("Can not create a request: %s", err)
 		}
 
-		r.Header.Add(middlewares.AuthorizationHeader, token)
+		r = authenticate(r, user, "")
 		rw := httptest.NewRecorder()
 
-		handlers.GetEvents(connection, sec).ServeHTTP(rw, r)
+		handlers.GetEvents(connection).ServeHTTP(rw, r)
 
 		if rw.Code != http.StatusOK {
 			t.Errorf("Unexpected status code. Received: %d, Expected: %d", rw.Code, http.StatusOK)
 		}
 
 		var responseEvents []models.Event
-		err = json.NewDecoder(rw.Body).Decode(&responseEvents)
+		page := pagination.Page{Data: &responseEvents}
+		err = json.NewDecoder(rw.Body).Decode(&page)
 		if err != nil {
 			t.Errorf("Can not parse respones body %s", err)
 		}
@@ -148,3 +111,125 @@ func TestGetEvents(t *testing.T) {
 		}
 	})
 }
+
+func TestGetEventsPagination(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+
+	user := models.User{Email: "pagination@example.com", Password: "123456789"}
+	connection.Save(&user)
+
+	starts := time.Date(2031, 3, 1, 18, 0, 0, 0, time.UTC)
+	events := []models.Event{}
+	for i := 0; i < 7; i++ {
+		event := models.Event{Name: fmt.Sprintf("Meetup %d", i), UserID: user.ID, Status: models.EventDraft}
+		if i%2 == 0 {
+			startsAt := starts.AddDate(0, 0, i)
+			event.StartsAt = &startsAt
+			event.Status = models.EventPublished
+		}
+		connection.Create(&event)
+		events = append(events, event)
+	}
+	connection.Create(&models.Event{Name: "100%_off sale", UserID: user.ID})
+	connection.Create(&models.Event{Name: "Last call! 50% off_today", UserID: user.ID})
+
+	list := func(query string) (*httptest.ResponseRecorder, pagination.Page, []models.Event) {
+		r, _ := http.NewRequest(http.MethodGet, "/events?"+query, nil)
+		r = authenticate(r, user, "")
+		rw := httptest.NewRecorder()
+		handlers.GetEvents(connection).ServeHTTP(rw, r)
+
+		listed := []models.Event{}
+		page := pagination.Page{Data: &listed}
+		json.NewDecoder(rw.Body).Decode(&page)
+		return rw, page, listed
+	}
+
+	collect := func(query string) []uint {
+		ids := []uint{}
+		cursor := ""
+		for i := 0; i < 10; i++ {
+			rw, page, listed := list(query + "&cursor=" + url.QueryEscape(cursor))
+			if rw.Code != http.StatusOK {
+				t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+			}
+			for _, event := range listed {
+				ids = append(ids, event.ID)
+			}
+			if page.NextCursor == "" {
+				return ids
+			}
+			cursor = page.NextCursor
+		}
+		t.Fatal("The pages do not end")
+		return nil
+	}
+
+	t.Run("it_pages_with_a_cursor", func(t *testing.T) {
+		rw, page, listed := list("limit=3&name=Meetup")
+		if page.Total != 7 || page.Limit != 3 || len(listed) != 3 || page.NextCursor == "" {
+			t.Fatalf("Unexpected page %+v", page)
+		}
+		if !strings.Contains(rw.Header().Get("Link"), `rel="next"`) {
+			t.Errorf("Unexpected Link header %s", rw.Header().Get("Link"))
+		}
+
+		ids := collect("limit=3&name=Meetup")
+		if len(ids) != 7 || ids[0] != events[0].ID || ids[6] != events[6].ID {
+			t.Errorf("Unexpected events %v", ids)
+		}
+	})
+
+	t.Run("it_sorts_nullable_columns", func(t *testing.T) {
+		ascending := collect("limit=2&name=Meetup&sort=starts_at")
+		expected := []uint{events[0].ID, events[2].ID, events[4].ID, events[6].ID, events[1].ID, events[3].ID, events[5].ID}
+		if fmt.Sprint(ascending) != fmt.Sprint(expected) {
+			t.Errorf("Unexpected ascending order %v; Expected %v", ascending, expected)
+		}
+
+		descending := collect("limit=2&name=Meetup&sort=-starts_at")
+		for i, id := range descending {
+			if id != expected[len(expected)-1-i] {
+				t.Fatalf("Unexpected descending order %v", descending)
+			}
+		}
+	})
+
+	t.Run("it_filters", func(t *testing.T) {
+		_, page, _ := list("name=Meetup&status=published&from=2031-03-03&to=2031-03-07")
+		if page.Total != 2 {
+			t.Errorf("Unexpected total %d; Expected 2", page.Total)
+		}
+
+		_, page, listed := list("name=" + url.QueryEscape("%_"))
+		if page.Total != 1 || listed[0].Name != "100%_off sale" {
+			t.Errorf("The wildcards of the name are not escaped %+v", listed)
+		}
+
+		_, page, listed = list("name=" + url.QueryEscape("! 50% off_"))
+		if page.Total != 1 || listed[0].Name != "Last call! 50% off_today" {
+			t.Errorf("The escape character of the name is not escaped %+v", listed)
+		}
+
+		_, page, _ = list("created_after=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)))
+		if page.Total != 0 {
+			t.Errorf("Unexpected total %d; Expected 0", page.Total)
+		}
+	})
+
+	t.Run("it_caps_the_limit", func(t *testing.T) {
+		if _, page, _ := list("limit=1000"); page.Limit != pagination.MaxLimit {
+			t.Errorf("Unexpected limit %d", page.Limit)
+		}
+	})
+
+	t.Run("it_rejects_invalid_parameters", func(t *testing.T) {
+		_, page, _ := list("limit=1&sort=name")
+		for _, query := range []string{"limit=0", "sort=password", "status=unknown", "from=tomorrow", "cursor=broken", "cursor=" + page.NextCursor} {
+			if rw, _, _ := list(query); rw.Code != http.StatusBadRequest {
+				t.Errorf("Unexpected status code for %s. Received %d; Expected %d", query, rw.Code, http.StatusBadRequest)
+			}
+		}
+	})
+}
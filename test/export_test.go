@@ -0,0 +1,225 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"site/database"
+	"site/database/models"
+	"site/export"
+	"site/http/handlers"
+	"site/uploader"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// recordingQueue keeps enqueued jobs instead of processing them.
+type recordingQueue struct {
+	jobs []uint
+}
+
+func (q *recordingQueue) Enqueue(jobID uint) {
+	q.jobs = append(q.jobs, jobID)
+}
+
+func TestExport(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	tokenService := newTokenService(t)
+	files := uploader.NewLocalUploader()
+	worker := export.NewWorker(connection, files, t.TempDir())
+	queue := &recordingQueue{}
+
+	user := models.User{Email: "export@example.com", Password: "123456789"}
+	connection.Save(&user)
+	other := models.User{Email: "export-other@example.com", Password: "123456789"}
+	connection.Save(&other)
+
+	event := models.Event{Name: "Exported event", UserID: user.ID}
+	connection.Save(&event)
+	connection.Save(&models.Event{Name: "Somebody else's event", UserID: other.ID})
+
+	path, err := files.Upload("photo.jpg", t.TempDir()+"/", strings.NewReader("image content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection.Save(&models.Media{Name: "photo.jpg", Size: 13, Path: path, EventId: event.ID})
+	connection.Save(&models.Media{Name: "lost.jpg", Path: "/does/not/exist.jpg", EventId: event.ID})
+
+	router := mux.NewRouter()
+	router.Handle("/me/export", handlers.RequestExport(connection, queue))
+	router.Handle("/me/export/{export}", handlers.GetExport(connection, tokenService))
+	router.Handle("/me/export/{export}/download", handlers.DownloadExport(connection, tokenService))
+
+	call := func(method string, target string, as *models.User) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, target, nil)
+		if as != nil {
+			r = authenticate(r, *as, "")
+		}
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, r)
+		return rw
+	}
+
+	rw := call(http.MethodPost, "/me/export", &user)
+	if rw.Code != http.StatusAccepted {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusAccepted)
+	}
+	location := rw.Header().Get("Location")
+
+	if rw := call(http.MethodPost, "/me/export", &user); rw.Code != http.StatusAccepted || len(queue.jobs) != 1 {
+		t.Errorf("A second export has been started while one is pending")
+	}
+
+	if rw := call(http.MethodGet, location, &other); rw.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status code for another user. Received %d; Expected %d", rw.Code, http.StatusNotFound)
+	}
+
+	status := handlers.ExportResponse{}
+	json.NewDecoder(call(http.MethodGet, location, &user).Body).Decode(&status)
+	if status.Status != models.ExportPending || status.DownloadURL != "" {
+		t.Errorf("Unexpected pending export %+v", status)
+	}
+
+	if err := worker.Process(queue.jobs[0]); err != nil {
+		t.Fatalf("The export failed %s", err)
+	}
+
+	json.NewDecoder(call(http.MethodGet, location, &user).Body).Decode(&status)
+	if status.Status != models.ExportCompleted || status.DownloadURL == "" {
+		t.Fatalf("Unexpected completed export %+v", status)
+	}
+
+	if rw := call(http.MethodGet, location+"/download?token=forged", nil); rw.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code for a forged link. Received %d; Expected %d", rw.Code, http.StatusForbidden)
+	}
+
+	rw = call(http.MethodGet, status.DownloadURL, nil)
+	if rw.Code != http.StatusOK || rw.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("Unexpected download. Received %d %s", rw.Code, rw.Header().Get("Content-Type"))
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(rw.Body.Bytes()), int64(rw.Body.Len()))
+	if err != nil {
+		t.Fatalf("The download is not a ZIP archive %s", err)
+	}
+
+	entries := map[string]string{}
+	for _, file := range archive.File {
+		reader, _ := file.Open()
+		content, _ := ioutil.ReadAll(reader)
+		reader.Close()
+		entries[file.Name] = string(content)
+	}
+
+	profile := export.Profile{}
+	json.Unmarshal([]byte(entries["profile.json"]), &profile)
+	if profile.Email != user.Email || strings.Contains(entries["profile.json"], user.Password) {
+		t.Errorf("Unexpected profile %s", entries["profile.json"])
+	}
+
+	events := []models.Event{}
+	json.Unmarshal([]byte(entries["events.json"]), &events)
+	if len(events) != 1 || events[0].ID != event.ID {
+		t.Errorf("Unexpected events %s", entries["events.json"])
+	}
+
+	media := []export.Media{}
+	json.Unmarshal([]byte(entries["media.json"]), &media)
+	if len(media) != 2 || media[0].File == "" || media[1].File != "" {
+		t.Fatalf("Unexpected media %s", entries["media.json"])
+	}
+
+	if entries[media[0].File] != "image content" {
+		t.Errorf("Unexpected media content %q", entries[media[0].File])
+	}
+}
+
+func TestExportOnlyContainsOwnMedia(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	files := uploader.NewLocalUploader()
+	worker := export.NewWorker(connection, files, t.TempDir())
+	queue := &recordingQueue{}
+	mediaDir := t.TempDir() + "/"
+
+	upload := func(user models.User, content string) {
+		event := models.Event{Name: "Exported media event", UserID: user.ID}
+		connection.Save(&event)
+
+		body := bytes.Buffer{}
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("file", "photo.jpg")
+		part.Write([]byte(content))
+		form.Close()
+
+		r, _ := http.NewRequest(http.MethodPost, "/event/"+strconv.Itoa(int(event.ID))+"/upload", &body)
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		rw := httptest.NewRecorder()
+		handlers.CreateMedia(connection, files, mediaDir).ServeHTTP(rw, authenticate(r, user, ""))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Unexpected status code for the upload. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+	}
+
+	first := models.User{Email: "export-media-first@example.com", Password: "123456789"}
+	connection.Save(&first)
+	second := models.User{Email: "export-media-second@example.com", Password: "123456789"}
+	connection.Save(&second)
+	upload(first, "first content")
+	upload(second, "second content")
+
+	// a file left from before uploads had names of their own
+	legacy, _ := files.Upload("test.jpg", t.TempDir()+"/", strings.NewReader("somebody's content"))
+	for _, user := range []models.User{first, second} {
+		event := models.Event{Name: "Legacy media event", UserID: user.ID}
+		connection.Save(&event)
+		connection.Save(&models.Media{Name: "test.jpg", Path: legacy, EventId: event.ID})
+	}
+
+	for _, c := range []struct {
+		user    models.User
+		content string
+	}{
+		{first, "first content"},
+		{second, "second content"},
+	} {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodPost, "/me/export", nil)
+		handlers.RequestExport(connection, queue).ServeHTTP(rw, authenticate(r, c.user, ""))
+		jobID := queue.jobs[len(queue.jobs)-1]
+		if err := worker.Process(jobID); err != nil {
+			t.Fatalf("The export failed %s", err)
+		}
+
+		job := models.ExportJob{}
+		connection.First(&job, jobID)
+		archive, err := zip.OpenReader(job.Path)
+		if err != nil {
+			t.Fatalf("The export is not a ZIP archive %s", err)
+		}
+
+		contents := []string{}
+		for _, file := range archive.File {
+			if !strings.HasPrefix(file.Name, "media/") {
+				continue
+			}
+			reader, _ := file.Open()
+			content, _ := ioutil.ReadAll(reader)
+			reader.Close()
+			contents = append(contents, string(content))
+		}
+		archive.Close()
+
+		if len(contents) != 1 || contents[0] != c.content {
+			t.Errorf("Unexpected media in the export of %s %q", c.user.Email, contents)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"site/database/models"
+	"site/http/middlewares"
+	"site/lockout"
+	"site/mailer"
+	"site/passwords"
+	"site/security"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSecret = "a-test-secret-that-is-long-enough-for-hs256"
+
+func newTokenService(t *testing.T) *security.TokenService {
+	tokenService, err := security.NewTokenService(security.Config{
+		SigningKeyID: "test",
+		Keys: []security.KeyConfig{
+			{ID: "test", Algorithm: security.AlgorithmHS256, Secret: testSecret},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Can not create the token service %s", err)
+	}
+
+	return tokenService
+}
+
+// authenticate stores the principal AuthMiddleware would store for the user.
+func authenticate(r *http.Request, user models.User, token string) *http.Request {
+	return r.WithContext(middlewares.WithPrincipal(r.Context(), &middlewares.Principal{User: user, Token: token}))
+}
+
+// waitForMessages waits until count messages have been sent to the address,
+// for messages sent after the response.
+func waitForMessages(t *testing.T, mails *mailer.InMemoryMailer, to string, count int) []mailer.Message {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if sent := mails.Sent(to); len(sent) >= count {
+			return sent
+		}
+	}
+	t.Fatalf("Expected %d messages to %s", count, to)
+	return nil
+}
+
+var messageTokenRegex = regexp.MustCompile(`token=([^\s]+)`)
+
+// tokenFromMessage returns the token of the link contained in an email.
+func tokenFromMessage(t *testing.T, message mailer.Message) string {
+	match := messageTokenRegex.FindStringSubmatch(message.Body)
+	if match == nil {
+		t.Fatalf("The message does not contain a link with a token: %s", message.Body)
+	}
+
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("Can not decode the token %s", err)
+	}
+
+	return token
+}
+
+func jsonRequest(t *testing.T, method string, target string, body interface{}) *http.Request {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("Can not encode json payload %s", err)
+	}
+
+	r, err := http.NewRequest(method, target, strings.NewReader(string(payload)))
+	if err != nil {
+		t.Fatalf("Can not create a request %s", err)
+	}
+
+	return r
+}
+
+// recordingLogger keeps audit entries in memory.
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []models.AuditLog
+}
+
+func (l *recordingLogger) Record(entry models.AuditLog) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+func newLoginGuard() *lockout.Guard {
+	return lockout.NewGuard(lockout.NewMemoryStore(), &recordingLogger{})
+}
+
+func newPasswordChecker() *passwords.Checker {
+	return passwords.NewChecker(passwords.DefaultPolicy, nil)
+}
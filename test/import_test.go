@@ -0,0 +1,201 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers"
+	"strings"
+	"testing"
+	"time"
+)
+
+const importedCalendar = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Example//Calendar//EN
+BEGIN:VTIMEZONE
+TZID:Office Time
+BEGIN:STANDARD
+DTSTART:19701025T030000
+TZOFFSETFROM:+0200
+TZOFFSETTO:+0100
+RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU
+END:STANDARD
+BEGIN:DAYLIGHT
+DTSTART:19700329T020000
+TZOFFSETFROM:+0100
+TZOFFSETTO:+0200
+RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU
+END:DAYLIGHT
+END:VTIMEZONE
+BEGIN:VEVENT
+UID:standup@example.com
+DTSTAMP:20310101T000000Z
+DTSTART;TZID=W. Europe Standard Time:20310303T093000
+DURATION:PT15M
+SUMMARY:Daily standup\, team A
+DESCRIPTION:Line one\nLine two with a folded tail that goes on and on and
+  on until it ends
+RRULE:FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR;COUNT=10
+EXDATE;TZID=Europe/Berlin:20310304T093000
+ATTACH:https://example.com/agenda.pdf
+END:VEVENT
+BEGIN:VEVENT
+UID:standup@example.com
+DTSTAMP:20310101T000000Z
+RECURRENCE-ID;TZID=Europe/Berlin:20310305T093000
+DTSTART;TZID=Europe/Berlin:20310305T110000
+DTEND;TZID=Europe/Berlin:20310305T111500
+SUMMARY:Daily standup\, team A
+END:VEVENT
+BEGIN:VEVENT
+UID:offsite@example.com
+DTSTAMP:20310101T000000Z
+DTSTART;TZID=Office Time:20310710T090000
+DTEND;TZID=Office Time:20310710T170000
+SUMMARY:Summer offsite
+LOCATION:Lakeside hotel
+GEO:47.5;9.4
+STATUS:TENTATIVE
+END:VEVENT
+BEGIN:VEVENT
+UID:holiday@example.com
+DTSTAMP:20310101T000000Z
+DTSTART;VALUE=DATE:20311225
+DTEND;VALUE=DATE:20311226
+SUMMARY:Christmas holiday
+END:VEVENT
+BEGIN:VEVENT
+UID:lunch@example.com
+DTSTAMP:20310101T000000Z
+DTSTART:20310301T120000Z
+SUMMARY:Lunch
+END:VEVENT
+BEGIN:VEVENT
+UID:undated@example.com
+DTSTAMP:20310101T000000Z
+SUMMARY:Undated meeting
+END:VEVENT
+BEGIN:VEVENT
+UID:holiday@example.com
+DTSTAMP:20310101T000000Z
+DTSTART;VALUE=DATE:20311226
+SUMMARY:Second holiday
+END:VEVENT
+BEGIN:VEVENT
+UID:orphan@example.com
+DTSTAMP:20310101T000000Z
+RECURRENCE-ID:20310301T120000Z
+DTSTART:20310301T130000Z
+SUMMARY:Orphaned occurrence
+END:VEVENT
+END:VCALENDAR
+`
+
+func TestImportEvents(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+
+	user := models.User{Email: "import@example.com", Password: "123456789"}
+	connection.Save(&user)
+
+	upload := func(calendar string) (*httptest.ResponseRecorder, handlers.ImportReport) {
+		body := bytes.Buffer{}
+		form := multipart.NewWriter(&body)
+		file, _ := form.CreateFormFile("file", "calendar.ics")
+		file.Write([]byte(strings.ReplaceAll(calendar, "\n", "\r\n")))
+		form.Close()
+
+		r, _ := http.NewRequest(http.MethodPost, "/events/import", &body)
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		rw := httptest.NewRecorder()
+		handlers.ImportEvents(connection).ServeHTTP(rw, authenticate(r, user, ""))
+
+		report := handlers.ImportReport{}
+		json.NewDecoder(rw.Body).Decode(&report)
+		return rw, report
+	}
+
+	rw, report := upload(importedCalendar)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+	if report.Created != 3 || report.Updated != 0 || report.Skipped != 4 || len(report.Items) != 7 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+
+	reasons := map[string]string{}
+	for _, item := range report.Items {
+		if item.Status == handlers.ImportSkipped {
+			reasons[item.UID] += item.Reason
+		}
+	}
+	if !strings.Contains(reasons["lunch@example.com"], "Name: min") ||
+		!strings.Contains(reasons["undated@example.com"], "DTSTART") ||
+		!strings.Contains(reasons["holiday@example.com"], "repeated") ||
+		!strings.Contains(reasons["orphan@example.com"], "recurring event") {
+		t.Errorf("Unexpected reasons %v", reasons)
+	}
+
+	standup := models.Event{}
+	connection.Preload("Attachments").Where("user_id = ? AND uid = ?", user.ID, "standup@example.com").First(&standup)
+	if standup.Name != "Daily standup, team A" || standup.TimeZone != "W. Europe Standard Time" && standup.TimeZone != "Europe/Berlin" {
+		t.Errorf("Unexpected event %+v", standup)
+	}
+	if !strings.Contains(standup.Description, "Line two with a folded tail that goes on and on and on until it ends") {
+		t.Errorf("Unexpected description %q", standup.Description)
+	}
+	if standup.StartsAt.UTC().Format(time.RFC3339) != "2031-03-03T08:30:00Z" || standup.EndsAt.Sub(*standup.StartsAt) != 15*time.Minute {
+		t.Errorf("Unexpected times %v %v", standup.StartsAt, standup.EndsAt)
+	}
+	if standup.RecurrenceRule != "FREQ=DAILY;COUNT=10;BYDAY=MO,TU,WE,TH,FR" || standup.ExceptionDates != "20310304T083000Z" {
+		t.Errorf("Unexpected recurrence %q %q", standup.RecurrenceRule, standup.ExceptionDates)
+	}
+	if len(standup.Attachments) != 1 || standup.Attachments[0].URL != "https://example.com/agenda.pdf" {
+		t.Errorf("Unexpected attachments %+v", standup.Attachments)
+	}
+
+	exceptions := []models.EventException{}
+	connection.Where("event_id = ?", standup.ID).Find(&exceptions)
+	if len(exceptions) != 1 || exceptions[0].StartsAt == nil || exceptions[0].StartsAt.UTC().Format(time.RFC3339) != "2031-03-05T10:00:00Z" || exceptions[0].Name != "" {
+		t.Errorf("Unexpected exceptions %+v", exceptions)
+	}
+
+	// the VTIMEZONE of "Office Time" puts July in daylight saving time
+	offsite := models.Event{}
+	connection.Where("user_id = ? AND uid = ?", user.ID, "offsite@example.com").First(&offsite)
+	if offsite.StartsAt.UTC().Format(time.RFC3339) != "2031-07-10T07:00:00Z" || offsite.Status != models.EventDraft || offsite.Venue != "Lakeside hotel" || offsite.Latitude == nil {
+		t.Errorf("Unexpected event %+v", offsite)
+	}
+
+	holiday := models.Event{}
+	connection.Where("user_id = ? AND uid = ?", user.ID, "holiday@example.com").First(&holiday)
+	if !holiday.AllDay || holiday.Name != "Christmas holiday" {
+		t.Errorf("Unexpected event %+v", holiday)
+	}
+
+	// importing the calendar again changes nothing, a changed event is updated
+	_, report = upload(importedCalendar)
+	if report.Created != 0 || report.Updated != 0 || report.Skipped != 7 {
+		t.Errorf("Unexpected report of the second import %+v", report)
+	}
+
+	_, report = upload(strings.Replace(importedCalendar, "SUMMARY:Summer offsite", "SUMMARY:Summer offsite moved", 1))
+	if report.Updated != 1 || report.Created != 0 {
+		t.Errorf("Unexpected report of the changed import %+v", report)
+	}
+
+	count := int64(0)
+	connection.Model(&models.Event{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 3 {
+		t.Errorf("Unexpected event count %d", count)
+	}
+
+	if rw, _ := upload("BEGIN:VCALENDAR\nBEGIN:VEVENT\n"); rw.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Unexpected status code for a broken file. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+	}
+}
@@ -0,0 +1,181 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers"
+	"site/http/handlers/auth"
+	"site/http/pagination"
+	"site/mailer"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestInvitations(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	tokenService := newTokenService(t)
+	mails := mailer.NewInMemoryMailer()
+
+	organizer := models.User{Email: "invitations-organizer@example.com", Password: "123456789"}
+	connection.Save(&organizer)
+	stranger := models.User{Email: "invitations-stranger@example.com", Password: "123456789"}
+	connection.Save(&stranger)
+	verifiedAt := time.Now()
+	member := models.User{Email: "invitations-member@example.com", Password: "123456789", EmailVerifiedAt: &verifiedAt}
+	connection.Save(&member)
+
+	event := models.Event{Name: "Invite only dinner", UserID: organizer.ID, Capacity: 1, Status: models.EventPublished}
+	connection.Save(&event)
+	target := "/event/" + strconv.Itoa(int(event.ID)) + "/invitations"
+
+	invite := func(user models.User, emails ...string) (*httptest.ResponseRecorder, handlers.InviteReport) {
+		rw := httptest.NewRecorder()
+		r := jsonRequest(t, http.MethodPost, target, map[string]interface{}{"emails": emails})
+		handlers.Invitations(connection, tokenService, mails).ServeHTTP(rw, authenticate(r, user, ""))
+
+		report := handlers.InviteReport{}
+		json.NewDecoder(rw.Body).Decode(&report)
+		return rw, report
+	}
+
+	answer := func(status string, token string) (*httptest.ResponseRecorder, handlers.InvitationAnswerResponse) {
+		r, _ := http.NewRequest(http.MethodPost, "/invitations/"+status+"?token="+url.QueryEscape(token), nil)
+		rw := httptest.NewRecorder()
+		handlers.AnswerInvitation(connection, tokenService, mails, status).ServeHTTP(rw, r)
+
+		response := handlers.InvitationAnswerResponse{}
+		json.NewDecoder(rw.Body).Decode(&response)
+		return rw, response
+	}
+
+	if rw, _ := invite(stranger, "invitations-guest@example.com"); rw.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code for another user. Received %d; Expected %d", rw.Code, http.StatusForbidden)
+	}
+	if rw, _ := invite(organizer, "not an email"); rw.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Unexpected status code for an invalid email. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+	}
+
+	rw, report := invite(organizer, "Invitations-Member@Example.com", "invitations-newcomer@example.com", "invitations-member@example.com")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+	if report.Invited != 2 || report.Skipped != 1 || report.Items[0].Email != member.Email {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	memberToken := tokenFromMessage(t, mails.Sent(member.Email)[0])
+	newcomerToken := tokenFromMessage(t, mails.Sent("invitations-newcomer@example.com")[0])
+
+	// following the link only asks for a confirmation
+	for _, status := range []string{models.InvitationDeclined, models.InvitationAccepted} {
+		r, _ := http.NewRequest(http.MethodGet, "/invitations/"+status+"?token="+url.QueryEscape(memberToken), nil)
+		rw := httptest.NewRecorder()
+		handlers.AnswerInvitation(connection, tokenService, mails, status).ServeHTTP(rw, r)
+
+		confirmation := handlers.InvitationConfirmation{}
+		json.NewDecoder(rw.Body).Decode(&confirmation)
+		if rw.Code != http.StatusOK || confirmation.Answer != status || confirmation.Status != models.InvitationPending || confirmation.Token != memberToken {
+			t.Errorf("Unexpected confirmation %d %+v", rw.Code, confirmation)
+		}
+	}
+	pending := models.Invitation{}
+	connection.Where("event_id = ? AND email = ?", event.ID, member.Email).First(&pending)
+	var answered int64
+	connection.Model(&models.Attendee{}).Where("event_id = ? AND user_id = ?", event.ID, member.ID).Count(&answered)
+	if pending.Status != models.InvitationPending || answered != 0 {
+		t.Errorf("Following the link has answered the invitation %+v", pending)
+	}
+
+	// an invitee with an account gets an RSVP right away
+	rw, response := answer(models.InvitationAccepted, memberToken)
+	if rw.Code != http.StatusOK || response.Status != models.InvitationAccepted || response.Attendance != models.AttendeeGoing || response.EventName != event.Name {
+		t.Fatalf("Unexpected answer %d %+v", rw.Code, response)
+	}
+
+	// the event is private, the invitation lets the invitee manage the answer
+	rw = httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/event/"+strconv.Itoa(int(event.ID))+"/rsvp", nil)
+	handlers.RSVP(connection, mails).ServeHTTP(rw, authenticate(r, member, ""))
+	if rw.Code != http.StatusOK {
+		t.Errorf("Unexpected status code for the RSVP of an invitee. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+
+	// the others when they register, the only seat is taken by then
+	if _, response = answer(models.InvitationAccepted, newcomerToken); response.Status != models.InvitationAccepted || response.Attendance != "" {
+		t.Errorf("Unexpected answer %+v", response)
+	}
+
+	rw = httptest.NewRecorder()
+	auth.Register(connection, tokenService, mails, newPasswordChecker()).ServeHTTP(rw, jsonRequest(t, http.MethodPost, "/register", auth.PossibleUser{
+		Name:                 "invitations-newcomer@example.com",
+		Password:             "a-long-password",
+		PasswordConfirmation: "a-long-password",
+	}))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code for the registration. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+
+	newcomer := models.User{}
+	connection.Where("email = ?", "invitations-newcomer@example.com").First(&newcomer)
+	attendee := models.Attendee{}
+	connection.Where("event_id = ? AND user_id = ?", event.ID, newcomer.ID).Limit(1).Find(&attendee)
+	if attendee.ID != 0 {
+		t.Fatalf("An unverified account has taken over the invitation %+v", attendee)
+	}
+	if _, response = answer(models.InvitationAccepted, newcomerToken); response.Attendance != "" {
+		t.Errorf("An unverified account has been given an RSVP %+v", response)
+	}
+
+	rw = httptest.NewRecorder()
+	auth.VerifyEmail(connection, tokenService, mails).ServeHTTP(rw, jsonRequest(t, http.MethodPost, "/verify-email", auth.VerifyEmailRequest{
+		Token: tokenFromMessage(t, mails.Sent(newcomer.Email)[1]),
+	}))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code for the verification. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+	connection.Where("event_id = ? AND user_id = ?", event.ID, newcomer.ID).Limit(1).Find(&attendee)
+	if attendee.Status != models.AttendeeWaitlisted {
+		t.Errorf("The accepted invitation has not been turned into an RSVP %+v", attendee)
+	}
+
+	// the same link declines, the seat goes to the waitlist
+	if _, response = answer(models.InvitationDeclined, memberToken); response.Status != models.InvitationDeclined || response.Attendance != models.AttendeeDeclined {
+		t.Errorf("Unexpected answer %+v", response)
+	}
+	connection.First(&attendee, attendee.ID)
+	if attendee.Status != models.AttendeeGoing || len(mails.Sent(newcomer.Email)) != 3 {
+		t.Errorf("The waitlist has not been promoted %+v", attendee)
+	}
+
+	if _, report = invite(organizer, member.Email); report.Skipped != 1 {
+		t.Errorf("An answered invitation has been sent again %+v", report)
+	}
+
+	// sending a pending invitation again replaces its link
+	invite(organizer, "invitations-late@example.com")
+	_, report = invite(organizer, "invitations-late@example.com")
+	if report.Resent != 1 || len(mails.Sent("invitations-late@example.com")) != 2 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if rw, _ := answer(models.InvitationAccepted, tokenFromMessage(t, mails.Sent("invitations-late@example.com")[0])); rw.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Unexpected status code for a replaced link. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+	}
+	if rw, _ := answer(models.InvitationAccepted, "invalid"); rw.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Unexpected status code for an invalid link. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, target+"?status=accepted", nil)
+	rw = httptest.NewRecorder()
+	handlers.Invitations(connection, tokenService, mails).ServeHTTP(rw, authenticate(r, organizer, ""))
+	invitations := []handlers.InvitationResponse{}
+	json.NewDecoder(rw.Body).Decode(&pagination.Page{Data: &invitations})
+	if len(invitations) != 1 || invitations[0].Email != newcomer.Email || invitations[0].UserID == nil || *invitations[0].UserID != newcomer.ID {
+		t.Errorf("Unexpected invitations %+v", invitations)
+	}
+}
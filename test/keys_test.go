@@ -0,0 +1,136 @@
+package test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"site/database/models"
+	"site/http/handlers/auth"
+	"site/security"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func rsaKeyPEM(t *testing.T) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Can not generate a rsa key %s", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+}
+
+func ed25519KeyPEM(t *testing.T) string {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Can not generate an ed25519 key %s", err)
+	}
+
+	encoded, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Can not encode an ed25519 key %s", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: encoded}))
+}
+
+func TestTokenServiceKeys(t *testing.T) {
+	user := models.User{Model: gorm.Model{ID: 42}}
+
+	t.Run("it_rejects_short_hmac_secrets", func(t *testing.T) {
+		_, err := security.NewTokenService(security.Config{
+			SigningKeyID: "short",
+			Keys:         []security.KeyConfig{{ID: "short", Algorithm: security.AlgorithmHS256, Secret: "secret"}},
+		})
+
+		if err == nil {
+			t.Error("A short HMAC secret has been accepted")
+		}
+	})
+
+	t.Run("it_signs_with_asymmetric_algorithms", func(t *testing.T) {
+		for _, key := range []security.KeyConfig{
+			{ID: "rsa", Algorithm: security.AlgorithmRS256, PrivateKeyPEM: rsaKeyPEM(t)},
+			{ID: "ed", Algorithm: security.AlgorithmEdDSA, PrivateKeyPEM: ed25519KeyPEM(t)},
+		} {
+			tokenService, err := security.NewTokenService(security.Config{SigningKeyID: key.ID, Keys: []security.KeyConfig{key}})
+			if err != nil {
+				t.Fatalf("Can not create a %s token service %s", key.Algorithm, err)
+			}
+
+			token, err := tokenService.CreateToken(&user)
+			if err != nil {
+				t.Errorf("Can not sign a %s token %s", key.Algorithm, err)
+			}
+
+			identifier, err := tokenService.GetIdentifier(token)
+			if err != nil || identifier != user.ID {
+				t.Errorf("Can not verify a %s token %s", key.Algorithm, err)
+			}
+		}
+	})
+
+	t.Run("it_verifies_tokens_of_rotated_keys", func(t *testing.T) {
+		oldKey := security.KeyConfig{ID: "old", Algorithm: security.AlgorithmRS256, PrivateKeyPEM: rsaKeyPEM(t)}
+		newKey := security.KeyConfig{ID: "new", Algorithm: security.AlgorithmEdDSA, PrivateKeyPEM: ed25519KeyPEM(t)}
+
+		before, _ := security.NewTokenService(security.Config{SigningKeyID: "old", Keys: []security.KeyConfig{oldKey}})
+		after, err := security.NewTokenService(security.Config{SigningKeyID: "new", Keys: []security.KeyConfig{oldKey, newKey}})
+		if err != nil {
+			t.Fatalf("Can not create the token service %s", err)
+		}
+
+		oldToken, _ := before.CreateToken(&user)
+		if !after.IsValid(oldToken) {
+			t.Error("Tokens signed with the previous key are rejected")
+		}
+
+		newToken, _ := after.CreateToken(&user)
+		if before.IsValid(newToken) {
+			t.Error("Tokens signed with an unknown key are accepted")
+		}
+
+		if !after.IsValid(newToken) {
+			t.Error("Tokens signed with the new key are rejected")
+		}
+	})
+
+	t.Run("it_publishes_public_keys_only", func(t *testing.T) {
+		tokenService, err := security.NewTokenService(security.Config{
+			SigningKeyID: "rsa",
+			Keys: []security.KeyConfig{
+				{ID: "hmac", Algorithm: security.AlgorithmHS256, Secret: testSecret},
+				{ID: "rsa", Algorithm: security.AlgorithmRS256, PrivateKeyPEM: rsaKeyPEM(t)},
+				{ID: "ed", Algorithm: security.AlgorithmEdDSA, PrivateKeyPEM: ed25519KeyPEM(t)},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Can not create the token service %s", err)
+		}
+
+		r, _ := http.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+		rw := httptest.NewRecorder()
+		auth.JWKS(tokenService).ServeHTTP(rw, r)
+
+		if rw.Code != http.StatusOK {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+
+		set := security.JSONWebKeySet{}
+		json.NewDecoder(rw.Body).Decode(&set)
+
+		if len(set.Keys) != 2 {
+			t.Fatalf("Unexpected number of published keys %d", len(set.Keys))
+		}
+
+		if set.Keys[0].KeyID != "rsa" || set.Keys[0].N == "" || set.Keys[1].KeyID != "ed" || set.Keys[1].X == "" {
+			t.Errorf("Unexpected key set %+v", set)
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"site/audit"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers/admin"
+	"site/http/handlers/auth"
+	"site/lockout"
+	"site/security"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginLockout(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	tokenService := newTokenService(t)
+
+	email := "lockout@example.com"
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("correct-password"), bcrypt.MinCost)
+	verifiedAt := time.Now()
+	user := models.User{Email: email, Password: string(hashedPassword), EmailVerifiedAt: &verifiedAt}
+	connection.Save(&user)
+
+	login := func(guard lockout.LoginGuard, password string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		r := jsonRequest(t, http.MethodPost, "/login", auth.UserLogin{Name: email, Password: password})
+		r.RemoteAddr = "192.0.2.1:1234"
+		auth.Login(connection, tokenService, security.NewRefreshTokenService(connection), guard).ServeHTTP(rw, r)
+		return rw
+	}
+
+	t.Run("it_backs_off_after_a_failure", func(t *testing.T) {
+		guard := newLoginGuard()
+
+		if rw := login(guard, "wrong"); rw.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+		}
+
+		rw := login(guard, "correct-password")
+		if rw.Code != http.StatusTooManyRequests || rw.Header().Get("Retry-After") != "1" {
+			t.Errorf("Unexpected response. Received %d, Retry-After %s", rw.Code, rw.Header().Get("Retry-After"))
+		}
+	})
+
+	t.Run("it_locks_the_account_and_an_admin_can_unlock_it", func(t *testing.T) {
+		logger := &recordingLogger{}
+		guard := lockout.NewGuard(lockout.NewGormStore(connection), logger)
+		guard.Account.BaseDelay = 0
+
+		for i := 0; i < guard.Account.MaxFailures; i++ {
+			login(guard, "wrong")
+		}
+
+		rw := login(guard, "correct-password")
+		if rw.Code != http.StatusTooManyRequests {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusTooManyRequests)
+		}
+
+		retryAfter, _ := strconv.Atoi(rw.Header().Get("Retry-After"))
+		if retryAfter < 60 {
+			t.Errorf("Unexpected Retry-After %d", retryAfter)
+		}
+
+		if len(logger.entries) != 1 || logger.entries[0].Action != audit.ActionAccountLocked {
+			t.Errorf("The lockout has not been audited %+v", logger.entries)
+		}
+
+		r, _ := http.NewRequest(http.MethodPost, "/admin/users/"+strconv.Itoa(int(user.ID))+"/unlock", nil)
+		r = mux.SetURLVars(r, map[string]string{"user": strconv.Itoa(int(user.ID))})
+		r = authenticate(r, models.User{Role: models.RoleAdmin}, "")
+		unlock := httptest.NewRecorder()
+		admin.UnlockUser(connection, guard).ServeHTTP(unlock, r)
+
+		if unlock.Code != http.StatusNoContent {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", unlock.Code, http.StatusNoContent)
+		}
+
+		if rw := login(guard, "correct-password"); rw.Code != http.StatusOK {
+			t.Errorf("The account is still locked. Received %d", rw.Code)
+		}
+
+		if logger.entries[len(logger.entries)-1].Action != audit.ActionAccountUnlocked {
+			t.Error("The unlock has not been audited")
+		}
+	})
+
+	t.Run("it_counts_parallel_guesses", func(t *testing.T) {
+		for name, store := range map[string]lockout.Store{
+			"memory": lockout.NewMemoryStore(),
+			"gorm":   lockout.NewGormStore(connection),
+		} {
+			logger := &recordingLogger{}
+			guard := lockout.NewGuard(store, logger)
+			guard.Account.BaseDelay = 0
+			guard.Unlock(email, nil)
+			logger.entries = nil
+
+			codes := make(chan int, 4*guard.Account.MaxFailures)
+			wg := sync.WaitGroup{}
+			for i := 0; i < cap(codes); i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					codes <- login(guard, "wrong").Code
+				}()
+			}
+			wg.Wait()
+			close(codes)
+
+			evaluated := 0
+			for code := range codes {
+				switch code {
+				case http.StatusUnprocessableEntity:
+					evaluated++
+				case http.StatusTooManyRequests:
+				default:
+					t.Errorf("Unexpected status code with the %s store. Received %d", name, code)
+				}
+			}
+			if evaluated != guard.Account.MaxFailures {
+				t.Errorf("Unexpected number of evaluated guesses with the %s store. Received %d; Expected %d", name, evaluated, guard.Account.MaxFailures)
+			}
+			if len(logger.entries) != 1 {
+				t.Errorf("Unexpected lockout audits with the %s store %+v", name, logger.entries)
+			}
+			guard.Unlock(email, nil)
+		}
+	})
+}
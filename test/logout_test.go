@@ -0,0 +1,90 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers/auth"
+	"site/security"
+	"strings"
+	"testing"
+)
+
+func TestLogoutHandler(t *testing.T) {
+	t.Run("it_revokes_the_access_token", func(t *testing.T) {
+		connection, _ := database.NewTestDatabaseConnection()
+		database.RunMigrations(connection)
+		tokenService := newTokenService(t)
+		tokenService.SetRevocationStore(security.NewGormRevocationStore(connection))
+
+		user := models.User{Email: "logout@example.com", Password: "123456789"}
+		connection.Save(&user)
+
+		token, _ := tokenService.CreateToken(&user)
+		other, _ := tokenService.CreateToken(&user)
+
+		r, err := http.NewRequest(http.MethodPost, "/logout", strings.NewReader("{}"))
+		if err != nil {
+			t.Errorf("Can not create a request %s", err)
+		}
+		r = authenticate(r, user, token)
+		rw := httptest.NewRecorder()
+
+		auth.Logout(tokenService, security.NewRefreshTokenService(connection)).ServeHTTP(rw, r)
+
+		if rw.Code != http.StatusNoContent {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusNoContent)
+		}
+
+		if tokenService.IsValid(token) {
+			t.Error("The token is still valid after logout")
+		}
+
+		if !tokenService.IsValid(other) {
+			t.Error("Tokens of other sessions have been revoked")
+		}
+	})
+
+	t.Run("it_revokes_all_tokens_of_the_user", func(t *testing.T) {
+		connection, _ := database.NewTestDatabaseConnection()
+		database.RunMigrations(connection)
+		tokenService := newTokenService(t)
+		tokenService.SetRevocationStore(security.NewGormRevocationStore(connection))
+		refreshTokens := security.NewRefreshTokenService(connection)
+
+		user := models.User{Email: "logout-all@example.com", Password: "123456789"}
+		connection.Save(&user)
+
+		token, _ := tokenService.CreateToken(&user)
+		other, _ := tokenService.CreateToken(&user)
+		refreshToken, _ := refreshTokens.Issue(&user)
+
+		r, err := http.NewRequest(http.MethodPost, "/logout/all", nil)
+		if err != nil {
+			t.Errorf("Can not create a request %s", err)
+		}
+		r = authenticate(r, user, token)
+		rw := httptest.NewRecorder()
+
+		auth.LogoutAll(tokenService, refreshTokens).ServeHTTP(rw, r)
+
+		if rw.Code != http.StatusNoContent {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusNoContent)
+		}
+
+		if tokenService.IsValid(token) || tokenService.IsValid(other) {
+			t.Error("Tokens are still valid after logging out all sessions")
+		}
+
+		if _, _, err := refreshTokens.Rotate(refreshToken); err == nil {
+			t.Error("The refresh token is still valid after logging out all sessions")
+		}
+
+		connection.Find(&user, user.ID)
+		fresh, _ := tokenService.CreateToken(&user)
+		if !tokenService.IsValid(fresh) {
+			t.Error("Tokens issued after logging out are rejected")
+		}
+	})
+}
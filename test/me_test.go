@@ -0,0 +1,219 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers"
+	"site/http/handlers/auth"
+	"site/mailer"
+	"site/security"
+	"site/uploader"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMe(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	tokenService := newTokenService(t)
+	tokenService.SetRevocationStore(security.NewGormRevocationStore(connection))
+	refreshTokens := security.NewRefreshTokenService(connection)
+	mails := mailer.NewInMemoryMailer()
+	files := uploader.NewLocalUploader()
+	me := auth.Me(connection, tokenService, refreshTokens, mails, files, newPasswordChecker())
+
+	newUser := func(email string) models.User {
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("old-password"), bcrypt.MinCost)
+		verifiedAt := time.Now()
+		user := models.User{Email: email, Password: string(hashedPassword), EmailVerifiedAt: &verifiedAt}
+		connection.Save(&user)
+		return user
+	}
+
+	call := func(method string, user models.User, body interface{}) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		me.ServeHTTP(rw, authenticate(jsonRequest(t, method, "/me", body), user, ""))
+		return rw
+	}
+
+	t.Run("it_shows_the_account_without_secrets", func(t *testing.T) {
+		user := newUser("me-show@example.com")
+
+		rw := call(http.MethodGet, user, nil)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+
+		if strings.Contains(rw.Body.String(), user.Password) || strings.Contains(strings.ToLower(rw.Body.String()), "password\":\"") {
+			t.Errorf("The response contains the password hash: %s", rw.Body.String())
+		}
+
+		response := auth.UserResponse{}
+		json.NewDecoder(rw.Body).Decode(&response)
+		if response.ID != user.ID || response.Email != user.Email || !response.EmailVerified {
+			t.Errorf("Unexpected response %+v", response)
+		}
+	})
+
+	t.Run("it_changes_the_password", func(t *testing.T) {
+		user := newUser("me-password@example.com")
+		refreshToken, _ := refreshTokens.Issue(&user)
+
+		rw := call(http.MethodPatch, user, auth.UpdateProfileRequest{
+			Password:             "another-password",
+			PasswordConfirmation: "another-password",
+			CurrentPassword:      "wrong-password",
+		})
+		if rw.Code != http.StatusForbidden {
+			t.Errorf("Unexpected status code for a wrong password. Received %d; Expected %d", rw.Code, http.StatusForbidden)
+		}
+
+		rw = call(http.MethodPatch, user, auth.UpdateProfileRequest{
+			Password:             "short",
+			PasswordConfirmation: "short",
+			CurrentPassword:      "old-password",
+		})
+		if rw.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Unexpected status code for a weak password. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+		}
+
+		rw = call(http.MethodPatch, user, auth.UpdateProfileRequest{
+			Password:             "another-password",
+			PasswordConfirmation: "another-password",
+			CurrentPassword:      "old-password",
+		})
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+
+		stored := models.User{}
+		connection.First(&stored, user.ID)
+		if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("another-password")) != nil {
+			t.Error("The password has not been changed")
+		}
+
+		if _, _, err := refreshTokens.Rotate(refreshToken); err == nil {
+			t.Error("The sessions survived a password change")
+		}
+	})
+
+	t.Run("it_changes_the_email_after_verification", func(t *testing.T) {
+		user := newUser("me-email@example.com")
+		taken := newUser("me-email-taken@example.com")
+
+		rw := call(http.MethodPatch, user, auth.UpdateProfileRequest{Email: taken.Email, CurrentPassword: "old-password"})
+		if rw.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Unexpected status code for a taken email. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+		}
+
+		rw = call(http.MethodPatch, user, auth.UpdateProfileRequest{Email: "me-email-new@example.com", CurrentPassword: "old-password"})
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+
+		response := auth.UserResponse{}
+		json.NewDecoder(rw.Body).Decode(&response)
+		if response.Email != user.Email || response.PendingEmail != "me-email-new@example.com" {
+			t.Errorf("Unexpected response %+v", response)
+		}
+
+		sent := mails.Sent("me-email-new@example.com")
+		if len(sent) != 1 {
+			t.Fatalf("Expected one verification email, %d sent", len(sent))
+		}
+
+		rw = httptest.NewRecorder()
+		auth.VerifyEmail(connection, tokenService, mails).ServeHTTP(rw, jsonRequest(t, http.MethodPost, "/verify-email", auth.VerifyEmailRequest{Token: tokenFromMessage(t, sent[0])}))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+
+		stored := models.User{}
+		connection.First(&stored, user.ID)
+		if stored.Email != "me-email-new@example.com" {
+			t.Errorf("The email has not been changed: %s", stored.Email)
+		}
+	})
+
+	t.Run("it_deletes_the_account", func(t *testing.T) {
+		user := newUser("me-delete@example.com")
+		event := models.Event{Name: "Deleted with the account", UserID: user.ID}
+		connection.Save(&event)
+		path, _ := files.Upload("photo.jpg", t.TempDir()+"/", strings.NewReader("image"))
+		media := models.Media{Name: "photo.jpg", Path: path, EventId: event.ID}
+		connection.Save(&media)
+		connection.Save(&models.Invitation{EventID: event.ID + 1000, Email: user.Email, TokenID: "me-delete-pending"})
+		connection.Save(&models.Invitation{EventID: event.ID + 1001, Email: "me-delete-old@example.com", UserID: &user.ID, TokenID: "me-delete-accepted"})
+		accessToken, _ := tokenService.CreateToken(&user)
+
+		rw := call(http.MethodDelete, user, auth.DeleteAccountRequest{CurrentPassword: "old-password"})
+		if rw.Code != http.StatusNoContent {
+			t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusNoContent)
+		}
+
+		var count int64
+		connection.Model(&models.User{}).Where("email = ?", user.Email).Count(&count)
+		if count != 0 {
+			t.Error("The account still exists")
+		}
+
+		connection.Unscoped().Model(&models.User{}).Where("email = ?", user.Email).Count(&count)
+		if count != 0 {
+			t.Error("The deleted account keeps the email address")
+		}
+
+		connection.Model(&models.Event{}).Where("id = ?", event.ID).Count(&count)
+		if count != 0 {
+			t.Error("The events of the account still exist")
+		}
+
+		connection.Model(&models.Media{}).Where("id = ?", media.ID).Count(&count)
+		if count != 0 {
+			t.Error("The media of the account still exists")
+		}
+
+		if file, err := files.Open(path); err == nil {
+			file.Close()
+			t.Error("The media file of the account still exists")
+		}
+
+		connection.Unscoped().Model(&models.Invitation{}).Where("user_id = ? OR email = ?", user.ID, user.Email).Count(&count)
+		if count != 0 {
+			t.Error("The invitations of the account still exist")
+		}
+
+		if tokenService.IsValid(accessToken) {
+			t.Error("The access token is still valid")
+		}
+
+		// admins can not bring back the events without their media
+		id := strconv.Itoa(int(event.ID))
+		r, _ := http.NewRequest(http.MethodPost, "/event/"+id+"/restore", nil)
+		r = mux.SetURLVars(authenticate(r, models.User{Role: models.RoleAdmin}, ""), map[string]string{"event": id})
+		rw = httptest.NewRecorder()
+		handlers.RestoreEvent(connection).ServeHTTP(rw, r)
+		if rw.Code != http.StatusConflict {
+			t.Errorf("Unexpected status code for restoring an event of the account. Received %d; Expected %d", rw.Code, http.StatusConflict)
+		}
+	})
+
+	t.Run("it_deletes_accounts_without_a_password_without_a_body", func(t *testing.T) {
+		user := models.User{Email: "me-delete-passwordless@example.com"}
+		connection.Save(&user)
+
+		r, _ := http.NewRequest(http.MethodDelete, "/me", http.NoBody)
+		rw := httptest.NewRecorder()
+		me.ServeHTTP(rw, authenticate(r, user, ""))
+		if rw.Code != http.StatusNoContent {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusNoContent)
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers/auth"
+	"site/security"
+	"site/security/totp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B, truncated to six digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	for seconds, expected := range map[int64]string{59: "287082", 1111111109: "081804", 2000000000: "279037"} {
+		code, err := totp.Code(secret, totp.Step(time.Unix(seconds, 0)))
+		if err != nil || code != expected {
+			t.Errorf("Unexpected code at %d. Received %s; Expected %s", seconds, code, expected)
+		}
+	}
+
+	if _, ok := totp.Validate(secret, "287082", time.Unix(89, 0)); !ok {
+		t.Error("Codes of the previous period are rejected")
+	}
+
+	if _, ok := totp.Validate(secret, "287082", time.Unix(150, 0)); ok {
+		t.Error("Outdated codes are accepted")
+	}
+
+	uri := totp.ProvisioningURI(secret, "site", "user@example.com")
+	if !strings.HasPrefix(uri, "otpauth://totp/site:user@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("Unexpected provisioning uri %s", uri)
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	tokenService := newTokenService(t)
+	refreshTokens := security.NewRefreshTokenService(connection)
+	guard := newLoginGuard()
+	guard.Account.BaseDelay = 0
+
+	email := "mfa@example.com"
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	verifiedAt := time.Now()
+	user := models.User{Email: email, Password: string(hashedPassword), EmailVerifiedAt: &verifiedAt}
+	connection.Save(&user)
+
+	current := func() models.User {
+		stored := models.User{}
+		connection.Find(&stored, user.ID)
+		return stored
+	}
+
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodPost, "/mfa/totp/enroll", nil)
+	auth.EnrollTOTP(connection).ServeHTTP(rw, authenticate(r, current(), ""))
+	enrollment := auth.TOTPEnrollmentResponse{}
+	json.NewDecoder(rw.Body).Decode(&enrollment)
+	if rw.Code != http.StatusOK || enrollment.Secret == "" || !strings.HasPrefix(enrollment.ProvisioningURI, "otpauth://totp/") {
+		t.Fatalf("Unexpected enrollment %d %+v", rw.Code, enrollment)
+	}
+
+	code, _ := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+
+	rw = httptest.NewRecorder()
+	auth.ConfirmTOTP(connection).ServeHTTP(rw, authenticate(jsonRequest(t, http.MethodPost, "/mfa/totp/confirm", auth.TOTPConfirmRequest{Code: code}), current(), ""))
+	recovery := auth.RecoveryCodesResponse{}
+	json.NewDecoder(rw.Body).Decode(&recovery)
+	if rw.Code != http.StatusOK || len(recovery.RecoveryCodes) != auth.RecoveryCodeCount {
+		t.Fatalf("Unexpected confirmation %d %+v", rw.Code, recovery)
+	}
+
+	stored := models.RecoveryCode{}
+	connection.Where("user_id = ?", user.ID).First(&stored)
+	if stored.CodeHash == recovery.RecoveryCodes[0] {
+		t.Error("Recovery codes are stored in plain text")
+	}
+
+	login := func() auth.MFAChallengeResponse {
+		rw := httptest.NewRecorder()
+		auth.Login(connection, tokenService, refreshTokens, guard).ServeHTTP(rw, jsonRequest(t, http.MethodPost, "/login", auth.UserLogin{Name: email, Password: "password"}))
+
+		challenge := auth.MFAChallengeResponse{}
+		json.NewDecoder(rw.Body).Decode(&challenge)
+		return challenge
+	}
+
+	second := func(request auth.MFALoginRequest) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		auth.LoginMFA(connection, tokenService, refreshTokens, guard).ServeHTTP(rw, jsonRequest(t, http.MethodPost, "/login/mfa", request))
+		return rw
+	}
+
+	t.Run("login_requires_the_second_factor", func(t *testing.T) {
+		challenge := login()
+		if !challenge.MFARequired || challenge.MFAToken == "" {
+			t.Fatalf("Unexpected login response %+v", challenge)
+		}
+
+		if tokenService.IsValid(challenge.MFAToken) {
+			t.Error("The pending token is accepted as an access token")
+		}
+	})
+
+	t.Run("totp_codes_can_not_be_replayed", func(t *testing.T) {
+		rw := second(auth.MFALoginRequest{MFAToken: login().MFAToken, Code: code})
+		if rw.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("recovery_codes_work_once", func(t *testing.T) {
+		challenge := login()
+
+		rw := second(auth.MFALoginRequest{MFAToken: challenge.MFAToken, RecoveryCode: strings.ToUpper(recovery.RecoveryCodes[0])})
+		if rw.Code != http.StatusOK {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+
+		response := auth.TokenResponse{}
+		json.NewDecoder(rw.Body).Decode(&response)
+		if !tokenService.IsValid(response.Token) {
+			t.Error("The second step does not issue an access token")
+		}
+
+		rw = second(auth.MFALoginRequest{MFAToken: challenge.MFAToken, RecoveryCode: recovery.RecoveryCodes[0]})
+		if rw.Code != http.StatusUnprocessableEntity {
+			t.Errorf("A recovery code can be used twice. Received %d", rw.Code)
+		}
+	})
+}
@@ -0,0 +1,368 @@
+package test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers/auth"
+	"site/oidc"
+	"site/security"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+)
+
+// fakeIdentity is what the fake provider asserts about the signed in user.
+type fakeIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// Nonce overrides the nonce of the authorization request when set.
+	Nonce string
+}
+
+type fakeGrant struct {
+	identity      fakeIdentity
+	nonce         string
+	codeChallenge string
+}
+
+// fakeProvider is an in-process OpenID provider with a single RSA key.
+type fakeProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	mu     sync.Mutex
+	codes  map[string]fakeGrant
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Can not generate a key %s", err)
+	}
+
+	p := &fakeProvider{key: key, codes: map[string]fakeGrant{}}
+
+	router := http.NewServeMux()
+	router.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(rw).Encode(oidc.Discovery{
+			Issuer:                p.server.URL,
+			AuthorizationEndpoint: p.server.URL + "/authorize",
+			TokenEndpoint:         p.server.URL + "/token",
+			JWKSURI:               p.server.URL + "/jwks",
+		})
+	})
+	router.HandleFunc("/jwks", func(rw http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(rw).Encode(oidc.JSONWebKeySet{Keys: []oidc.JSONWebKey{{
+			KeyType:   "RSA",
+			KeyID:     "fake",
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	router.HandleFunc("/token", func(rw http.ResponseWriter, r *http.Request) {
+		clientID, secret, _ := r.BasicAuth()
+		if clientID != "client" || secret != "secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		r.ParseForm()
+		p.mu.Lock()
+		grant, ok := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		p.mu.Unlock()
+
+		if !ok || oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != grant.codeChallenge {
+			rw.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(rw).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		nonce := grant.nonce
+		if grant.identity.Nonce != "" {
+			nonce = grant.identity.Nonce
+		}
+
+		json.NewEncoder(rw).Encode(map[string]string{
+			"access_token": "unused",
+			"token_type":   "Bearer",
+			"id_token": p.sign(t, jwt.MapClaims{
+				"iss":            p.server.URL,
+				"aud":            "client",
+				"sub":            grant.identity.Subject,
+				"email":          grant.identity.Email,
+				"email_verified": grant.identity.EmailVerified,
+				"nonce":          nonce,
+				"iat":            time.Now().Unix(),
+				"exp":            time.Now().Add(time.Minute).Unix(),
+			}),
+		})
+	})
+
+	p.server = httptest.NewServer(router)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+func (p *fakeProvider) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "fake"
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		t.Fatalf("Can not sign the ID token %s", err)
+	}
+	return signed
+}
+
+// authorize plays the user signing in at the provider and returns the
+// callback URL the provider would redirect to.
+func (p *fakeProvider) authorize(t *testing.T, authorizationURL string, identity fakeIdentity) string {
+	target, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatalf("Invalid authorization URL %s", err)
+	}
+
+	query := target.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("The authorization request does not use PKCE: %s", authorizationURL)
+	}
+
+	code, _ := security.NewRandomToken(16)
+	p.mu.Lock()
+	p.codes[code] = fakeGrant{identity: identity, nonce: query.Get("nonce"), codeChallenge: query.Get("code_challenge")}
+	p.mu.Unlock()
+
+	return "/oauth/fake/callback?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+}
+
+func (p *fakeProvider) providers() oidc.Providers {
+	return oidc.NewProviders(oidc.NewProvider(oidc.ProviderConfig{
+		Name:         "fake",
+		Issuer:       p.server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/oauth/fake/callback",
+	}, p.server.Client()))
+}
+
+// bindingCookie returns the cookie that binds a sign in to the browser that
+// started it.
+func bindingCookie(t *testing.T, rw *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range rw.Result().Cookies() {
+		if cookie.Name == auth.OAuthBindingCookie {
+			if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+				t.Errorf("The binding cookie is readable by scripts or sent cross site %+v", cookie)
+			}
+			return cookie
+		}
+	}
+	t.Fatalf("No binding cookie has been set")
+	return nil
+}
+
+func TestOIDCLogin(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	tokenService := newTokenService(t)
+	refreshTokens := security.NewRefreshTokenService(connection)
+	fake := newFakeProvider(t)
+	providers := fake.providers()
+
+	router := mux.NewRouter()
+	router.Handle("/oauth/{provider}/login", auth.OIDCLogin(connection, providers))
+	router.Handle("/oauth/{provider}/callback", auth.OIDCCallback(connection, providers, tokenService, refreshTokens))
+
+	var browser *http.Cookie
+	start := func() string {
+		r, _ := http.NewRequest(http.MethodGet, "/oauth/fake/login", nil)
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, r)
+		if rw.Code != http.StatusFound {
+			t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusFound)
+		}
+		browser = bindingCookie(t, rw)
+		return rw.Header().Get("Location")
+	}
+
+	callback := func(target string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(http.MethodGet, target, nil)
+		if browser != nil {
+			r.AddCookie(browser)
+		}
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, r)
+		return rw
+	}
+
+	identity := fakeIdentity{Subject: "oidc-subject-1", Email: "oidc-login@example.com", EmailVerified: true}
+	target := fake.authorize(t, start(), identity)
+	rw := callback(target)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d: %s", rw.Code, http.StatusOK, rw.Body.String())
+	}
+
+	response := auth.TokenResponse{}
+	json.NewDecoder(rw.Body).Decode(&response)
+	id, err := tokenService.GetIdentifier(response.Token)
+	if err != nil {
+		t.Fatalf("The access token is invalid %s", err)
+	}
+
+	user := models.User{}
+	connection.First(&user, id)
+	if user.Email != identity.Email || user.EmailVerifiedAt == nil || user.Password != "" {
+		t.Errorf("Unexpected user %+v", user)
+	}
+
+	// the state is single use
+	if rw := callback(target); rw.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status code for a replayed state. Received %d; Expected %d", rw.Code, http.StatusBadRequest)
+	}
+
+	// signing in again uses the linked account
+	rw = callback(fake.authorize(t, start(), identity))
+	json.NewDecoder(rw.Body).Decode(&response)
+	if id, _ := tokenService.GetIdentifier(response.Token); id != user.ID {
+		t.Errorf("Unexpected user %d; Expected %d", id, user.ID)
+	}
+
+	// an ID token for another authorization request is rejected
+	replayed := identity
+	replayed.Nonce = "another-nonce"
+	if rw := callback(fake.authorize(t, start(), replayed)); rw.Code != http.StatusUnauthorized {
+		t.Errorf("Unexpected status code for a wrong nonce. Received %d; Expected %d", rw.Code, http.StatusUnauthorized)
+	}
+
+	// a sign in is only completed in the browser that started it
+	target = fake.authorize(t, start(), identity)
+	other := browser
+	start()
+	for _, cookie := range []*http.Cookie{nil, browser} {
+		browser = cookie
+		if rw := callback(target); rw.Code != http.StatusBadRequest {
+			t.Errorf("Unexpected status code for another browser. Received %d; Expected %d", rw.Code, http.StatusBadRequest)
+		}
+	}
+	browser = other
+	if rw := callback(target); rw.Code != http.StatusOK {
+		t.Errorf("Unexpected status code for the browser that started. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+
+	// a forged state is rejected
+	if rw := callback("/oauth/fake/callback?code=unknown&state=forged"); rw.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status code for a forged state. Received %d; Expected %d", rw.Code, http.StatusBadRequest)
+	}
+
+	// existing accounts are not taken over through a matching email
+	now := time.Now()
+	existing := models.User{Email: "oidc-existing@example.com", Password: "123456789", EmailVerifiedAt: &now}
+	connection.Save(&existing)
+	rw = callback(fake.authorize(t, start(), fakeIdentity{Subject: "oidc-subject-2", Email: existing.Email, EmailVerified: true}))
+	if rw.Code != http.StatusConflict {
+		t.Errorf("Unexpected status code for an existing email. Received %d; Expected %d", rw.Code, http.StatusConflict)
+	}
+
+	// unverified emails do not create accounts
+	rw = callback(fake.authorize(t, start(), fakeIdentity{Subject: "oidc-subject-3", Email: "oidc-unverified@example.com"}))
+	if rw.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code for an unverified email. Received %d; Expected %d", rw.Code, http.StatusForbidden)
+	}
+}
+
+func TestOIDCLink(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	tokenService := newTokenService(t)
+	refreshTokens := security.NewRefreshTokenService(connection)
+	fake := newFakeProvider(t)
+	providers := fake.providers()
+
+	now := time.Now()
+	user := models.User{Email: "oidc-link@example.com", Password: "123456789", EmailVerifiedAt: &now}
+	connection.Save(&user)
+
+	router := mux.NewRouter()
+	router.Handle("/oauth/{provider}/link", auth.OIDCLink(connection, providers))
+	router.Handle("/oauth/{provider}/callback", auth.OIDCCallback(connection, providers, tokenService, refreshTokens))
+
+	r, _ := http.NewRequest(http.MethodPost, "/oauth/fake/link", nil)
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, authenticate(r, user, ""))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+	browser := bindingCookie(t, rw)
+	link := auth.AuthorizationURLResponse{}
+	json.NewDecoder(rw.Body).Decode(&link)
+
+	// another user can not have the victim complete a link they started
+	attacker := models.User{Email: "oidc-link-attacker@example.com", Password: "123456789", EmailVerifiedAt: &now}
+	connection.Save(&attacker)
+	r, _ = http.NewRequest(http.MethodPost, "/oauth/fake/link", nil)
+	rw = httptest.NewRecorder()
+	router.ServeHTTP(rw, authenticate(r, attacker, ""))
+	planted := auth.AuthorizationURLResponse{}
+	json.NewDecoder(rw.Body).Decode(&planted)
+	r, _ = http.NewRequest(http.MethodGet, fake.authorize(t, planted.AuthorizationURL, fakeIdentity{Subject: "oidc-attacker-subject", Email: attacker.Email, EmailVerified: true}), nil)
+	r.AddCookie(browser)
+	rw = httptest.NewRecorder()
+	router.ServeHTTP(rw, r)
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status code for a link started elsewhere. Received %d; Expected %d", rw.Code, http.StatusBadRequest)
+	}
+	var count int64
+	connection.Model(&models.ExternalIdentity{}).Where("subject = ?", "oidc-attacker-subject").Count(&count)
+	if count != 0 {
+		t.Errorf("A link started by another user has been completed")
+	}
+
+	identity := fakeIdentity{Subject: "oidc-link-subject", Email: "someone-else@example.com", EmailVerified: true}
+	r, _ = http.NewRequest(http.MethodGet, fake.authorize(t, link.AuthorizationURL, identity), nil)
+	r.AddCookie(browser)
+	rw = httptest.NewRecorder()
+	router.ServeHTTP(rw, r)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d: %s", rw.Code, http.StatusOK, rw.Body.String())
+	}
+
+	linked := models.ExternalIdentity{}
+	connection.Where("provider = ? AND subject = ?", "fake", identity.Subject).Find(&linked)
+	if linked.UserID != user.ID {
+		t.Fatalf("The identity is linked to %d; Expected %d", linked.UserID, user.ID)
+	}
+
+	// the provider now logs into the linked account
+	r, _ = http.NewRequest(http.MethodGet, "/oauth/fake/login", nil)
+	rw = httptest.NewRecorder()
+	auth.OIDCLogin(connection, providers).ServeHTTP(rw, mux.SetURLVars(r, map[string]string{"provider": "fake"}))
+	r, _ = http.NewRequest(http.MethodGet, fake.authorize(t, rw.Header().Get("Location"), identity), nil)
+	r.AddCookie(bindingCookie(t, rw))
+	rw = httptest.NewRecorder()
+	router.ServeHTTP(rw, r)
+	response := auth.TokenResponse{}
+	json.NewDecoder(rw.Body).Decode(&response)
+	if id, _ := tokenService.GetIdentifier(response.Token); id != user.ID {
+		t.Errorf("Unexpected user %d; Expected %d", id, user.ID)
+	}
+
+	r, _ = http.NewRequest(http.MethodDelete, "/oauth/fake/link", nil)
+	rw = httptest.NewRecorder()
+	router.ServeHTTP(rw, authenticate(r, user, ""))
+	if rw.Code != http.StatusNoContent {
+		t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusNoContent)
+	}
+}
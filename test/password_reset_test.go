@@ -0,0 +1,105 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers/auth"
+	"site/mailer"
+	"site/security"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordReset(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	tokenService := newTokenService(t)
+	tokenService.SetRevocationStore(security.NewGormRevocationStore(connection))
+	refreshTokens := security.NewRefreshTokenService(connection)
+	mails := mailer.NewInMemoryMailer()
+
+	email := "reset@example.com"
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("old-password"), bcrypt.DefaultCost)
+	verifiedAt := time.Now()
+	user := models.User{Email: email, Password: string(hashedPassword), EmailVerifiedAt: &verifiedAt}
+	connection.Save(&user)
+
+	forgot := func(email string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		auth.ForgotPassword(connection, mails).ServeHTTP(rw, jsonRequest(t, http.MethodPost, "/password/forgot", auth.ForgotPasswordRequest{Email: email}))
+		return rw
+	}
+
+	reset := func(token string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		auth.ResetPassword(connection, tokenService, refreshTokens, newPasswordChecker()).ServeHTTP(rw, jsonRequest(t, http.MethodPost, "/password/reset", auth.ResetPasswordRequest{
+			Token:                token,
+			Password:             "new-password",
+			PasswordConfirmation: "new-password",
+		}))
+		return rw
+	}
+
+	t.Run("it_does_not_reveal_unknown_emails", func(t *testing.T) {
+		rw := forgot("unknown-reset@example.com")
+		if rw.Code != http.StatusAccepted {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusAccepted)
+		}
+
+		if len(mails.Sent("unknown-reset@example.com")) != 0 {
+			t.Error("A reset email has been sent to an unknown address")
+		}
+	})
+
+	t.Run("it_resets_the_password_once", func(t *testing.T) {
+		accessToken, _ := tokenService.CreateToken(&user)
+		refreshToken, _ := refreshTokens.Issue(&user)
+
+		if rw := forgot(email); rw.Code != http.StatusAccepted {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusAccepted)
+		}
+
+		token := tokenFromMessage(t, waitForMessages(t, mails, email, 1)[0])
+		if rw := reset(token); rw.Code != http.StatusOK {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+
+		stored := models.User{}
+		connection.Find(&stored, user.ID)
+		if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("new-password")) != nil {
+			t.Error("The password has not been changed")
+		}
+
+		if tokenService.IsValid(accessToken) {
+			t.Error("Access tokens issued before the reset are still valid")
+		}
+
+		if _, _, err := refreshTokens.Rotate(refreshToken); err == nil {
+			t.Error("Refresh tokens issued before the reset are still valid")
+		}
+
+		if rw := reset(token); rw.Code != http.StatusUnprocessableEntity {
+			t.Errorf("The reset token can be used twice. Received %d", rw.Code)
+		}
+	})
+
+	t.Run("only_the_latest_link_is_valid", func(t *testing.T) {
+		sent := mails.Sent(email)
+		forgot(email)
+		waitForMessages(t, mails, email, len(sent)+1)
+		forgot(email)
+		sent = waitForMessages(t, mails, email, len(sent)+2)
+
+		if rw := reset(tokenFromMessage(t, sent[len(sent)-2])); rw.Code != http.StatusUnprocessableEntity {
+			t.Errorf("A superseded reset token is still valid. Received %d", rw.Code)
+		}
+
+		if rw := reset(tokenFromMessage(t, sent[len(sent)-1])); rw.Code != http.StatusOK {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"site/database"
+	"site/http/handlers/auth"
+	"site/mailer"
+	"site/passwords"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := passwords.Policy{MinLength: 10, RequireUpper: true, RequireDigit: true, RequireSymbol: true, DisallowEmail: true}
+	checker := passwords.NewChecker(policy, nil)
+
+	cases := map[string]struct {
+		password string
+		expected []string
+	}{
+		"valid":           {"Correct-Horse-42", []string{}},
+		"short":           {"Sh0rt!", []string{passwords.ViolationMinLength}},
+		"classes":         {"lowercase only", []string{passwords.ViolationUpper, passwords.ViolationDigit}},
+		"email":           {"Jane.Doe@example.com1", []string{passwords.ViolationEmail}},
+		"local part":      {"1-JANE.DOE-password", []string{passwords.ViolationEmail}},
+		"bcrypt limit":    {"A1-" + strings.Repeat("x", 70), []string{passwords.ViolationMaxLength}},
+		"multibyte chars": {"Ünïcödé-Pässwörd-1", []string{}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			violations, err := checker.Validate(c.password, "jane.doe@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(violations, c.expected) {
+				t.Errorf("Unexpected violations %v; Expected %v", violations, c.expected)
+			}
+		})
+	}
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "5BAA6"), []byte("003D68EB55068C33ACE09247EE4C639306B:3\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9659365\n"), 0600)
+
+	file := filepath.Join(t.TempDir(), "hashes.txt")
+	ioutil.WriteFile(file, []byte("5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8:9659365\n"), 0600)
+
+	for _, path := range []string{dir, file} {
+		list, err := passwords.LoadBreachedList(path)
+		if err != nil {
+			t.Fatalf("Can not load %s %s", path, err)
+		}
+
+		if breached, _ := list.Contains("password"); !breached {
+			t.Errorf("%s: a breached password is accepted", path)
+		}
+
+		if breached, _ := list.Contains("Correct-Horse-42"); breached {
+			t.Errorf("%s: an unknown password is rejected", path)
+		}
+	}
+
+	list, _ := passwords.LoadBreachedList(dir)
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+
+	rw := httptest.NewRecorder()
+	checker := passwords.NewChecker(passwords.DefaultPolicy, list)
+	auth.Register(connection, newTokenService(t), mailer.NewInMemoryMailer(), checker).ServeHTTP(rw, jsonRequest(t, http.MethodPost, "/register", auth.PossibleUser{
+		Name:                 "breached@example.com",
+		Password:             "password",
+		PasswordConfirmation: "password",
+	}))
+
+	if rw.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+	}
+
+	errors := map[string]string{}
+	json.NewDecoder(rw.Body).Decode(&errors)
+	if errors["Password"] != passwords.ViolationBreached {
+		t.Errorf("Unexpected validation errors %v", errors)
+	}
+}
@@ -0,0 +1,163 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers"
+	"site/http/middlewares"
+	"site/mailer"
+	"site/policy"
+	"site/uploader"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+func TestRequirePermission(t *testing.T) {
+	ok := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	cases := []struct {
+		role   string
+		status int
+	}{
+		{models.RoleViewer, http.StatusForbidden},
+		{models.RoleOrganizer, http.StatusOK},
+		{models.RoleAdmin, http.StatusOK},
+		{"", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		t.Run("create_events_as_"+c.role, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodPost, "/event", nil)
+			r = authenticate(r, models.User{Role: c.role}, "")
+			rw := httptest.NewRecorder()
+
+			policy.RequirePermission(policy.CreateEvents)(ok).ServeHTTP(rw, r)
+
+			if rw.Code != c.status {
+				t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, c.status)
+			}
+		})
+	}
+
+	t.Run("it_returns_unauthorized_without_principal", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodPost, "/event", nil)
+		rw := httptest.NewRecorder()
+
+		policy.RequirePermission(policy.CreateEvents)(ok).ServeHTTP(rw, r)
+
+		if rw.Code != http.StatusUnauthorized {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestEventOwnershipPolicy(t *testing.T) {
+	owner := models.User{Model: gorm.Model{ID: 1}, Role: models.RoleOrganizer}
+	other := models.User{Model: gorm.Model{ID: 2}, Role: models.RoleOrganizer}
+	admin := models.User{Model: gorm.Model{ID: 3}, Role: models.RoleAdmin}
+	event := models.Event{Model: gorm.Model{ID: 10}, UserID: owner.ID}
+
+	if !policy.CanManageEvent(&middlewares.Principal{User: owner}, &event) {
+		t.Error("Owners can not manage their events")
+	}
+
+	if policy.CanManageEvent(&middlewares.Principal{User: other}, &event) {
+		t.Error("Other users can manage foreign events")
+	}
+
+	if !policy.CanManageEvent(&middlewares.Principal{User: admin}, &event) {
+		t.Error("Admins can not manage every event")
+	}
+
+	if policy.CanManageEvent(&middlewares.Principal{User: admin, APIKey: &models.APIKey{Scopes: string(policy.ReadEvents)}}, &event) {
+		t.Error("Admin keys can manage every event without the scope to do so")
+	}
+
+	if policy.CanManageMedia(&middlewares.Principal{User: owner}, &models.Media{EventId: 11}, &event) {
+		t.Error("Media of another event can be managed")
+	}
+
+	t.Run("only_owners_and_admins_can_upload_media", func(t *testing.T) {
+		connection, _ := database.NewTestDatabaseConnection()
+		database.RunMigrations(connection)
+
+		stored := models.Event{Name: "Ownership event", UserID: owner.ID}
+		connection.Save(&stored)
+
+		for _, c := range []struct {
+			user   models.User
+			status int
+		}{
+			{other, http.StatusForbidden},
+			// allowed users get past the policy and fail on the missing file
+			{owner, http.StatusUnprocessableEntity},
+			{admin, http.StatusUnprocessableEntity},
+		} {
+			r, _ := http.NewRequest(http.MethodPost, "/event/"+strconv.Itoa(int(stored.ID))+"/upload", nil)
+			r = authenticate(r, c.user, "")
+			rw := httptest.NewRecorder()
+
+			handlers.CreateMedia(connection, uploader.NewLocalUploader(), t.TempDir()+"/").ServeHTTP(rw, r)
+
+			if rw.Code != c.status {
+				t.Errorf("Unexpected status code for user %d. Received %d; Expected %d", c.user.ID, rw.Code, c.status)
+			}
+		}
+	})
+
+	t.Run("admin_keys_only_manage_other_events_with_the_scope", func(t *testing.T) {
+		connection, _ := database.NewTestDatabaseConnection()
+		database.RunMigrations(connection)
+
+		for _, c := range []struct {
+			scopes  []policy.Permission
+			allowed bool
+		}{
+			{[]policy.Permission{policy.ReadEvents}, false},
+			{[]policy.Permission{policy.ReadEvents, policy.CreateEvents}, false},
+			{[]policy.Permission{policy.CreateEvents, policy.ManageAnyEvent}, true},
+		} {
+			stored := models.Event{Name: "Scoped event", UserID: owner.ID}
+			connection.Save(&stored)
+			id := strconv.Itoa(int(stored.ID))
+
+			scopes := make([]string, len(c.scopes))
+			for i := range c.scopes {
+				scopes[i] = string(c.scopes[i])
+			}
+			principal := &middlewares.Principal{User: admin, APIKey: &models.APIKey{Scopes: strings.Join(scopes, ",")}}
+
+			for _, call := range []struct {
+				method  string
+				body    string
+				handler http.Handler
+				status  int
+			}{
+				{http.MethodPut, `{"name": "Changed by a key"}`, handlers.UpdateEvent(connection, mailer.NewInMemoryMailer()), http.StatusOK},
+				{http.MethodDelete, "", handlers.DeleteEvent(connection, uploader.NewLocalUploader(), handlers.RetainMedia), http.StatusNoContent},
+			} {
+				r, _ := http.NewRequest(call.method, "/event/"+id, strings.NewReader(call.body))
+				r = mux.SetURLVars(r, map[string]string{"event": id})
+				r = r.WithContext(middlewares.WithPrincipal(r.Context(), principal))
+				rw := httptest.NewRecorder()
+				call.handler.ServeHTTP(rw, r)
+
+				status := call.status
+				if !c.allowed {
+					status = http.StatusForbidden
+				}
+				if rw.Code != status {
+					t.Errorf("Unexpected status code for %s with %v. Received %d; Expected %d", call.method, c.scopes, rw.Code, status)
+				}
+			}
+		}
+	})
+}
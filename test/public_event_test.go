@@ -0,0 +1,165 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers"
+	"site/mailer"
+	"site/uploader"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPublicEvents(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	files := uploader.NewLocalUploader()
+
+	owner := models.User{Email: "public-events-owner@example.com", Password: "123456789"}
+	connection.Save(&owner)
+	stranger := models.User{Email: "public-events-stranger@example.com", Password: "123456789"}
+	connection.Save(&stranger)
+
+	router := mux.NewRouter()
+	router.Handle("/event", handlers.EventCreate(connection))
+	router.Handle("/event/{event}", handlers.GetEvent(connection)).Methods(http.MethodGet)
+	router.Handle("/event/{event}", handlers.UpdateEvent(connection, mailer.NewInMemoryMailer())).Methods(http.MethodPatch)
+	router.Handle("/event/{event}/upload", handlers.CreateMedia(connection, files, t.TempDir()+"/"))
+	router.Handle("/public/events/{slug}", handlers.PublicEvent(connection))
+	router.Handle("/public/events/{slug}/media/{media:[0-9]+}", handlers.PublicMedia(connection, files))
+
+	call := func(method string, path string, user *models.User, body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if user != nil {
+			r = authenticate(r, *user, "")
+		}
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, r)
+		return rw
+	}
+	create := func(name string, status string, visibility string) models.Event {
+		rw := call(http.MethodPost, "/event", &owner, `{"name": "`+name+`", "status": "`+status+`", "visibility": "`+visibility+`"}`)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+		event := models.Event{}
+		connection.Where("name = ?", name).First(&event)
+		return event
+	}
+
+	private := create("Public events private", models.EventPublished, "")
+	public := create("Public events public", models.EventPublished, models.EventPublic)
+	unlisted := create("Public events unlisted", models.EventPublished, models.EventUnlisted)
+	draft := create("Public events draft", models.EventDraft, models.EventUnlisted)
+
+	if private.Visibility != models.EventPrivate || private.ShareSlug != nil {
+		t.Errorf("Events are not private by default %+v", private)
+	}
+	if unlisted.ShareSlug == nil || len(*unlisted.ShareSlug) < 22 || public.ShareSlug == nil {
+		t.Fatalf("Shared events have no share link %+v %+v", unlisted, public)
+	}
+
+	// only public events are shown to every user
+	for _, c := range []struct {
+		event  models.Event
+		user   models.User
+		status int
+	}{
+		{private, stranger, http.StatusNotFound},
+		{private, owner, http.StatusOK},
+		{unlisted, stranger, http.StatusNotFound},
+		{public, stranger, http.StatusOK},
+	} {
+		if rw := call(http.MethodGet, "/event/"+strconv.Itoa(int(c.event.ID)), &c.user, ""); rw.Code != c.status {
+			t.Errorf("Unexpected status code for %s. Received %d; Expected %d", c.event.Name, rw.Code, c.status)
+		}
+	}
+
+	upload := func(event models.Event, name string, content string) models.Media {
+		body := bytes.Buffer{}
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("file", name)
+		part.Write([]byte(content))
+		form.Close()
+
+		r, _ := http.NewRequest(http.MethodPost, "/event/"+strconv.Itoa(int(event.ID))+"/upload", &body)
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, authenticate(r, owner, ""))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Unexpected status code for the upload. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+
+		uploaded := map[string]string{}
+		json.NewDecoder(rw.Body).Decode(&uploaded)
+		media := models.Media{}
+		connection.First(&media, uploaded["media_id"])
+		return media
+	}
+
+	photo := upload(unlisted, "photo.jpg", "image content")
+	page := upload(unlisted, "page.html", "<script></script>")
+	secret := upload(private, "secret.jpg", "private content")
+	if photo.Path == page.Path || photo.Path == secret.Path || !strings.HasSuffix(photo.Path, ".jpg") || strings.Contains(photo.Path, "photo") {
+		t.Errorf("Uploads are not stored under their own names %s %s %s", photo.Path, page.Path, secret.Path)
+	}
+	connection.Save(&models.EventAttachment{EventID: unlisted.ID, URL: "https://example.com/agenda.pdf"})
+
+	rw := call(http.MethodGet, "/public/events/"+*unlisted.ShareSlug, nil, "")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+	if body := rw.Body.String(); strings.Contains(body, "UserID") || strings.Contains(body, photo.Path) {
+		t.Errorf("The public event exposes private fields %s", body)
+	}
+	response := handlers.PublicEventResponse{}
+	json.NewDecoder(rw.Body).Decode(&response)
+	if response.Name != unlisted.Name || len(response.Attachments) != 1 || len(response.Media) != 2 || response.Media[0].Name != photo.Name || response.Media[1].Name != page.Name {
+		t.Fatalf("Unexpected public event %+v", response)
+	}
+
+	rw = call(http.MethodGet, response.Media[0].URL, nil, "")
+	if rw.Code != http.StatusOK || rw.Body.String() != "image content" || rw.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("Unexpected media %d %s %s", rw.Code, rw.Header().Get("Content-Type"), rw.Body.String())
+	}
+	rw = call(http.MethodGet, response.Media[1].URL, nil, "")
+	if !strings.HasPrefix(rw.Header().Get("Content-Disposition"), "attachment") || rw.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("A page is shown inline %s %s", rw.Header().Get("Content-Type"), rw.Header().Get("Content-Disposition"))
+	}
+
+	// media and events are only found through their own share link
+	for _, path := range []string{
+		"/public/events/" + *public.ShareSlug + "/media/" + strconv.Itoa(int(photo.ID)),
+		"/public/events/" + *draft.ShareSlug,
+		"/public/events/unknown",
+	} {
+		if rw := call(http.MethodGet, path, nil, ""); rw.Code != http.StatusNotFound {
+			t.Errorf("Unexpected status code for %s. Received %d; Expected %d", path, rw.Code, http.StatusNotFound)
+		}
+	}
+
+	// making the event private drops the link, sharing it again creates a new one
+	target := "/event/" + strconv.Itoa(int(unlisted.ID))
+	call(http.MethodPatch, target, &owner, `{"visibility": "private"}`)
+	if rw := call(http.MethodGet, "/public/events/"+*unlisted.ShareSlug, nil, ""); rw.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status code for a private event. Received %d; Expected %d", rw.Code, http.StatusNotFound)
+	}
+	if rw := call(http.MethodPatch, target, &owner, `{"visibility": "everyone"}`); rw.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Unexpected status code for an invalid visibility. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+	}
+	call(http.MethodPatch, target, &owner, `{"visibility": "unlisted"}`)
+
+	shared := models.Event{}
+	connection.First(&shared, unlisted.ID)
+	if shared.ShareSlug == nil || *shared.ShareSlug == *unlisted.ShareSlug {
+		t.Errorf("Sharing the event again has not created a new link %+v", shared)
+	}
+}
@@ -0,0 +1,210 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers"
+	"site/recurrence"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRecurrenceRules(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+
+	tests := []struct {
+		rule     string
+		start    time.Time
+		expected []string
+	}{
+		{
+			"FREQ=WEEKLY;BYDAY=TU,TH;COUNT=4",
+			time.Date(2031, 3, 4, 18, 0, 0, 0, newYork),
+			[]string{"2031-03-04T18:00:00-05:00", "2031-03-06T18:00:00-05:00", "2031-03-11T18:00:00-04:00", "2031-03-13T18:00:00-04:00"},
+		},
+		{
+			// 02:30 does not exist on the day clocks jump forward
+			"FREQ=DAILY;UNTIL=20310310",
+			time.Date(2031, 3, 8, 2, 30, 0, 0, newYork),
+			[]string{"2031-03-08T02:30:00-05:00", "2031-03-09T03:30:00-04:00", "2031-03-10T02:30:00-04:00"},
+		},
+		{
+			"FREQ=MONTHLY;BYDAY=-1FR;COUNT=3",
+			time.Date(2031, 1, 31, 9, 0, 0, 0, time.UTC),
+			[]string{"2031-01-31T09:00:00Z", "2031-02-28T09:00:00Z", "2031-03-28T09:00:00Z"},
+		},
+		{
+			"FREQ=MONTHLY;BYMONTHDAY=31;COUNT=3",
+			time.Date(2031, 1, 31, 9, 0, 0, 0, time.UTC),
+			[]string{"2031-01-31T09:00:00Z", "2031-03-31T09:00:00Z", "2031-05-31T09:00:00Z"},
+		},
+		{
+			"FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1;COUNT=3",
+			time.Date(2031, 1, 31, 9, 0, 0, 0, time.UTC),
+			[]string{"2031-01-31T09:00:00Z", "2031-02-28T09:00:00Z", "2031-03-31T09:00:00Z"},
+		},
+		{
+			"FREQ=YEARLY;BYMONTH=11;BYDAY=4TH;COUNT=2",
+			time.Date(2031, 11, 27, 12, 0, 0, 0, time.UTC),
+			[]string{"2031-11-27T12:00:00Z", "2032-11-25T12:00:00Z"},
+		},
+		{
+			"FREQ=YEARLY;COUNT=2",
+			time.Date(2032, 2, 29, 12, 0, 0, 0, time.UTC),
+			[]string{"2032-02-29T12:00:00Z", "2036-02-29T12:00:00Z"},
+		},
+		{
+			"FREQ=WEEKLY;INTERVAL=2;WKST=SU;BYDAY=TU,SU;COUNT=4",
+			time.Date(1997, 8, 5, 9, 0, 0, 0, newYork),
+			[]string{"1997-08-05T09:00:00-04:00", "1997-08-17T09:00:00-04:00", "1997-08-19T09:00:00-04:00", "1997-08-31T09:00:00-04:00"},
+		},
+	}
+
+	for _, test := range tests {
+		rule, err := recurrence.ParseRule(test.rule)
+		if err != nil {
+			t.Fatalf("Can not parse %s: %s", test.rule, err)
+		}
+
+		set := recurrence.Set{Start: test.start, Rule: &rule}
+		occurrences := set.Between(test.start.AddDate(-1, 0, 0), test.start.AddDate(10, 0, 0), 100)
+
+		received := []string{}
+		for _, occurrence := range occurrences {
+			received = append(received, occurrence.Format(time.RFC3339))
+		}
+		if strings.Join(received, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("Unexpected occurrences of %s. Received %v; Expected %v", test.rule, received, test.expected)
+		}
+	}
+
+	for _, invalid := range []string{"", "FREQ=HOURLY", "BYDAY=MO", "FREQ=DAILY;BYDAY=1MO", "FREQ=DAILY;COUNT=2;UNTIL=20310101", "FREQ=DAILY;BYHOUR=9"} {
+		if _, err := recurrence.ParseRule(invalid); err == nil {
+			t.Errorf("The invalid rule %q has been accepted", invalid)
+		}
+	}
+}
+
+func TestEventOccurrences(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+
+	owner := models.User{Email: "occurrences@example.com", Password: "123456789"}
+	connection.Save(&owner)
+	stranger := models.User{Email: "occurrences-stranger@example.com", Password: "123456789"}
+	connection.Save(&stranger)
+
+	router := mux.NewRouter()
+	router.Handle("/event", handlers.EventCreate(connection))
+	router.Handle("/event/{event}/occurrences", handlers.GetOccurrences(connection))
+	router.Handle("/event/{event}/occurrences/{occurrence}", handlers.Occurrence(connection))
+
+	call := func(method string, target string, user models.User, body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, target, strings.NewReader(body))
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, authenticate(r, user, ""))
+		return rw
+	}
+
+	if rw := call(http.MethodPost, "/event", owner, `{"name": "Broken meetup", "recurrence_rule": "FREQ=SOMETIMES"}`); rw.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Unexpected status code for an invalid rule. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+	}
+
+	rw := call(http.MethodPost, "/event", owner, `{
+		"name": "Weekly meetup",
+		"starts_at": "2031-03-04T18:00:00-05:00",
+		"ends_at": "2031-03-04T20:00:00-05:00",
+		"time_zone": "America/New_York",
+		"recurrence_rule": "freq=weekly;byday=TU",
+		"recurrence_dates": ["2031-03-07T23:00:00Z"],
+		"exception_dates": ["2031-03-18T22:00:00Z"]
+	}`)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d %s", rw.Code, http.StatusOK, rw.Body.String())
+	}
+
+	event := models.Event{}
+	connection.Where("user_id = ? AND name = ?", owner.ID, "Weekly meetup").First(&event)
+	if event.RecurrenceRule != "FREQ=WEEKLY;BYDAY=TU" || event.ExceptionDates != "20310318T220000Z" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	target := "/event/" + strconv.Itoa(int(event.ID)) + "/occurrences"
+
+	list := func() []handlers.OccurrenceResponse {
+		rw := call(http.MethodGet, target+"?from=2031-03-01&to=2031-04-01", owner, "")
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+		occurrences := []handlers.OccurrenceResponse{}
+		json.NewDecoder(rw.Body).Decode(&occurrences)
+		return occurrences
+	}
+
+	starts := func(occurrences []handlers.OccurrenceResponse) string {
+		received := []string{}
+		for _, occurrence := range occurrences {
+			received = append(received, occurrence.StartsAt.Format(time.RFC3339))
+		}
+		return strings.Join(received, " ")
+	}
+
+	// the time of day stays 18:00 in New York after DST begins on March 9th
+	occurrences := list()
+	expected := "2031-03-04T23:00:00Z 2031-03-07T23:00:00Z 2031-03-11T22:00:00Z 2031-03-25T22:00:00Z"
+	if starts(occurrences) != expected {
+		t.Fatalf("Unexpected occurrences %s; Expected %s", starts(occurrences), expected)
+	}
+	if occurrences[2].EndsAt == nil || occurrences[2].EndsAt.Sub(occurrences[2].StartsAt) != 2*time.Hour {
+		t.Errorf("Unexpected end %v", occurrences[2].EndsAt)
+	}
+
+	if rw := call(http.MethodGet, target+"?from=2031-01-01&to=2033-01-01", owner, ""); rw.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status code for a long window. Received %d; Expected %d", rw.Code, http.StatusBadRequest)
+	}
+
+	occurrence := target + "/" + url.PathEscape("2031-03-11T22:00:00Z")
+	if rw := call(http.MethodPut, occurrence, stranger, `{"cancelled": true}`); rw.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code for another user. Received %d; Expected %d", rw.Code, http.StatusForbidden)
+	}
+
+	if rw := call(http.MethodPut, target+"/2031-03-12T22:00:00Z", owner, `{"cancelled": true}`); rw.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status code for a time without occurrence. Received %d; Expected %d", rw.Code, http.StatusNotFound)
+	}
+
+	if rw := call(http.MethodPut, occurrence, owner, `{"cancelled": true}`); rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+
+	moved := target + "/2031-03-25T22:00:00Z"
+	rw = call(http.MethodPut, moved, owner, `{"name": "Moved meetup", "starts_at": "2031-04-02T22:00:00Z"}`)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d %s", rw.Code, http.StatusOK, rw.Body.String())
+	}
+
+	occurrences = list()
+	if starts(occurrences) != "2031-03-04T23:00:00Z 2031-03-07T23:00:00Z 2031-03-11T22:00:00Z" || !occurrences[2].Cancelled {
+		t.Errorf("Unexpected occurrences after the exceptions %+v", occurrences)
+	}
+
+	rw = call(http.MethodGet, target+"?from=2031-04-01&to=2031-04-05", owner, "")
+	occurrences = []handlers.OccurrenceResponse{}
+	json.NewDecoder(rw.Body).Decode(&occurrences)
+	if len(occurrences) != 2 || occurrences[1].Name != "Moved meetup" || !occurrences[1].Modified || occurrences[1].EndsAt.Format(time.RFC3339) != "2031-04-03T00:00:00Z" {
+		t.Errorf("Unexpected occurrences after moving one %+v", occurrences)
+	}
+
+	if rw := call(http.MethodDelete, occurrence, owner, ""); rw.Code != http.StatusNoContent {
+		t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusNoContent)
+	}
+	if occurrences := list(); len(occurrences) != 3 || occurrences[2].Cancelled {
+		t.Errorf("The cancelled occurrence has not been restored %+v", occurrences)
+	}
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers/auth"
+	"site/security"
+	"strings"
+	"testing"
+)
+
+func TestRefreshHandler(t *testing.T) {
+	tokenService := newTokenService(t)
+
+	refresh := func(handler http.Handler, token string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(auth.RefreshRequest{RefreshToken: token})
+		r, err := http.NewRequest(http.MethodPost, "/token/refresh", strings.NewReader(string(body)))
+		if err != nil {
+			t.Errorf("Can not create a request %s", err)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, r)
+		return rw
+	}
+
+	t.Run("it_rejects_unknown_refresh_tokens", func(t *testing.T) {
+		connection, _ := database.NewTestDatabaseConnection()
+		database.RunMigrations(connection)
+
+		handler := auth.Refresh(connection, tokenService, security.NewRefreshTokenService(connection))
+		rw := refresh(handler, "unknown")
+
+		if rw.Code != http.StatusUnauthorized {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("it_rotates_the_refresh_token", func(t *testing.T) {
+		connection, _ := database.NewTestDatabaseConnection()
+		database.RunMigrations(connection)
+		refreshTokens := security.NewRefreshTokenService(connection)
+
+		user := models.User{Email: "refresh@example.com", Password: "123456789"}
+		connection.Save(&user)
+
+		token, err := refreshTokens.Issue(&user)
+		if err != nil {
+			t.Errorf("Can not issue a refresh token %s", err)
+		}
+
+		rw := refresh(auth.Refresh(connection, tokenService, refreshTokens), token)
+		if rw.Code != http.StatusOK {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+
+		response := auth.TokenResponse{}
+		json.NewDecoder(rw.Body).Decode(&response)
+
+		if response.RefreshToken == "" || response.RefreshToken == token {
+			t.Error("The refresh token has not been rotated")
+		}
+
+		identifier, err := tokenService.GetIdentifier(response.Token)
+		if err != nil || identifier != user.ID {
+			t.Errorf("Unexpected access token identifier %d", identifier)
+		}
+	})
+
+	t.Run("it_revokes_the_token_family_when_a_rotated_token_is_reused", func(t *testing.T) {
+		connection, _ := database.NewTestDatabaseConnection()
+		database.RunMigrations(connection)
+		refreshTokens := security.NewRefreshTokenService(connection)
+		handler := auth.Refresh(connection, tokenService, refreshTokens)
+
+		user := models.User{Email: "reuse@example.com", Password: "123456789"}
+		connection.Save(&user)
+
+		token, _ := refreshTokens.Issue(&user)
+
+		rw := refresh(handler, token)
+		response := auth.TokenResponse{}
+		json.NewDecoder(rw.Body).Decode(&response)
+
+		rw = refresh(handler, token)
+		if rw.Code != http.StatusUnauthorized {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusUnauthorized)
+		}
+
+		rw = refresh(handler, response.RefreshToken)
+		if rw.Code != http.StatusUnauthorized {
+			t.Errorf("The rotated token is still usable. Received %d; Expected %d", rw.Code, http.StatusUnauthorized)
+		}
+	})
+}
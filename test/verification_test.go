@@ -0,0 +1,107 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"site/database"
+	"site/database/models"
+	"site/http/handlers/auth"
+	"site/mailer"
+	"site/security"
+	"testing"
+)
+
+func TestEmailVerification(t *testing.T) {
+	connection, _ := database.NewTestDatabaseConnection()
+	database.RunMigrations(connection)
+	tokenService := newTokenService(t)
+	mails := mailer.NewInMemoryMailer()
+	email := "verify@example.com"
+
+	rw := httptest.NewRecorder()
+	auth.Register(connection, tokenService, mails, newPasswordChecker()).ServeHTTP(rw, jsonRequest(t, http.MethodPost, "/register", auth.PossibleUser{
+		Name:                 email,
+		Password:             "test1234",
+		PasswordConfirmation: "test1234",
+	}))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+	}
+
+	login := func() *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		auth.Login(connection, tokenService, security.NewRefreshTokenService(connection), newLoginGuard()).ServeHTTP(rw, jsonRequest(t, http.MethodPost, "/login", auth.UserLogin{
+			Name:     email,
+			Password: "test1234",
+		}))
+		return rw
+	}
+
+	t.Run("it_mails_a_verification_link", func(t *testing.T) {
+		if len(mails.Sent(email)) != 1 {
+			t.Errorf("Unexpected number of verification emails %d", len(mails.Sent(email)))
+		}
+	})
+
+	t.Run("unverified_users_can_not_login", func(t *testing.T) {
+		rw := login()
+		if rw.Code != http.StatusForbidden {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusForbidden)
+		}
+
+		body := map[string]string{}
+		json.NewDecoder(rw.Body).Decode(&body)
+		if body["code"] != "email_not_verified" {
+			t.Errorf("Unexpected error code %s", body["code"])
+		}
+	})
+
+	t.Run("it_throttles_resending_the_link", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		auth.ResendVerification(connection, tokenService, mails).ServeHTTP(rw, jsonRequest(t, http.MethodPost, "/verify-email/resend", auth.ResendVerificationRequest{Email: email}))
+
+		if rw.Code != http.StatusTooManyRequests || rw.Header().Get("Retry-After") == "" {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusTooManyRequests)
+		}
+	})
+
+	t.Run("it_verifies_the_email_once", func(t *testing.T) {
+		token := tokenFromMessage(t, mails.Sent(email)[0])
+
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "/verify-email?token="+token, nil)
+		auth.VerifyEmail(connection, tokenService, mails).ServeHTTP(rw, r)
+		if rw.Code != http.StatusOK {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusOK)
+		}
+
+		rw = httptest.NewRecorder()
+		auth.VerifyEmail(connection, tokenService, mails).ServeHTTP(rw, jsonRequest(t, http.MethodPost, "/verify-email", auth.VerifyEmailRequest{Token: token}))
+		if rw.Code != http.StatusUnprocessableEntity {
+			t.Errorf("The verification token can be used twice. Received %d", rw.Code)
+		}
+
+		user := models.User{}
+		connection.Find(&user, "email=?", email)
+		if user.EmailVerifiedAt == nil {
+			t.Error("The user has not been verified")
+		}
+
+		if rw := login(); rw.Code != http.StatusOK {
+			t.Errorf("Verified users can not login. Received %d", rw.Code)
+		}
+	})
+
+	t.Run("access_tokens_are_not_verification_tokens", func(t *testing.T) {
+		user := models.User{}
+		connection.Find(&user, "email=?", email)
+		token, _ := tokenService.CreateToken(&user)
+
+		rw := httptest.NewRecorder()
+		auth.VerifyEmail(connection, tokenService, mails).ServeHTTP(rw, jsonRequest(t, http.MethodPost, "/verify-email", auth.VerifyEmailRequest{Token: token}))
+		if rw.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Unexpected status code. Received %d; Expected %d", rw.Code, http.StatusUnprocessableEntity)
+		}
+	})
+}
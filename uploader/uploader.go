@@ -8,6 +8,8 @@ import (
 
 type Uploader interface {
 	Upload(name string, path string, reader io.Reader) (string, error)
+	Open(path string) (io.ReadCloser, error)
+	Remove(path string) error
 }
 
 func NewLocalUploader() *LocalUploader {
@@ -16,6 +18,8 @@ func NewLocalUploader() *LocalUploader {
 
 type LocalUploader struct{}
 
+// Upload stores the content of the reader as the file name in the directory
+// path, creating the directory when needed.
 func (*LocalUploader) Upload(name string, path string, reader io.Reader) (string, error) {
 	fullPath := path + name
 
@@ -24,6 +28,10 @@ func (*LocalUploader) Upload(name string, path string, reader io.Reader) (string
 		return "", err
 	}
 
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return "", err
+	}
+
 	file, err := os.Create(fullPath)
 	if err != nil {
 		return "", err
@@ -37,3 +45,16 @@ func (*LocalUploader) Upload(name string, path string, reader io.Reader) (string
 
 	return fullPath, nil
 }
+
+// Open reads a file stored by Upload, path being the value Upload returned.
+func (*LocalUploader) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+// Remove deletes a file stored by Upload. Removing a missing file succeeds.
+func (*LocalUploader) Remove(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package validation
+
+import (
+	"reflect"
+	"site/recurrence"
+	"time"
+
+	"github.com/go-playground/validator"
+)
+
+// rules are the validations added to the built in ones.
+var rules = map[string]validator.Func{
+	"timezone": isTimeZone,
+	"paired":   isPaired,
+	"rrule":    isRecurrenceRule,
+	"datelist": isDateList,
+}
+
+// isTimeZone accepts IANA time zone names like "Europe/Berlin". "Local" is
+// rejected since it depends on the server.
+func isTimeZone(fl validator.FieldLevel) bool {
+	name := fl.Field().String()
+	if name == "" || name == "Local" {
+		return false
+	}
+
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// isPaired requires the field named by the parameter to be set as well, e.g.
+// a latitude needs a longitude. It is meant to follow omitempty.
+func isPaired(fl validator.FieldLevel) bool {
+	field, kind, ok := fl.GetStructFieldOK()
+	if !ok || kind == reflect.Invalid {
+		return false
+	}
+
+	switch kind {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return !field.IsNil()
+	}
+	return !field.IsZero()
+}
+
+// isRecurrenceRule accepts the RRULE values the recurrence package can expand.
+func isRecurrenceRule(fl validator.FieldLevel) bool {
+	_, err := recurrence.ParseRule(fl.Field().String())
+	return err == nil
+}
+
+// isDateList accepts a comma separated list of UTC date-times like
+// "20220301T180000Z".
+func isDateList(fl validator.FieldLevel) bool {
+	_, err := recurrence.ParseDates(fl.Field().String())
+	return err == nil
+}
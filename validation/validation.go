@@ -4,10 +4,21 @@ import (
 	"github.com/go-playground/validator"
 )
 
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	val := validator.New()
+	for tag, rule := range rules {
+		if err := val.RegisterValidation(tag, rule); err != nil {
+			panic(err)
+		}
+	}
+	return val
+}
+
 func Validate(item interface{}) map[string]string {
 	var fieldErrors map[string]string = map[string]string{}
-	val := validator.New()
-	err := val.Struct(item)
+	err := validate.Struct(item)
 	if err != nil {
 		for _, err := range err.(validator.ValidationErrors) {
 			fieldErrors[err.Field()] = err.Tag()